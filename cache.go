@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/nlopes/slack"
+	"github.com/spf13/cobra"
+)
+
+// Cache stores the responses of the read paths on disk, one file per query.
+type Cache struct {
+	dir string
+	ttl time.Duration
+	// offline serves everything from the cache regardless of the TTL.
+	offline bool
+}
+
+type cacheEntry struct {
+	Key   string          `json:"key"`
+	Time  time.Time       `json:"time"`
+	Value json.RawMessage `json:"value"`
+}
+
+func newCache(cfg CacheConfig, offline bool) (*Cache, error) {
+	c := &Cache{dir: cfg.Dir, offline: offline}
+	if len(cfg.TTL) > 0 {
+		ttl, err := time.ParseDuration(cfg.TTL)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cache ttl:%s", cfg.TTL)
+		}
+		c.ttl = ttl
+	}
+	return c, nil
+}
+
+// enabled returns whether the responses should be cached, the cache is
+// disabled if the TTL is not set.
+func (c *Cache) enabled() bool {
+	return c.ttl > 0 || c.offline
+}
+
+func (c *Cache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return path.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) get(key string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(c.file(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	var entry cacheEntry
+	if err = json.Unmarshal(data, &entry); err != nil {
+		return false, errors.Trace(err)
+	}
+	if entry.Key != key {
+		return false, nil
+	}
+	if !c.offline && time.Since(entry.Time) > c.ttl {
+		return false, nil
+	}
+	return true, errors.Trace(json.Unmarshal(entry.Value, v))
+}
+
+func (c *Cache) put(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := json.Marshal(cacheEntry{Key: key, Time: time.Now(), Value: value})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = os.MkdirAll(c.dir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ioutil.WriteFile(c.file(key), data, 0600))
+}
+
+func (c *Cache) remove(key string) error {
+	err := os.Remove(c.file(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+func (c *Cache) clear() error {
+	return errors.Trace(os.RemoveAll(c.dir))
+}
+
+// fetch reads v from the cache, or calls load to fill v and caches it.
+// The cache failures are only logged, since the cache is just an optimization.
+func (c *Cache) fetch(key string, v interface{}, load func() error) error {
+	ok, err := c.get(key, v)
+	if err != nil {
+		log.Warnf("read cache %s: %v", key, err)
+	} else if ok {
+		return nil
+	}
+
+	if c.offline {
+		return errors.NotFoundf("offline mode: %s in cache", key)
+	}
+	if err = load(); err != nil {
+		return err
+	}
+	if err = c.put(key, v); err != nil {
+		log.Warnf("write cache %s: %v", key, err)
+	}
+	return nil
+}
+
+func cacheKey(kind string, args ...string) string {
+	values := url.Values{}
+	for i := 0; i+1 < len(args); i += 2 {
+		values.Set(args[i], args[i+1])
+	}
+	return kind + "?" + values.Encode()
+}
+
+type cachedGithubSearcher struct {
+	GithubSearcher
+	cache *Cache
+}
+
+// githubSearchTimePattern matches the times in the search queries, like the
+// start of the daily report's last 24 hours.
+var githubSearchTimePattern = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})T\d{2}:\d{2}:\d{2}Z`)
+
+func (s cachedGithubSearcher) SearchIssues(ctx context.Context, query string, opt *github.SearchOptions) ([]github.Issue, int, error) {
+	// The searches are keyed by the day, or the key of a query relative to
+	// now changes every run and --offline never hits the cache.
+	dayQuery := githubSearchTimePattern.ReplaceAllString(query, "$1")
+	key := cacheKey("github/search", "q", dayQuery, "sort", opt.Sort, "order", opt.Order,
+		"page", fmt.Sprint(opt.Page), "per_page", fmt.Sprint(opt.PerPage))
+	var res struct {
+		Issues   []github.Issue `json:"issues"`
+		NextPage int            `json:"next_page"`
+	}
+	err := s.cache.fetch(key, &res, func() (err error) {
+		res.Issues, res.NextPage, err = s.GithubSearcher.SearchIssues(ctx, query, opt)
+		return err
+	})
+	return res.Issues, res.NextPage, err
+}
+
+func (s cachedGithubSearcher) ListReviews(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.PullRequestReview, int, error) {
+	key := cacheKey("github/reviews", "repo", owner+"/"+repo, "number", fmt.Sprint(number), "page", fmt.Sprint(opt.Page))
+	var res struct {
+		Reviews  []*github.PullRequestReview `json:"reviews"`
+		NextPage int                         `json:"next_page"`
+	}
+	err := s.cache.fetch(key, &res, func() (err error) {
+		res.Reviews, res.NextPage, err = s.GithubSearcher.ListReviews(ctx, owner, repo, number, opt)
+		return err
+	})
+	return res.Reviews, res.NextPage, err
+}
+
+func (s cachedGithubSearcher) ListTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.Timeline, int, error) {
+	key := cacheKey("github/timeline", "repo", owner+"/"+repo, "number", fmt.Sprint(number), "page", fmt.Sprint(opt.Page))
+	var res struct {
+		Events   []*github.Timeline `json:"events"`
+		NextPage int                `json:"next_page"`
+	}
+	err := s.cache.fetch(key, &res, func() (err error) {
+		res.Events, res.NextPage, err = s.GithubSearcher.ListTimeline(ctx, owner, repo, number, opt)
+		return err
+	})
+	return res.Events, res.NextPage, err
+}
+
+type cachedIssueSearcher struct {
+	IssueSearcher
+	cache *Cache
+}
+
+func (s cachedIssueSearcher) Search(jql string, opts *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	if opts == nil {
+		opts = &jira.SearchOptions{}
+	}
+	key := cacheKey("jira/search", "jql", jql, "startAt", fmt.Sprint(opts.StartAt), "maxResults", fmt.Sprint(opts.MaxResults),
+		"expand", opts.Expand, "fields", strings.Join(opts.Fields, ","))
+	var res struct {
+		Issues     []jira.Issue `json:"issues"`
+		StartAt    int          `json:"startAt"`
+		MaxResults int          `json:"maxResults"`
+		Total      int          `json:"total"`
+	}
+	var resp *jira.Response
+	err := s.cache.fetch(key, &res, func() (err error) {
+		res.Issues, resp, err = s.IssueSearcher.Search(jql, opts)
+		if resp != nil {
+			res.StartAt, res.MaxResults, res.Total = resp.StartAt, resp.MaxResults, resp.Total
+		}
+		return err
+	})
+	if err != nil {
+		// Keep the response for the rate limit handling.
+		return nil, resp, err
+	}
+	return res.Issues, &jira.Response{StartAt: res.StartAt, MaxResults: res.MaxResults, Total: res.Total}, nil
+}
+
+func (s cachedIssueSearcher) GetWorklogs(key string) (*jira.Worklog, error) {
+	var res jira.Worklog
+	err := s.cache.fetch(cacheKey("jira/worklog", "key", key), &res, func() error {
+		workLogs, err := s.IssueSearcher.GetWorklogs(key)
+		if workLogs != nil {
+			res = *workLogs
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (s cachedIssueSearcher) GetChangelog(key string) ([]jira.ChangelogHistory, error) {
+	var res []jira.ChangelogHistory
+	err := s.cache.fetch(cacheKey("jira/changelog", "key", key), &res, func() (err error) {
+		res, err = s.IssueSearcher.GetChangelog(key)
+		return err
+	})
+	return res, err
+}
+
+func (s cachedIssueSearcher) GetStatuses() ([]jira.Status, error) {
+	var res []jira.Status
+	err := s.cache.fetch(cacheKey("jira/statuses"), &res, func() (err error) {
+		res, err = s.IssueSearcher.GetStatuses()
+		return err
+	})
+	return res, err
+}
+
+// cachedContentStore drops the cached pages on writes, since the commands
+// read the pages they just created.
+type cachedContentStore struct {
+	ContentStore
+	cache *Cache
+}
+
+func contentTitleKey(space, title string) string {
+	return cacheKey("confluence/title", "space", space, "title", title)
+}
+
+func contentIDKey(id string) string {
+	return cacheKey("confluence/content", "id", id)
+}
+
+func (s cachedContentStore) GetContentByTitle(space string, title string) (Content, error) {
+	var res Content
+	err := s.cache.fetch(contentTitleKey(space, title), &res, func() (err error) {
+		res, err = s.ContentStore.GetContentByTitle(space, title)
+		return err
+	})
+	return res, err
+}
+
+func (s cachedContentStore) GetContent(id string) (Content, error) {
+	var res Content
+	err := s.cache.fetch(contentIDKey(id), &res, func() (err error) {
+		res, err = s.ContentStore.GetContent(id)
+		return err
+	})
+	return res, err
+}
+
+func (s cachedContentStore) invalidate(content Content) {
+	for _, key := range []string{contentTitleKey(content.Space.Key, content.Title), contentIDKey(content.Id)} {
+		if err := s.cache.remove(key); err != nil {
+			log.Warnf("remove cache %s: %v", key, err)
+		}
+	}
+}
+
+func (s cachedContentStore) CreateContent(content *Content) (Content, error) {
+	s.invalidate(*content)
+	return s.ContentStore.CreateContent(content)
+}
+
+func (s cachedContentStore) UpdateContent(content *Content) (Content, error) {
+	s.invalidate(*content)
+	return s.ContentStore.UpdateContent(content)
+}
+
+func (s cachedContentStore) DeleteContent(id string) error {
+	if c, ok := s.cachedContent(id); ok {
+		s.invalidate(c)
+	}
+	s.invalidate(Content{Id: id})
+	return s.ContentStore.DeleteContent(id)
+}
+
+// GetAttachments and GetChildPages are not cached, the pages are moved and the
+// charts are synced right after they're read.
+func (s cachedContentStore) GetAttachments(contentID string) ([]Attachment, error) {
+	if s.cache.offline {
+		return nil, errors.NotFoundf("offline mode: attachments of page %s", contentID)
+	}
+	return s.ContentStore.GetAttachments(contentID)
+}
+
+func (s cachedContentStore) GetChildPages(contentID string) ([]Content, error) {
+	if s.cache.offline {
+		return nil, errors.NotFoundf("offline mode: child pages of page %s", contentID)
+	}
+	return s.ContentStore.GetChildPages(contentID)
+}
+
+func (s cachedContentStore) cachedContent(id string) (Content, bool) {
+	var c Content
+	ok, err := s.cache.get(contentIDKey(id), &c)
+	return c, ok && err == nil
+}
+
+type cachedMessagePoster struct {
+	MessagePoster
+	cache *Cache
+}
+
+func (s cachedMessagePoster) GetUsers() ([]slack.User, error) {
+	var users []slack.User
+	err := s.cache.fetch(cacheKey("slack/users"), &users, func() (err error) {
+		users, err = s.MessagePoster.GetUsers()
+		return err
+	})
+	return users, err
+}
+
+// offlineSprintManager fails the sprint reads, the writes are printed by
+// the dry run which --offline implies.
+type offlineSprintManager struct {
+	SprintManager
+}
+
+func (s offlineSprintManager) GetAllBoards(opts *jira.BoardListOptions) (*jira.BoardsList, error) {
+	return nil, errors.NotFoundf("offline mode: boards")
+}
+
+func (s offlineSprintManager) GetAllSprints(boardID int, opts *jira.GetAllSprintsOptions) (*jira.SprintsList, error) {
+	return nil, errors.NotFoundf("offline mode: sprints of board %d", boardID)
+}
+
+var cache *Cache
+
+// useCache wraps the read paths, the sprint reads are never cached since
+// the sprint rotation must see the latest state, so they fail in the offline mode.
+func useCache(c *Cache) {
+	githubSearcher = cachedGithubSearcher{githubSearcher, c}
+	issueSearcher = cachedIssueSearcher{issueSearcher, c}
+	contentStore = cachedContentStore{contentStore, c}
+	messagePoster = cachedMessagePoster{messagePoster, c}
+	if c.offline {
+		sprintManager = offlineSprintManager{sprintManager}
+	}
+}
+
+func newCacheCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage The Response Cache",
+	}
+	m.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Clear The Response Cache",
+		Run:   runCacheClearCommandFunc,
+	})
+	return m
+}
+
+func runCacheClearCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("cache clear")
+	if !summary.Record(cache.dir, cache.clear()) {
+		fmt.Printf("cache %s is cleared\n", cache.dir)
+	}
+	summary.Exit()
+}
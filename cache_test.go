@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+)
+
+func TestCacheOffline(t *testing.T) {
+	gh, jr, cf, _ := setupFakes(t)
+	dir := t.TempDir()
+	page, err := createContent(config.Confluence.Space, "", "Weekly Reports", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run fills the cache.
+	online, err := newCache(CacheConfig{Dir: dir, TTL: "1h"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useCache(online)
+	start := testNow.Add(-24 * time.Hour).Format(githubUTCDateFormat)
+	gh.Issues[mentionedQuery("alice", start)] = []github.Issue{testPullRequest(1, "Fix the planner", "carol")}
+	issues, err := getPullReuestsMentioned(start, nil, "alice")
+	if err != nil || len(issues) != 1 {
+		t.Fatalf("online search: %v %v", issues, err)
+	}
+
+	// The offline run a few minutes later reads the same day from the cache.
+	githubSearcher, issueSearcher, sprintManager, contentStore = gh, jr, jr, cf
+	offlineCache, err := newCache(CacheConfig{Dir: dir}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useCache(offlineCache)
+	start = testNow.Add(5*time.Minute - 24*time.Hour).Format(githubUTCDateFormat)
+	issues, err = getPullReuestsMentioned(start, nil, "alice")
+	if err != nil || len(issues) != 1 {
+		t.Fatalf("offline search: %v %v", issues, err)
+	}
+	if len(gh.Queries) != 1 {
+		t.Errorf("offline search calls GitHub: %q", gh.Queries)
+	}
+
+	// The reads which are never cached fail fast.
+	if _, err = getSprints(1, "active"); !errors.IsNotFound(errors.Cause(err)) {
+		t.Errorf("offline sprints: %v", err)
+	}
+	if _, err = contentStore.GetChildPages(page.Id); !errors.IsNotFound(err) {
+		t.Errorf("offline child pages: %v", err)
+	}
+	if _, err = contentStore.GetAttachments(page.Id); !errors.IsNotFound(err) {
+		t.Errorf("offline attachments: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/juju/errors"
+)
+
+// The charts are rendered as SVG by hand, so they need no fonts or image libraries.
+
+// chartAttachmentPrefix names the attachments of the charts, the other
+// attachments with the prefix are stale charts and deleted.
+const chartAttachmentPrefix = "chart-"
+
+const (
+	chartWidth  = 720
+	chartHeight = 360
+	chartLeft   = 50
+	chartRight  = 150
+	chartTop    = 40
+	chartBottom = 60
+)
+
+var chartColors = []string{"#4c9aff", "#36b37e", "#ffab00", "#ff5630", "#6554c0", "#00b8d9", "#97a0af", "#ff8b00"}
+
+type ChartSeries struct {
+	Name string
+	// Values are of the labels, a line may have fewer values than the labels.
+	Values []float64
+}
+
+// Chart is a bar chart or a line chart of the series.
+type Chart struct {
+	// Name names the attachment.
+	Name   string
+	Title  string
+	Labels []string
+	Series []ChartSeries
+	// Stacked stacks the bars of the series instead of putting them side by side.
+	Stacked bool
+	// Line draws the series as lines.
+	Line bool
+}
+
+// FileName is the name of the attachment.
+func (c *Chart) FileName() string {
+	return chartAttachmentPrefix + c.Name + ".svg"
+}
+
+func (c *Chart) maxValue() float64 {
+	max := 0.0
+	for idx := range c.Labels {
+		sum := 0.0
+		for _, s := range c.Series {
+			if idx >= len(s.Values) {
+				continue
+			}
+			if c.Stacked {
+				sum += s.Values[idx]
+			} else {
+				sum = math.Max(sum, s.Values[idx])
+			}
+		}
+		max = math.Max(max, sum)
+	}
+	return max
+}
+
+// chartStep returns a 1, 2 or 5 times power of 10 step, so the axis has about 5 ticks.
+func chartStep(max float64) float64 {
+	if max <= 0 {
+		return 1
+	}
+	step := math.Pow(10, math.Floor(math.Log10(max/5)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if max/(step*m) <= 5 {
+			return math.Max(step*m, 1)
+		}
+	}
+	return step * 10
+}
+
+// SVG renders the chart.
+func (c *Chart) SVG() []byte {
+	var buf bytes.Buffer
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	step := chartStep(c.maxValue())
+	top := step * math.Max(1, math.Ceil(c.maxValue()/step))
+	y := func(v float64) float64 { return chartTop + plotHeight*(1-v/top) }
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`+"\n", chartWidth, chartHeight)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#ffffff"/>`+"\n", chartWidth, chartHeight)
+	fmt.Fprintf(&buf, `<text x="%d" y="20" font-size="14" font-weight="bold">%s</text>`+"\n", chartLeft, html.EscapeString(c.Title))
+	for v := 0.0; v <= top; v += step {
+		fmt.Fprintf(&buf, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#dfe1e6"/>`+"\n", chartLeft, y(v), chartLeft+plotWidth, y(v))
+		fmt.Fprintf(&buf, `<text x="%d" y="%.1f" text-anchor="end">%g</text>`+"\n", chartLeft-6, y(v)+4, v)
+	}
+
+	slot := plotWidth / float64(len(c.Labels))
+	if len(c.Labels) == 0 {
+		slot = plotWidth
+	}
+	center := func(idx int) float64 { return chartLeft + slot*(float64(idx)+0.5) }
+	for idx, label := range c.Labels {
+		x, ly := center(idx), chartHeight-chartBottom+16
+		if len(c.Labels) > 8 {
+			fmt.Fprintf(&buf, `<text x="%.1f" y="%d" text-anchor="end" transform="rotate(-30 %.1f %d)">%s</text>`+"\n", x, ly, x, ly, html.EscapeString(label))
+		} else {
+			fmt.Fprintf(&buf, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n", x, ly, html.EscapeString(label))
+		}
+	}
+
+	switch {
+	case c.Line:
+		for sIdx, s := range c.Series {
+			points := make([]string, 0, len(s.Values))
+			for idx, v := range s.Values {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", center(idx), y(v)))
+			}
+			fmt.Fprintf(&buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", strings.Join(points, " "), chartColors[sIdx%len(chartColors)])
+		}
+	case c.Stacked:
+		width := slot * 0.6
+		for idx := range c.Labels {
+			base := 0.0
+			for sIdx, s := range c.Series {
+				if idx >= len(s.Values) || s.Values[idx] == 0 {
+					continue
+				}
+				fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n",
+					center(idx)-width/2, y(base+s.Values[idx]), width, y(base)-y(base+s.Values[idx]), chartColors[sIdx%len(chartColors)])
+				base += s.Values[idx]
+			}
+		}
+	default:
+		width := slot * 0.8 / float64(len(c.Series))
+		for idx := range c.Labels {
+			left := center(idx) - slot*0.4
+			for sIdx, s := range c.Series {
+				if idx >= len(s.Values) {
+					continue
+				}
+				fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n",
+					left+width*float64(sIdx), y(s.Values[idx]), width, y(0)-y(s.Values[idx]), chartColors[sIdx%len(chartColors)])
+			}
+		}
+	}
+
+	for sIdx, s := range c.Series {
+		ly := chartTop + 18*sIdx
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`+"\n", chartWidth-chartRight+16, ly, chartColors[sIdx%len(chartColors)])
+		fmt.Fprintf(&buf, `<text x="%d" y="%d">%s</text>`+"\n", chartWidth-chartRight+34, ly+10, html.EscapeString(s.Name))
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// newStatusChart stacks the unresolved issues of every member by the status.
+func newStatusChart(team *Team) (*Chart, error) {
+	categories, err := getJiraStatusCategories()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	issues, err := queryJiraIssues(fmt.Sprintf(`assignee in (%s) AND resolution = Unresolved`, strings.Join(team.QuotedEmails(), ",")))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	chart := &Chart{Name: "status", Title: "Unresolved Jira issues by status", Stacked: true}
+	members := make(map[string]int)
+	for idx, member := range team.Members {
+		chart.Labels = append(chart.Labels, member.Name)
+		members[strings.ToLower(member.Email)] = idx
+	}
+	counts := make(map[string][]float64)
+	var statuses []string
+	for _, issue := range issues {
+		if issue.Fields == nil || issue.Fields.Assignee == nil || issue.Fields.Status == nil {
+			continue
+		}
+		idx, ok := members[strings.ToLower(issue.Fields.Assignee.EmailAddress)]
+		if !ok {
+			continue
+		}
+		status := issue.Fields.Status.Name
+		if _, ok := counts[status]; !ok {
+			counts[status] = make([]float64, len(team.Members))
+			statuses = append(statuses, status)
+		}
+		counts[status][idx]++
+	}
+	categories.order(statuses)
+	for _, status := range statuses {
+		chart.Series = append(chart.Series, ChartSeries{Name: status, Values: counts[status]})
+	}
+	return chart, nil
+}
+
+// newPullRequestThroughputChart counts the pull requests of the members
+// created and merged on every day of [start, end].
+func newPullRequestThroughputChart(team *Team, start time.Time, end time.Time) (*Chart, error) {
+	startDay, endDay := start.Format(dayFormat), end.Format(dayFormat)
+	created, err := getCreatedPullRequests(startDay, &endDay)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	merged, err := getMergedPullRequests(startDay, &endDay)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	chart := &Chart{Name: "pr-throughput", Title: "Pull requests of the team"}
+	days := make(map[string]int)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		days[day.Format(dayFormat)] = len(chart.Labels)
+		chart.Labels = append(chart.Labels, day.Format("01-02"))
+	}
+	count := func(name string, issues IssueSlice, at func(idx int) *time.Time) {
+		values := make([]float64, len(chart.Labels))
+		for idx, issue := range issues {
+			t := at(idx)
+			if issue.User == nil || t == nil || !team.IsMember(issue.User.GetLogin()) {
+				continue
+			}
+			if day, ok := days[t.In(start.Location()).Format(dayFormat)]; ok {
+				values[day]++
+			}
+		}
+		chart.Series = append(chart.Series, ChartSeries{Name: name, Values: values})
+	}
+	count("Created", created, func(idx int) *time.Time { return created[idx].CreatedAt })
+	// The merged pull requests are closed when they are merged.
+	count("Merged", merged, func(idx int) *time.Time { return merged[idx].ClosedAt })
+	return chart, nil
+}
+
+// newBurndownChart draws the unresolved issues of the members in the active
+// sprint on every day, it returns nil if there is no active sprint.
+func newBurndownChart(team *Team, now time.Time) (*Chart, error) {
+	boardID, err := getBoardID(config.Jira.Project, "scrum", config.Sprint.Board)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sprints, err := getSprints(boardID, "active")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(sprints) == 0 {
+		return nil, nil
+	}
+	return newSprintBurndownChart(team, sprints[0], now)
+}
+
+// newSprintBurndownChart draws the unresolved issues of the members in the
+// sprint on every day until now, it returns nil if the sprint has no dates.
+func newSprintBurndownChart(team *Team, sprint jira.Sprint, now time.Time) (*Chart, error) {
+	if sprint.StartDate == nil || sprint.EndDate == nil {
+		return nil, nil
+	}
+	issues, err := queryJiraIssues(fmt.Sprintf(`Sprint = %d AND assignee in (%s)`, sprint.ID, strings.Join(team.QuotedEmails(), ",")))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	loc := config.Sprint.location()
+	start, end := sprint.StartDate.In(loc), sprint.EndDate.In(loc)
+	first := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	chart := &Chart{Name: "burndown", Title: "Burndown of sprint " + sprint.Name, Line: true}
+	var days []time.Time
+	for day := first; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+		chart.Labels = append(chart.Labels, day.Format("01-02"))
+	}
+
+	total := float64(len(issues))
+	remaining := ChartSeries{Name: "Remaining"}
+	ideal := ChartSeries{Name: "Ideal"}
+	for idx, day := range days {
+		if len(days) > 1 {
+			ideal.Values = append(ideal.Values, total*(1-float64(idx)/float64(len(days)-1)))
+		} else {
+			ideal.Values = append(ideal.Values, total)
+		}
+		if day.After(now) {
+			continue
+		}
+		left := 0.0
+		for _, issue := range issues {
+			resolved := time.Time(issue.Fields.Resolutiondate)
+			if resolved.IsZero() || !resolved.Before(day.AddDate(0, 0, 1)) {
+				left++
+			}
+		}
+		remaining.Values = append(remaining.Values, left)
+	}
+	chart.Series = []ChartSeries{remaining, ideal}
+	return chart, nil
+}
+
+// collectWeeklyCharts collects the charts of the week ending now, the failed
+// charts are recorded to summary and skipped.
+func collectWeeklyCharts(team *Team, now time.Time, summary *RunSummary) []*Chart {
+	var charts []*Chart
+	add := func(name string, chart *Chart, err error) {
+		if !summary.Record(team.Name+" "+name+" chart", err) && chart != nil {
+			charts = append(charts, chart)
+		}
+	}
+	chart, err := newBurndownChart(team, now)
+	add("burndown", chart, err)
+	chart, err = newStatusChart(team)
+	add("status", chart, err)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	chart, err = newPullRequestThroughputChart(team, today.AddDate(0, 0, -6), today)
+	add("pull request", chart, err)
+	return charts
+}
+
+// genWeeklyReportCharts embeds the charts, they are uploaded as the attachments after the page is saved.
+func genWeeklyReportCharts(buf *bytes.Buffer, charts []*Chart) {
+	if len(charts) == 0 {
+		return
+	}
+	formatSectionBeginForHtmlOutput(buf)
+	buf.WriteString("\n<h1>Charts</h1>\n")
+	for _, chart := range charts {
+		fmt.Fprintf(buf, `<p><ac:image ac:width="%d"><ri:attachment ri:filename="%s" /></ac:image></p>`+"\n", chartWidth, html.EscapeString(chart.FileName()))
+	}
+	formatSectionEndForHtmlOutput(buf)
+}
+
+// uploadCharts attaches the charts to the page, and deletes the charts no longer generated.
+func uploadCharts(contentID string, charts []*Chart) error {
+	files := make([]attachmentFile, 0, len(charts))
+	for _, chart := range charts {
+		files = append(files, attachmentFile{Name: chart.FileName(), MediaType: "image/svg+xml", Data: chart.SVG()})
+	}
+	return errors.Trace(syncAttachments(contentID, chartAttachmentPrefix, files))
+}
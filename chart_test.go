@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestChartStep(t *testing.T) {
+	tests := []struct {
+		max  float64
+		want float64
+	}{
+		{max: 0, want: 1},
+		// The step is never less than 1, the values are counts.
+		{max: 0.5, want: 1},
+		{max: 3, want: 1},
+		{max: 5, want: 1},
+		{max: 7, want: 2},
+		{max: 12, want: 5},
+		{max: 100, want: 20},
+		{max: 2600, want: 1000},
+	}
+	for _, tt := range tests {
+		if got := chartStep(tt.max); got != tt.want {
+			t.Errorf("chartStep(%g) = %g, want %g", tt.max, got, tt.want)
+		}
+	}
+}
+
+// svgElements counts the elements of the SVG by the name, it fails if the SVG isn't well-formed.
+func svgElements(t *testing.T, svg []byte) map[string]int {
+	counts := make(map[string]int)
+	decoder := xml.NewDecoder(strings.NewReader(string(svg)))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return counts
+		}
+		if err != nil {
+			t.Fatalf("malformed SVG %v:\n%s", err, svg)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			counts[start.Name.Local]++
+		}
+	}
+}
+
+func TestChartSVG(t *testing.T) {
+	series := []ChartSeries{{Name: "A", Values: []float64{1, 2}}, {Name: "B & C", Values: []float64{0, 3}}}
+	tests := []struct {
+		name  string
+		chart Chart
+		// rects count the background, the bars and the legends.
+		rects     int
+		polylines int
+		// top is the label of the top tick.
+		top string
+	}{
+		{name: "bars", chart: Chart{Labels: []string{"x", "y"}, Series: series}, rects: 1 + 4 + 2, top: "3"},
+		// The zero values aren't stacked.
+		{name: "stacked", chart: Chart{Labels: []string{"x", "y"}, Series: series, Stacked: true}, rects: 1 + 3 + 2, top: "5"},
+		{name: "lines", chart: Chart{Labels: []string{"x", "y", "z"}, Series: series, Line: true}, rects: 1 + 2, polylines: 2, top: "3"},
+		{name: "empty", chart: Chart{}, rects: 1, top: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.chart.Title = "<Title>"
+			svg := tt.chart.SVG()
+			counts := svgElements(t, svg)
+			if counts["rect"] != tt.rects || counts["polyline"] != tt.polylines {
+				t.Errorf("%d rects and %d polylines, want %d and %d:\n%s", counts["rect"], counts["polyline"], tt.rects, tt.polylines, svg)
+			}
+			for _, want := range []string{"&lt;Title&gt;", ">" + tt.top + "</text>"} {
+				if !strings.Contains(string(svg), want) {
+					t.Errorf("SVG doesn't contain %q:\n%s", want, svg)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncAttachments(t *testing.T) {
+	_, _, cf, _ := setupFakes(t)
+	page := &Content{Type: "page", Title: "Page"}
+	c, err := cf.CreateContent(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"chart-kept.svg", "chart-stale.svg", "photo.png"} {
+		if _, err = cf.CreateAttachment(c.Id, attachmentFile{Name: name, Data: []byte("old")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = syncAttachments(c.Id, chartAttachmentPrefix, []attachmentFile{
+		{Name: "chart-kept.svg", Data: []byte("new")},
+		{Name: "chart-new.svg", Data: []byte("new")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stale chart is deleted, the attachments without the prefix are kept.
+	want := map[string]string{"chart-kept.svg": "new", "photo.png": "old", "chart-new.svg": "new"}
+	attachments := cf.Attachments[c.Id]
+	if len(attachments) != len(want) {
+		t.Errorf("attachments %+v", attachments)
+	}
+	for _, a := range attachments {
+		if data, ok := want[a.Title]; !ok || string(cf.AttachmentData[a.Id]) != data {
+			t.Errorf("attachment %s is %q", a.Title, cf.AttachmentData[a.Id])
+		}
+	}
+
+	if err = syncAttachments("404", chartAttachmentPrefix, nil); err == nil {
+		t.Error("synced the attachments of a missing page")
+	}
+}
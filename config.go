@@ -10,6 +10,12 @@ type Slack struct {
 	Token   string `toml:"token"`
 	Channel string `toml:"channel"`
 	User    string `toml:"user"`
+	// Blocks posts the reports as Block Kit messages.
+	Blocks bool `toml:"blocks"`
+	// Thread posts a summary of the Block Kit report, and the sections as the replies in its thread.
+	Thread bool `toml:"thread"`
+	// SigningSecret verifies the slash commands of the serve command.
+	SigningSecret string `toml:"signing-secret"`
 }
 
 type Jira struct {
@@ -20,9 +26,18 @@ type Jira struct {
 	Server   string `toml:"server"`
 	Project  string `toml:"project"`
 	OnCall   string `toml:"oncall"`
-	//NonProcessStatus     string `toml:"non-process-status"`
+	// The issues in any of the statuses or the status categories are in progress,
+	// the statusCategory "indeterminate" is used if neither is set.
+	InProgressStatuses         []string `toml:"in-progress-statuses"`
+	InProgressStatusCategories []string `toml:"in-progress-status-categories"`
+	// DueSoonDays is the horizon of the due date sections of the daily report.
+	DueSoonDays          int    `toml:"due-soon-days"`
 	WeeklyPersonalIssues string `toml:"weekly-personal-issues-jql"`
+	// EpicLinkField is the custom field of the epic link, like "customfield_10008",
+	// the epic field of the issue is used if it's not set.
+	EpicLinkField string `toml:"epic-link-field"`
 	//FinishedStatus       string `toml:"finished-status"`
+	// TimeTrackingDayHours is the hours of a time tracking day, 8 by default.
 	TimeTrackingDayHours int `toml:"timetracking-day-hours"`
 }
 
@@ -30,16 +45,34 @@ type Member struct {
 	Name   string `json:"name"`
 	Github string `json:"github"`
 	Email  string `json:"email"`
+	// SlackDigest sends the member's own part of the daily report by Slack DM.
+	SlackDigest bool `toml:"slack-digest"`
 }
 
 type Team struct {
 	Name    string   `json:"name"`
 	Members []Member `json:"members"`
+
+	// The settings below fall back to the global ones if not set.
+	SlackChannel      string `toml:"slack-channel"`
+	WeeklyPath        string `toml:"weekly-path"`
+	WeeklyDueDatePath string `toml:"weekly-due-date-path"`
+	// ExternalLabel tags the issues and pull requests from people outside the team.
+	ExternalLabel string `toml:"external-label"`
 }
 
 type Github struct {
 	Token string   `json:"token"`
 	Repos []string `json:"repos"`
+	// WebhookSecret verifies the webhook deliveries of the serve command.
+	WebhookSecret string `toml:"webhook-secret"`
+	// Events reads the mentions, reviews and new issues from the received
+	// webhook events instead of the search API.
+	Events bool `toml:"events"`
+	// EventsDir is ~/.work-reporter/events by default.
+	EventsDir string `toml:"events-dir"`
+	// EventsRetentionDays is how long the events are kept, 90 days by default.
+	EventsRetentionDays int `toml:"events-retention-days"`
 }
 
 type Confluence struct {
@@ -50,6 +83,62 @@ type Confluence struct {
 	Space             string `toml:"space"`
 	WeeklyPath        string `toml:"weekly-path"`
 	WeeklyDueDatePath string `toml:"weekly-due-date-path"`
+	// Charts attaches the burndown, the issue status and the pull request charts to the weekly dead-line pages.
+	// The burndown of the closed sprint is also attached to a page linked in the sprint rotation report.
+	Charts bool `toml:"charts"`
+	// ReviewLatency adds the pull request review latency of the week to the weekly
+	// dead-line pages and the team's date pages.
+	ReviewLatency bool `toml:"review-latency"`
+	// Labels are added to the generated pages, {team}, {kind}, {week} and {member} in them are replaced.
+	Labels       []string               `toml:"labels"`
+	Restrictions ConfluenceRestrictions `toml:"restrictions"`
+}
+
+// ConfluenceRestrictions are the users and the groups who can view or edit the
+// generated pages, an operation is not restricted if it has neither.
+type ConfluenceRestrictions struct {
+	ViewUsers  []string `toml:"view-users"`
+	ViewGroups []string `toml:"view-groups"`
+	EditUsers  []string `toml:"edit-users"`
+	EditGroups []string `toml:"edit-groups"`
+}
+
+type Sprint struct {
+	// Board is the name of the scrum board, it can be omitted if the project has only one scrum board.
+	Board string `toml:"board"`
+	Days  int    `toml:"days"`
+	// Name is the sprint name pattern, {start} and {end} are replaced by the first and last day of the sprint.
+	Name     string `toml:"name"`
+	Timezone string `toml:"timezone"`
+}
+
+type CacheConfig struct {
+	// Dir is ~/.work-reporter/cache by default.
+	Dir string `toml:"dir"`
+	// TTL is a duration like "30m", the cache is disabled if it's empty.
+	TTL string `toml:"ttl"`
+}
+
+type HistoryConfig struct {
+	// Dir is ~/.work-reporter/history by default.
+	Dir string `toml:"dir"`
+}
+
+type Release struct {
+	// Parent is the title of the Confluence page the release pages are created under,
+	// the release report is printed if it's not set.
+	Parent string `toml:"parent"`
+	// NoteLabels are the groups of the release notes in order, a merged pull request
+	// is in the group of the first label it has, or in "Others".
+	NoteLabels []string `toml:"note-labels"`
+	// SkipLabels leave the pull requests out of the release notes, e.g. "release-note-none".
+	SkipLabels []string `toml:"skip-labels"`
+}
+
+type Metrics struct {
+	// Parent is the title of the Confluence page the Jira metrics and the timesheet
+	// pages are created under, they are printed if it's not set.
+	Parent string `toml:"parent"`
 }
 
 type IssueLink struct {
@@ -59,12 +148,17 @@ type IssueLink struct {
 }
 
 type Config struct {
-	Slack      Slack       `toml:"slack"`
-	Jira       Jira        `toml:"jira"`
-	Confluence Confluence  `toml:"confluence"`
-	Github     Github      `toml:"github"`
-	Teams      []Team      `toml:"teams"`
-	IssueLinks []IssueLink `toml:"issue-links"`
+	Slack      Slack         `toml:"slack"`
+	Jira       Jira          `toml:"jira"`
+	Confluence Confluence    `toml:"confluence"`
+	Github     Github        `toml:"github"`
+	Sprint     Sprint        `toml:"sprint"`
+	Cache      CacheConfig   `toml:"cache"`
+	History    HistoryConfig `toml:"history"`
+	Teams      []Team        `toml:"teams"`
+	Release    Release       `toml:"release"`
+	Metrics    Metrics       `toml:"metrics"`
+	IssueLinks []IssueLink   `toml:"issue-links"`
 }
 
 // NewConfigFromFile creates the configuration from file
@@ -4,11 +4,21 @@ import (
 	"fmt"
 	"net/url"
 	"reflect"
+	"regexp"
+	"strings"
 
 	"github.com/google/go-querystring/query"
 	"github.com/juju/errors"
+	"github.com/ngaut/log"
 )
 
+// contentUpdateRetries is how many times an update is retried on version conflicts.
+const contentUpdateRetries = 3
+
+// errContentVersionConflict is returned by ContentStore.UpdateContent if the
+// page has been updated since it was fetched.
+var errContentVersionConflict = errors.New("confluence content version conflict")
+
 type Ancestor struct {
 	Id string `json:"id,omitempty"`
 }
@@ -34,7 +44,47 @@ type Content struct {
 	Space struct {
 		Key string `json:"key,omitempty"`
 	} `json:"space,omitempty"`
-	Ancestors []Ancestor `json:"ancestors,omitempty"`
+	Ancestors []Ancestor      `json:"ancestors,omitempty"`
+	History   *ContentHistory `json:"history,omitempty"`
+}
+
+type ContentHistory struct {
+	CreatedDate string `json:"createdDate,omitempty"`
+}
+
+// ContentRestriction restricts an operation, "read" or "update", of a page
+// to the users and the groups.
+type ContentRestriction struct {
+	Operation    string `json:"operation"`
+	Restrictions struct {
+		User  []RestrictionUser  `json:"user"`
+		Group []RestrictionGroup `json:"group"`
+	} `json:"restrictions"`
+}
+
+type RestrictionUser struct {
+	Type     string `json:"type"`
+	Username string `json:"username"`
+}
+
+type RestrictionGroup struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// Attachment is a file attached to a page.
+type Attachment struct {
+	Id      string `json:"id,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Version struct {
+		Number int `json:"number,omitempty"`
+	} `json:"version,omitempty"`
+}
+
+type attachmentFile struct {
+	Name      string
+	MediaType string
+	Data      []byte
 }
 
 func addOptions(s string, opt interface{}) (string, error) {
@@ -57,52 +107,25 @@ func addOptions(s string, opt interface{}) (string, error) {
 	return u.String(), nil
 }
 
-func getContentByTitle(space string, title string) Content {
-	opts := struct {
-		Title    string `url:"title"`
-		SpaceKey string `url:"spaceKey"`
-		Expand   string `url:"expand"`
-	}{
-		Title:    title,
-		SpaceKey: space,
-		Expand:   "body.storage,version.number,space.key",
-	}
-
-	apiEndpoint := "rest/api/content"
-	url, err := addOptions(apiEndpoint, opts)
-	perror(errors.Trace(err))
-
-	req, err := conflunceClient.NewRequest("GET", url, nil)
-	perror(errors.Trace(err))
-
-	res := struct {
-		Results []Content `json:"results"`
-	}{}
-
-	_, err = conflunceClient.Do(req, &res)
-	perror(errors.Trace(err))
-
-	if len(res.Results) == 0 {
-		return Content{}
+func getContentByTitle(space string, title string) (Content, error) {
+	content, err := contentStore.GetContentByTitle(space, title)
+	if err != nil {
+		return Content{}, errors.Annotatef(err, "title:%s", title)
 	}
 
-	return res.Results[0]
+	return content, nil
 }
 
-func getContent(id string) Content {
-	apiEndpoint := fmt.Sprintf("rest/api/content/%s?expand=body.storage,version.number,space.key", id)
-
-	req, err := conflunceClient.NewRequest("GET", apiEndpoint, nil)
-	perror(errors.Trace(err))
-
-	var content Content
-	_, err = conflunceClient.Do(req, &content)
-	perror(errors.Trace(err))
+func getContent(id string) (Content, error) {
+	content, err := contentStore.GetContent(id)
+	if err != nil {
+		return Content{}, errors.Annotatef(err, "content:%s", id)
+	}
 
-	return content
+	return content, nil
 }
 
-func createContent(space string, parentID string, title string, value string) Content {
+func createContent(space string, parentID string, title string, value string) (Content, error) {
 	content := Content{
 		Type:  "page",
 		Title: title,
@@ -115,18 +138,66 @@ func createContent(space string, parentID string, title string, value string) Co
 	content.Body.Storage.Value = value
 	content.Body.Storage.Representation = "storage"
 
-	apiEndpoint := "rest/api/content"
+	respContent, err := contentStore.CreateContent(&content)
+	if err != nil {
+		return Content{}, errors.Annotatef(err, "title:%s", title)
+	}
+	return respContent, nil
+}
+
+// updateContent replaces the body of the page.
+func updateContent(content Content, value string) (Content, error) {
+	return mergeContent(content, func(string) string { return value })
+}
 
-	req, err := conflunceClient.NewRequest("POST", apiEndpoint, &content)
-	perror(errors.Trace(err))
+// mergeContent updates the page to the body merged from its current body, the
+// page is fetched and merged again if someone else updates it meanwhile.
+// Nothing is updated if the body doesn't change.
+func mergeContent(content Content, merge func(old string) string) (Content, error) {
+	for retry := 0; ; retry++ {
+		value := merge(content.Body.Storage.Value)
+		if value == content.Body.Storage.Value {
+			return content, nil
+		}
+		updated, err := putContent(content, value)
+		if errors.Cause(err) != errContentVersionConflict || retry >= contentUpdateRetries {
+			return updated, err
+		}
+		log.Warnf("page %q is updated by someone else, fetch it and retry", content.Title)
+		if content, err = getContent(content.Id); err != nil {
+			return Content{}, errors.Trace(err)
+		}
+	}
+}
 
-	var respContent Content
-	_, err = conflunceClient.Do(req, &respContent)
-	perror(errors.Trace(err))
-	return respContent
+// publishGeneratedPage creates the page under the parent page, or replaces only
+// the generated regions of the body if the page exists, and applies the page
+// settings of the kind. The team is nil for the pages of no team.
+func publishGeneratedPage(team *Team, kind string, parentTitle string, title string, body string) error {
+	space := config.Confluence.Space
+	c, err := getContentByTitle(space, title)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if c.Id != "" {
+		c, err = mergeGeneratedContent(c, body)
+	} else {
+		var parent Content
+		if parent, err = getContentByTitle(space, parentTitle); err != nil {
+			return errors.Trace(err)
+		}
+		if len(parent.Id) == 0 {
+			return errors.NotFoundf("page %q", parentTitle)
+		}
+		c, err = createContent(space, parent.Id, title, body)
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(applyPageSettings(c, team, kind, ""))
 }
 
-func updateContent(content Content, value string) Content {
+func putContent(content Content, value string) (Content, error) {
 	newContent := Content{
 		Id:    content.Id,
 		Type:  "page",
@@ -138,24 +209,182 @@ func updateContent(content Content, value string) Content {
 	newContent.Body.Storage.Representation = "storage"
 	newContent.Version.Number = content.Version.Number + 1
 
-	apiEndpoint := "rest/api/content/" + content.Id
+	respContent, err := contentStore.UpdateContent(&newContent)
+	if err != nil {
+		return Content{}, errors.Annotatef(err, "title:%s", content.Title)
+	}
+
+	return respContent, nil
+}
+
+// moveContent moves the page under the parent, the children of the page are moved along.
+func moveContent(id string, parentID string) (Content, error) {
+	content, err := getContent(id)
+	if err != nil {
+		return Content{}, errors.Trace(err)
+	}
+	newContent := Content{
+		Id:        content.Id,
+		Type:      "page",
+		Title:     content.Title,
+		Ancestors: []Ancestor{{Id: parentID}},
+	}
+	newContent.Space.Key = content.Space.Key
+	newContent.Body.Storage.Value = content.Body.Storage.Value
+	newContent.Body.Storage.Representation = "storage"
+	newContent.Version.Number = content.Version.Number + 1
+
+	respContent, err := contentStore.UpdateContent(&newContent)
+	if err != nil {
+		return Content{}, errors.Annotatef(err, "move title:%s", content.Title)
+	}
+	return respContent, nil
+}
+
+func deleteContent(id string) error {
+	err := contentStore.DeleteContent(id)
+	return errors.Annotatef(err, "content:%s", id)
+}
+
+// syncAttachments uploads the files to the page, the attachments of the same
+// names are updated, and the other attachments whose names have the prefix are deleted.
+func syncAttachments(contentID string, prefix string, files []attachmentFile) error {
+	attachments, err := contentStore.GetAttachments(contentID)
+	if err != nil {
+		return errors.Annotatef(err, "attachments of content:%s", contentID)
+	}
+	existing := make(map[string]Attachment, len(attachments))
+	for _, attachment := range attachments {
+		existing[attachment.Title] = attachment
+	}
+
+	uploaded := make(map[string]bool, len(files))
+	for _, file := range files {
+		uploaded[file.Name] = true
+		if attachment, ok := existing[file.Name]; ok {
+			_, err = contentStore.UpdateAttachment(contentID, attachment.Id, file)
+		} else {
+			_, err = contentStore.CreateAttachment(contentID, file)
+		}
+		if err != nil {
+			return errors.Annotatef(err, "attachment:%s", file.Name)
+		}
+	}
+	for _, attachment := range attachments {
+		if strings.HasPrefix(attachment.Title, prefix) && !uploaded[attachment.Title] {
+			if err = contentStore.DeleteAttachment(attachment.Id); err != nil {
+				return errors.Annotatef(err, "attachment:%s", attachment.Title)
+			}
+		}
+	}
+	return nil
+}
+
+// The generated regions of a page are wrapped in a pair of anchor macros, so a
+// re-run replaces only the regions and keeps the rest of the page as it is.
+const generatedAnchorPrefix = "work-reporter-"
+
+var generatedAnchorPattern = regexp.MustCompile(`<ac:structured-macro[^>]*ac:name="anchor"[^>]*>\s*` +
+	`<ac:parameter ac:name="">\s*` + generatedAnchorPrefix + `([\w-]+)-(begin|end)\s*</ac:parameter>\s*</ac:structured-macro>`)
+
+func generatedAnchor(name string) string {
+	return fmt.Sprintf(`<ac:structured-macro ac:name="anchor" ac:schema-version="1"><ac:parameter ac:name="">%s%s</ac:parameter></ac:structured-macro>`,
+		generatedAnchorPrefix, name)
+}
 
-	req, err := conflunceClient.NewRequest("PUT", apiEndpoint, &newContent)
-	perror(errors.Trace(err))
+// regionBegin and regionEnd wrap the generated region of the name.
+func regionBegin(name string) string {
+	return generatedAnchor(name + "-begin")
+}
 
-	var respContent Content
-	_, err = conflunceClient.Do(req, &respContent)
-	perror(errors.Trace(err))
+func regionEnd(name string) string {
+	return generatedAnchor(name + "-end")
+}
 
-	return respContent
+type generatedRegion struct {
+	name       string
+	start, end int
+}
+
+// generatedRegions returns the regions of the body in order, the unpaired anchors are ignored.
+func generatedRegions(body string) []generatedRegion {
+	var (
+		regions []generatedRegion
+		open    = -1
+		name    string
+	)
+	for _, m := range generatedAnchorPattern.FindAllStringSubmatchIndex(body, -1) {
+		anchor, kind := body[m[2]:m[3]], body[m[4]:m[5]]
+		switch {
+		case kind == "begin":
+			open, name = m[0], anchor
+		case open >= 0 && anchor == name:
+			regions = append(regions, generatedRegion{name: name, start: open, end: m[1]})
+			open = -1
+		}
+	}
+	return regions
 }
 
-func deleteContent(id string) {
-	apiEndpoint := "rest/api/content/" + id
+// legacyContentHeading heads the body of a page written before the generated
+// regions, it's kept below the regions.
+const legacyContentHeading = "<h2>Previous content</h2>"
+
+// mergeGeneratedContent replaces the generated regions of the page, see mergeGeneratedRegions.
+func mergeGeneratedContent(content Content, generated string) (Content, error) {
+	return mergeContent(content, func(old string) string {
+		if len(generatedRegions(old)) == 0 && len(strings.TrimSpace(old)) > 0 {
+			log.Warnf("page %q has no generated regions, it's written by an older version, keep its body under %q",
+				content.Title, legacyContentHeading)
+		}
+		return mergeGeneratedRegions(old, generated)
+	})
+}
 
-	req, err := conflunceClient.NewRequest("DELETE", apiEndpoint, nil)
-	perror(errors.Trace(err))
+// mergeGeneratedRegions replaces the regions of the old body with the ones of
+// the same names in the generated body. The regions missing in the old body,
+// e.g. removed by hand or new in the template, are appended to it. The old
+// body without any region is written before the regions, it's kept below the
+// generated body under legacyContentHeading, the later merges keep it as any
+// other notes.
+func mergeGeneratedRegions(old string, generated string) string {
+	regions := make(map[string]string)
+	var names []string
+	for _, r := range generatedRegions(generated) {
+		if _, ok := regions[r.name]; !ok {
+			names = append(names, r.name)
+		}
+		regions[r.name] = generated[r.start:r.end]
+	}
 
-	_, err = conflunceClient.Do(req, nil)
-	perror(errors.Trace(err))
+	oldRegions := generatedRegions(old)
+	if len(oldRegions) == 0 {
+		if len(strings.TrimSpace(old)) == 0 {
+			return generated
+		}
+		return generated + legacyContentHeading + old
+	}
+	var (
+		merged strings.Builder
+		last   int
+		seen   = make(map[string]bool)
+	)
+	for _, r := range oldRegions {
+		value, ok := regions[r.name]
+		if !ok {
+			// The region is no longer generated, keep it.
+			continue
+		}
+		merged.WriteString(old[last:r.start])
+		merged.WriteString(value)
+		last = r.end
+		seen[r.name] = true
+	}
+	merged.WriteString(old[last:])
+	for _, name := range names {
+		if !seen[name] {
+			merged.WriteString(regions[name])
+		}
+	}
+	return merged.String()
 }
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/google/go-github/github"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -48,49 +48,149 @@ func collectMentionsPR(collector map[string]*GithubItem, member string, issues [
 }
 
 func runDailyCommandFunc(cmd *cobra.Command, args []string) {
-	now := time.Now().UTC()
+	now := reportClock().UTC()
 	start := now.Add(-24 * time.Hour).Format(githubUTCDateFormat)
+	summary := newRunSummary("daily")
 
-	var buf bytes.Buffer
-	buf.WriteString("*Daily Report*\n\n")
+	for _, team := range teams {
+		report := buildDailyReport(team, start, summary)
+		summary.Record(team.Name+" snapshot", saveReportSnapshot(team, report))
+		summary.Record(team.Name+" output", outputReport(team.SlackChannel, report))
+		sendDailyDigests(team, report, summary)
+	}
+	summary.Exit()
+}
+
+// buildDailyReport builds the daily report of the team, the failed sections
+// are recorded to summary and skipped.
+func buildDailyReport(team *Team, start string, summary *RunSummary) *Report {
+	report := &Report{Kind: "daily", Title: "Daily Report", Team: team.Name}
 
 	//issues := getCreatedIssues(start, nil)
-	//formatSectionForSlackOutput(&buf, "New Issues", "New issues in last 24 hours")
-	//formatGitHubIssuesForSlackOutput(&buf, issues)
-	//buf.WriteString("\n")
+	//report.AddSection("New Issues", "New issues in last 24 hours", newGithubReportItems(team, issues))
+
+	githubIDs := team.GithubIDs()
+	mentionedPRs := make([]IssueSlice, len(githubIDs))
+	errs := make([]error, len(githubIDs))
+	runParallel(len(githubIDs), func(idx int) {
+		mentionedPRs[idx], errs[idx] = getPullReuestsMentioned(start, nil, githubIDs[idx])
+	})
 
 	collector := make(map[string]*GithubItem)
-	for _, member := range allSQLInfraMembers {
-		mentionedPRs := getPullReuestsMentioned(start, nil, member)
-		collectMentionsPR(collector, member, mentionedPRs)
+	for idx, member := range githubIDs {
+		if summary.Record(member, errs[idx]) {
+			continue
+		}
+		collectMentionsPR(collector, member, mentionedPRs[idx])
+	}
+	report.AddSection("Pull Requests that mentioned you", "PR that mentioned you in last 24 hours", newMentionsPRReportItems(team, collector))
+
+	members := strings.Join(team.QuotedEmails(), ",")
+	dailyIssues, err := queryJiraIssues(fmt.Sprintf(`assignee in (%v)  AND updated >= -1d ORDER BY assignee`, members))
+	if !summary.Record(team.Name+" Team JIRA Issue", err) {
+		report.AddSection("Team JIRA Issue", "Updated in last 24 hours", newJiraReportItems(dailyIssues))
+	}
+
+	addDueDateSections(report, team, summary)
+
+	return report
+}
+
+// addDueDateSections adds the in progress issues which are reaching the due
+// date or have no due date.
+func addDueDateSections(report *Report, team *Team, summary *RunSummary) {
+	members := strings.Join(team.QuotedEmails(), ",")
+	inProgress := config.Jira.inProgressJQL()
+	dueSoonIssues, err := queryJiraIssues(fmt.Sprintf(`%s AND assignee in (%v) AND duedate <= %dd ORDER BY assignee`,
+		inProgress, members, config.Jira.DueSoonDays))
+	if !summary.Record(team.Name+" Getting To Due Date JIRA Issue", err) {
+		report.AddSection("Getting To Due Date JIRA Issue",
+			fmt.Sprintf("The due date will be less than %d days", config.Jira.DueSoonDays),
+			newJiraReportItems(dueSoonIssues))
+	}
+
+	processingIssues, err := queryJiraIssues(fmt.Sprintf(`%s AND assignee in (%v) ORDER BY assignee`, inProgress, members))
+	if !summary.Record(team.Name+" JIRA Issue Without Due Date", err) {
+		report.AddSection("JIRA Issue Without Due Date", "Please add due date to processing JIRA issues",
+			newJiraReportItems(findOutIssuesWithoutDueDate(processingIssues)))
+	}
+}
+
+// sendDailyDigests sends the members who enable slack-digest their own part
+// of the daily report and the pull requests waiting for their reviews by DM.
+func sendDailyDigests(team *Team, report *Report, summary *RunSummary) {
+	var members []Member
+	for _, member := range team.Members {
+		if member.SlackDigest {
+			members = append(members, member)
+		}
 	}
 
-	formatSectionForSlackOutput(&buf, fmt.Sprintf("Pull Requests that mentioned you"), "PR that mentioned you in last 24 hours")
-	formatCollectMentionsPRForSlackOutput(&buf, collector)
-	buf.WriteString("\n")
-
-	members := strings.Join(allSQLInfraMemberEmals, ",")
-	dailyIssues := queryJiraIssues(fmt.Sprintf(`assignee in (%v)  AND updated >= -1d ORDER BY assignee`, members))
-	formatSectionForSlackOutput(&buf, "Team JIRA Issue", "Updated in last 24 hours")
-	formatJiraIssuesForSlackOutput(&buf, dailyIssues)
-	buf.WriteString("\n")
-
-	// TODO: make the filter syntax configurable in the config file.
-	// nonProcessStatus := `"Job Closed", 完成, TODO, "To Do", DUPLICATED, Blocked, Closed, Paused, Resolved, "CAN'T REPRODUCE", Cancelled, "WON'T FIX"`
-	//nonProcessStatus := config.Jira.NonProcessStatus
-	//dueDateIssues := queryJiraIssues(fmt.Sprintf(`statusCategory = indeterminate AND assignee in (%v) and duedate <= 2d  ORDER BY assignee`, members))
-	//formatSectionForSlackOutput(&buf, "Getting To Due Date JIRA Issue", "The due date will be less than 2 day")
-	//formatJiraIssuesForSlackOutput(&buf, dueDateIssues)
-	//buf.WriteString("\n")
-
-	//processingIssues := queryJiraIssues(fmt.Sprintf(`status not in (%v) AND assignee in (%v) ORDER BY assignee`, nonProcessStatus, members))
-	//formatSectionForSlackOutput(&buf, "JIRA Issue Without Due Date", "Please add due date to processing JIRA issues")
-	//formatJiraIssuesForSlackOutput(&buf, findOutIssuesWithoutDueDate(processingIssues))
-	//buf.WriteString("\n")
-
-	if printToConsole {
-		fmt.Println(buf.String())
-	} else {
-		sendToSlack(buf.String())
+	reviews := make([]IssueSlice, len(members))
+	errs := make([]error, len(members))
+	runParallel(len(members), func(idx int) {
+		reviews[idx], errs[idx] = getReviewRequestedPullRequests(members[idx].Github)
+	})
+
+	_, toSlack := reportOutput()
+	for idx, member := range members {
+		if summary.Record(member.Name+" digest", errs[idx]) {
+			continue
+		}
+		digest := newDailyDigest(team, report, member, reviews[idx])
+		if len(digest.Sections) == 0 {
+			continue
+		}
+
+		var channel string
+		if toSlack {
+			id, err := slackMemberID(member.Email)
+			if summary.Record(member.Name+" digest", err) {
+				continue
+			}
+			channel = id
+		}
+		summary.Record(member.Name+" digest", outputReport(channel, digest))
+	}
+}
+
+func newDailyDigest(team *Team, report *Report, member Member, reviews IssueSlice) *Report {
+	digest := report.filterItems(func(item ReportItem) bool {
+		return item.involves(member.Github, member.Email)
+	})
+	digest.Kind = "daily-digest"
+	digest.Title = "Daily Digest"
+	digest.Team = team.Name
+	if len(reviews) > 0 {
+		digest.AddSection("Pull Requests waiting for your review", "Open PR that requested your review", newGithubReportItems(team, reviews))
+	}
+	return digest
+}
+
+// newMentionsPRReportItems converts the collected PRs to the report items
+// sorted by the link, only the members with a known email are mentioned.
+func newMentionsPRReportItems(team *Team, collector map[string]*GithubItem) []ReportItem {
+	links := make([]string, 0, len(collector))
+	for link := range collector {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+
+	items := make([]ReportItem, 0, len(links))
+	for _, link := range links {
+		githubItem := collector[link]
+		item := newGithubReportItem(team, githubItem.issue)
+		for _, memtion := range githubItem.memtions {
+			email, ok := github2Email[memtion]
+			if !ok {
+				continue
+			}
+			item.Mentions = append(item.Mentions, ReportPerson{Login: memtion, Email: email})
+		}
+		if len(item.Mentions) == 0 {
+			continue
+		}
+		items = append(items, item)
 	}
+	return items
 }
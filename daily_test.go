@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/github"
+	"github.com/nlopes/slack"
+)
+
+// testNow is the report time of the tests, a Friday.
+var testNow = time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+// setupFakes wires the services to the fakes, loads a one-team config and
+// fixes the clock, the globals are restored after the test.
+func setupFakes(t *testing.T) (*FakeGithub, *FakeJira, *FakeConfluence, *FakeSlack) {
+	oldGithub, oldIssues, oldSprints, oldContents, oldMessages := githubSearcher, issueSearcher, sprintManager, contentStore, messagePoster
+	oldConfig, oldTeams, oldTeamName, oldClock := config, teams, teamName, reportClock
+	oldPrint, oldFormat, oldDryRun, oldOffline := printToConsole, reportFormat, dryRun, offline
+	oldSnapshots, oldEvents, oldTemplateDir, oldCtx := snapshots, githubEvents, templateDir, globalCtx
+	oldSlackLoaded, oldSlackMembers := slackMemberLoaded, slackMembers
+	t.Cleanup(func() {
+		githubSearcher, issueSearcher, sprintManager, contentStore, messagePoster = oldGithub, oldIssues, oldSprints, oldContents, oldMessages
+		config, teams, teamName, reportClock = oldConfig, oldTeams, oldTeamName, oldClock
+		printToConsole, reportFormat, dryRun, offline = oldPrint, oldFormat, oldDryRun, oldOffline
+		snapshots, githubEvents, templateDir, globalCtx = oldSnapshots, oldEvents, oldTemplateDir, oldCtx
+		slackMemberLoaded, slackMembers = oldSlackLoaded, oldSlackMembers
+	})
+
+	gh, jr, cf, sl := useFakes()
+	config = &Config{
+		Slack: Slack{Channel: "team-channel", User: "reporter"},
+		Jira: Jira{
+			Endpoint:             "https://jira.example.com",
+			Server:               "JIRA",
+			ServerID:             "jira-id",
+			Project:              "TIKV",
+			WeeklyPersonalIssues: "updated >= -7d",
+		},
+		Confluence: Confluence{
+			User:              "reporter",
+			Space:             "TT",
+			WeeklyPath:        "Weekly Reports",
+			WeeklyDueDatePath: "Weekly Due Dates",
+		},
+		Github: Github{Repos: []string{"pingcap/tidb"}},
+		Sprint: Sprint{Timezone: "UTC"},
+		Teams: []Team{{
+			Name: "Team",
+			Members: []Member{
+				{Name: "Alice", Github: "alice", Email: "alice@example.com", SlackDigest: true},
+				{Name: "Bob", Github: "bob", Email: "bob@example.com"},
+			},
+		}},
+	}
+	if err := config.Sprint.adjust(); err != nil {
+		t.Fatal(err)
+	}
+	config.Jira.adjust()
+	teamName = ""
+	initRepoQuery()
+	initTeamMembers()
+	reportClock = func() time.Time { return testNow }
+	printToConsole, reportFormat, dryRun, offline = false, "", false, false
+	snapshots, githubEvents = nil, nil
+	templateDir = t.TempDir()
+	globalCtx = context.Background()
+	slackMemberLoaded, slackMembers = time.Time{}, map[string]string{}
+	sl.Users = []slack.User{
+		{ID: "UALICE0001", Profile: slack.UserProfile{Email: "alice@example.com"}},
+		{ID: "UBOB000001", Profile: slack.UserProfile{Email: "bob@example.com"}},
+	}
+	return gh, jr, cf, sl
+}
+
+func testPullRequest(number int, title string, author string) github.Issue {
+	return github.Issue{
+		Number:  github.Int(number),
+		Title:   github.String(title),
+		State:   github.String("open"),
+		HTMLURL: github.String(fmt.Sprintf("https://github.com/pingcap/tidb/pull/%d", number)),
+		User:    &github.User{Login: github.String(author)},
+	}
+}
+
+func testJiraIssue(key string, summary string, assignee string, status string, category string) jira.Issue {
+	return jira.Issue{
+		ID:  key,
+		Key: key,
+		Fields: &jira.IssueFields{
+			Summary:  summary,
+			Type:     jira.IssueType{Name: "Task"},
+			Assignee: &jira.User{Key: assignee, DisplayName: assignee, EmailAddress: assignee},
+			Status:   &jira.Status{Name: status, StatusCategory: jira.StatusCategory{Key: category}},
+			Priority: &jira.Priority{Name: "Major"},
+		},
+	}
+}
+
+func mentionedQuery(login string, start string) string {
+	return fmt.Sprintf("%s -author:%s is:pr mentions:%s updated:>=%s", repoQuery, login, login, start)
+}
+
+func TestRunDailyCommand(t *testing.T) {
+	start := testNow.Add(-24 * time.Hour).Format(githubUTCDateFormat)
+	members := `"alice@example.com","bob@example.com"`
+	inProgress := `(statusCategory in ("indeterminate"))`
+	updatedJQL := fmt.Sprintf(`assignee in (%s)  AND updated >= -1d ORDER BY assignee`, members)
+	dueSoonJQL := fmt.Sprintf(`%s AND assignee in (%s) AND duedate <= 2d ORDER BY assignee`, inProgress, members)
+	processingJQL := fmt.Sprintf(`%s AND assignee in (%s) ORDER BY assignee`, inProgress, members)
+
+	tests := []struct {
+		name   string
+		blocks bool
+		// posts are the channels of the Slack messages in order.
+		posts    []string
+		contains map[string][]string
+	}{
+		{
+			name:  "text",
+			posts: []string{"#team-channel", "UALICE0001"},
+			contains: map[string][]string{
+				"#team-channel": {
+					"Daily Report", "https://github.com/pingcap/tidb/pull/1", "<@UALICE0001>",
+					"TIKV-1", "TIKV-2", "Getting To Due Date JIRA Issue", "JIRA Issue Without Due Date",
+				},
+				"UALICE0001": {"Daily Digest* (Team)", "TIKV-1", "https://github.com/pingcap/tidb/pull/3"},
+			},
+		},
+		{
+			name:   "blocks",
+			blocks: true,
+			posts:  []string{"#team-channel", "UALICE0001"},
+			contains: map[string][]string{
+				"#team-channel": {"Daily Report", "TIKV-2"},
+				"UALICE0001":    {"Daily Digest"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh, jr, _, sl := setupFakes(t)
+			config.Slack.Blocks = tt.blocks
+			gh.Issues[mentionedQuery("alice", start)] = []github.Issue{testPullRequest(1, "Fix the planner", "carol")}
+			gh.Issues[mentionedQuery("bob", start)] = []github.Issue{testPullRequest(1, "Fix the planner", "carol")}
+			gh.Issues[repoQuery+" is:open review-requested:alice type:pr"] = []github.Issue{testPullRequest(3, "Add an index", "bob")}
+			jr.Issues[updatedJQL] = []jira.Issue{
+				testJiraIssue("TIKV-1", "Support TTL", "alice@example.com", "In Progress", "indeterminate"),
+				testJiraIssue("TIKV-2", "Refine the docs", "bob@example.com", "Done", "done"),
+			}
+			jr.Issues[dueSoonJQL] = []jira.Issue{testJiraIssue("TIKV-3", "Release", "bob@example.com", "In Progress", "indeterminate")}
+			jr.Issues[processingJQL] = []jira.Issue{testJiraIssue("TIKV-4", "No due date", "bob@example.com", "In Progress", "indeterminate")}
+
+			runDailyCommandFunc(nil, nil)
+
+			var channels []string
+			texts := make(map[string]string)
+			for _, msg := range sl.Messages {
+				if len(msg.ThreadTS) > 0 {
+					continue
+				}
+				if _, ok := texts[msg.Channel]; !ok {
+					channels = append(channels, msg.Channel)
+				}
+				texts[msg.Channel] += msg.Text + fmt.Sprintf("%v", msg.Blocks)
+				if tt.blocks != (len(msg.Blocks) > 0) {
+					t.Errorf("message to %s has %d blocks", msg.Channel, len(msg.Blocks))
+				}
+			}
+			if strings.Join(channels, ",") != strings.Join(tt.posts, ",") {
+				t.Fatalf("posted to %v, want %v", channels, tt.posts)
+			}
+			for channel, wants := range tt.contains {
+				for _, want := range wants {
+					if !strings.Contains(texts[channel], want) {
+						t.Errorf("message to %s doesn't contain %q:\n%s", channel, want, texts[channel])
+					}
+				}
+			}
+			if strings.Contains(texts["UALICE0001"], "TIKV-2") {
+				t.Errorf("the digest of alice contains the issue of bob:\n%s", texts["UALICE0001"])
+			}
+		})
+	}
+}
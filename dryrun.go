@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+// The dry-run services pass all the reads through and only print the writes.
+var dryRunOutput io.Writer = os.Stdout
+
+func dryRunPrintf(format string, args ...interface{}) {
+	fmt.Fprintf(dryRunOutput, "[dry-run] "+format+"\n", args...)
+}
+
+type dryRunIssueSearcher struct {
+	IssueSearcher
+}
+
+func (s dryRunIssueSearcher) AddLink(link *jira.IssueLink) error {
+	dryRunPrintf("add %q link: %s -> %s", link.Type.Name, link.InwardIssue.Key, link.OutwardIssue.Key)
+	return nil
+}
+
+type dryRunSprintManager struct {
+	SprintManager
+}
+
+func (s dryRunSprintManager) CreateSprint(boardID int, name string, startDate, endDate string) (jira.Sprint, error) {
+	dryRunPrintf("create sprint %q on board %d: %s ~ %s", name, boardID, startDate, endDate)
+	sprint := jira.Sprint{Name: name, OriginBoardID: boardID, State: "future"}
+	if t, err := parseSprintDate(startDate); err == nil {
+		sprint.StartDate = &t
+	}
+	if t, err := parseSprintDate(endDate); err == nil {
+		sprint.EndDate = &t
+	}
+	return sprint, nil
+}
+
+func (s dryRunSprintManager) UpdateSprint(sprintID int, args map[string]string) (jira.Sprint, error) {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	changes := make([]string, 0, len(keys))
+	for _, k := range keys {
+		changes = append(changes, fmt.Sprintf("%s=%s", k, args[k]))
+	}
+	dryRunPrintf("update sprint %d: %s", sprintID, strings.Join(changes, ", "))
+	sprint := jira.Sprint{ID: sprintID, State: args["state"]}
+	return sprint, nil
+}
+
+func (s dryRunSprintManager) DeleteSprint(sprintID int) error {
+	dryRunPrintf("delete sprint %d", sprintID)
+	return nil
+}
+
+func (s dryRunSprintManager) MoveIssuesToSprint(sprintID int, issueIDs []string) error {
+	dryRunPrintf("move %d issues to sprint %d: %s", len(issueIDs), sprintID, strings.Join(issueIDs, ","))
+	return nil
+}
+
+type dryRunContentStore struct {
+	ContentStore
+}
+
+func (s dryRunContentStore) CreateContent(content *Content) (Content, error) {
+	parent := ""
+	if len(content.Ancestors) > 0 {
+		parent = content.Ancestors[len(content.Ancestors)-1].Id
+	}
+	dryRunPrintf("create page %q in space %s under page %q", content.Title, content.Space.Key, parent)
+	printContentDiff("", content.Body.Storage.Value)
+	return *content, nil
+}
+
+func (s dryRunContentStore) UpdateContent(content *Content) (Content, error) {
+	dryRunPrintf("update page %q (%s) to version %d", content.Title, content.Id, content.Version.Number)
+	if len(content.Ancestors) > 0 {
+		dryRunPrintf("move page %q under page %q", content.Title, content.Ancestors[len(content.Ancestors)-1].Id)
+	}
+	// The pages created in the dry run have no ID, and nothing to diff against.
+	var old Content
+	if len(content.Id) > 0 {
+		var err error
+		if old, err = s.ContentStore.GetContent(content.Id); err != nil {
+			return Content{}, err
+		}
+	}
+	printContentDiff(old.Body.Storage.Value, content.Body.Storage.Value)
+	return *content, nil
+}
+
+func (s dryRunContentStore) DeleteContent(id string) error {
+	dryRunPrintf("delete page %s", id)
+	return nil
+}
+
+// GetAttachments returns nothing for the pages created in the dry run, they have no ID.
+func (s dryRunContentStore) GetAttachments(contentID string) ([]Attachment, error) {
+	if len(contentID) == 0 {
+		return nil, nil
+	}
+	return s.ContentStore.GetAttachments(contentID)
+}
+
+func (s dryRunContentStore) CreateAttachment(contentID string, file attachmentFile) (Attachment, error) {
+	dryRunPrintf("attach %q (%s, %d bytes) to page %q", file.Name, file.MediaType, len(file.Data), contentID)
+	return Attachment{Title: file.Name}, nil
+}
+
+func (s dryRunContentStore) UpdateAttachment(contentID string, attachmentID string, file attachmentFile) (Attachment, error) {
+	dryRunPrintf("update attachment %q (%s) of page %q to %d bytes", file.Name, attachmentID, contentID, len(file.Data))
+	return Attachment{Id: attachmentID, Title: file.Name}, nil
+}
+
+func (s dryRunContentStore) DeleteAttachment(attachmentID string) error {
+	dryRunPrintf("delete attachment %s", attachmentID)
+	return nil
+}
+
+// GetChildPages returns nothing for the pages created in the dry run, they have no ID.
+func (s dryRunContentStore) GetChildPages(contentID string) ([]Content, error) {
+	if len(contentID) == 0 {
+		return nil, nil
+	}
+	return s.ContentStore.GetChildPages(contentID)
+}
+
+func (s dryRunContentStore) AddLabels(contentID string, labels []string) error {
+	dryRunPrintf("label page %q: %s", contentID, strings.Join(labels, ","))
+	return nil
+}
+
+func (s dryRunContentStore) SetRestrictions(contentID string, restrictions []ContentRestriction) error {
+	data, err := json.Marshal(restrictions)
+	if err != nil {
+		return err
+	}
+	dryRunPrintf("restrict page %q: %s", contentID, data)
+	return nil
+}
+
+type dryRunMessagePoster struct {
+	MessagePoster
+}
+
+func (s dryRunMessagePoster) PostMessage(channel string, user string, text string) error {
+	dryRunPrintf("post message to %s as %s:\n%s", channel, user, text)
+	return nil
+}
+
+func (s dryRunMessagePoster) PostBlocks(channel string, user string, threadTS string, text string, blocks []SlackBlock) (string, error) {
+	data, err := json.MarshalIndent(blocks, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if len(threadTS) > 0 {
+		dryRunPrintf("post blocks to %s as %s in thread %s:\n%s", channel, user, threadTS, data)
+	} else {
+		dryRunPrintf("post blocks to %s as %s:\n%s", channel, user, data)
+	}
+	return "dry-run", nil
+}
+
+// useDryRun wraps the services, it must be called after the services are created.
+func useDryRun() {
+	issueSearcher = dryRunIssueSearcher{issueSearcher}
+	sprintManager = dryRunSprintManager{sprintManager}
+	contentStore = dryRunContentStore{contentStore}
+	messagePoster = dryRunMessagePoster{messagePoster}
+}
+
+// splitStorageLines splits the Confluence storage format into lines at the tag
+// boundaries, since the generated pages are mostly in one line.
+func splitStorageLines(value string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+	value = strings.Replace(value, "><", ">\n<", -1)
+	lines := strings.Split(value, "\n")
+	for idx := range lines {
+		lines[idx] = strings.TrimSpace(lines[idx])
+	}
+	return lines
+}
+
+func printContentDiff(oldValue, newValue string) {
+	diff := diffLines(splitStorageLines(oldValue), splitStorageLines(newValue))
+	if len(diff) == 0 {
+		dryRunPrintf("page body is not changed")
+		return
+	}
+	fmt.Fprintln(dryRunOutput, strings.Join(diff, "\n"))
+}
+
+// diffLines returns the changed lines prefixed by "-" or "+", based on
+// the longest common subsequence.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+ "+b[j])
+	}
+	return diff
+}
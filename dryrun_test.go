@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDryRunUpdateContent(t *testing.T) {
+	_, _, cf, _ := setupFakes(t)
+	var output bytes.Buffer
+	oldOutput := dryRunOutput
+	dryRunOutput = &output
+	defer func() {
+		dryRunOutput = oldOutput
+	}()
+	page := &Content{Type: "page", Title: "Page"}
+	page.Body.Storage.Value = "<p>old</p>"
+	existing, err := cf.CreateContent(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+		diff    string
+	}{
+		{name: "existing page", id: existing.Id, diff: "- <p>old</p>\n+ <p>new</p>\n"},
+		// The page is created earlier in the same dry run.
+		{name: "page without ID", diff: "+ <p>new</p>\n"},
+		{name: "missing page", id: "404", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output.Reset()
+			c := &Content{Id: tt.id, Title: "Page"}
+			c.Version.Number = 2
+			c.Body.Storage.Value = "<p>new</p>"
+			_, err := dryRunContentStore{cf}.UpdateContent(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !strings.HasSuffix(output.String(), tt.diff) {
+				t.Errorf("output %q, want the diff %q", output.String(), tt.diff)
+			}
+		})
+	}
+	if c, _ := cf.GetContent(existing.Id); c.Body.Storage.Value != "<p>old</p>" || c.Version.Number != 1 {
+		t.Errorf("the dry run updated the page: %+v", c)
+	}
+}
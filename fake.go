@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	"github.com/nlopes/slack"
+)
+
+// The fakes below keep everything in memory, so the report commands
+// can run without any live service.
+
+// FakeGithub returns the issues registered for a search query.
+type FakeGithub struct {
+	// Issues is keyed by the full search query.
+	Issues  map[string][]github.Issue
+	Queries []string
+	// Reviews and Timelines are keyed by "owner/repo#number".
+	Reviews   map[string][]*github.PullRequestReview
+	Timelines map[string][]*github.Timeline
+
+	mu sync.Mutex
+}
+
+func newFakeGithub() *FakeGithub {
+	return &FakeGithub{
+		Issues:    make(map[string][]github.Issue),
+		Reviews:   make(map[string][]*github.PullRequestReview),
+		Timelines: make(map[string][]*github.Timeline),
+	}
+}
+
+func (f *FakeGithub) SearchIssues(ctx context.Context, query string, opt *github.SearchOptions) ([]github.Issue, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Queries = append(f.Queries, query)
+	return f.Issues[query], 0, nil
+}
+
+func (f *FakeGithub) ListReviews(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.PullRequestReview, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Reviews[fmt.Sprintf("%s/%s#%d", owner, repo, number)], 0, nil
+}
+
+func (f *FakeGithub) ListTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.Timeline, int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Timelines[fmt.Sprintf("%s/%s#%d", owner, repo, number)], 0, nil
+}
+
+// FakeJira implements IssueSearcher and SprintManager.
+type FakeJira struct {
+	// Issues is keyed by JQL.
+	Issues   map[string][]jira.Issue
+	Worklogs map[string][]jira.WorklogRecord
+	// Changelogs are the full histories keyed by the issue key.
+	Changelogs map[string][]jira.ChangelogHistory
+	Statuses   []jira.Status
+	Links      []jira.IssueLink
+	Queries    []string
+	// ServerMaxResults caps the page size like a real server if it's positive.
+	ServerMaxResults int
+
+	Boards []jira.Board
+	// Sprints is keyed by board ID.
+	Sprints map[int][]jira.Sprint
+	// SprintIssues is keyed by sprint ID.
+	SprintIssues map[int][]string
+
+	nextID int
+	// The reports fetch concurrently.
+	mu sync.Mutex
+}
+
+func newFakeJira() *FakeJira {
+	return &FakeJira{
+		Issues:       make(map[string][]jira.Issue),
+		Worklogs:     make(map[string][]jira.WorklogRecord),
+		Changelogs:   make(map[string][]jira.ChangelogHistory),
+		Sprints:      make(map[int][]jira.Sprint),
+		SprintIssues: make(map[int][]string),
+		nextID:       1000,
+	}
+}
+
+func (f *FakeJira) Search(jql string, opts *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Queries = append(f.Queries, jql)
+	issues := f.Issues[jql]
+	resp := &jira.Response{Total: len(issues)}
+	if opts != nil {
+		resp.StartAt = opts.StartAt
+		resp.MaxResults = opts.MaxResults
+		if f.ServerMaxResults > 0 && (resp.MaxResults <= 0 || resp.MaxResults > f.ServerMaxResults) {
+			resp.MaxResults = f.ServerMaxResults
+		}
+		if opts.StartAt >= len(issues) {
+			return nil, resp, nil
+		}
+		issues = issues[opts.StartAt:]
+		if resp.MaxResults > 0 && len(issues) > resp.MaxResults {
+			issues = issues[:resp.MaxResults]
+		}
+	}
+	return issues, resp, nil
+}
+
+func (f *FakeJira) GetWorklogs(key string) (*jira.Worklog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := f.Worklogs[key]
+	return &jira.Worklog{Worklogs: records, Total: len(records)}, nil
+}
+
+func (f *FakeJira) GetChangelog(key string) ([]jira.ChangelogHistory, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Changelogs[key], nil
+}
+
+func (f *FakeJira) GetStatuses() ([]jira.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Statuses, nil
+}
+
+func (f *FakeJira) AddLink(link *jira.IssueLink) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Links = append(f.Links, *link)
+	return nil
+}
+
+func (f *FakeJira) GetAllBoards(opts *jira.BoardListOptions) (*jira.BoardsList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &jira.BoardsList{Values: f.Boards, Total: len(f.Boards), IsLast: true}, nil
+}
+
+func (f *FakeJira) GetAllSprints(boardID int, opts *jira.GetAllSprintsOptions) (*jira.SprintsList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var states []string
+	if opts != nil && len(opts.State) > 0 {
+		states = strings.Split(opts.State, ",")
+	}
+
+	var sprints []jira.Sprint
+	for _, sprint := range f.Sprints[boardID] {
+		if len(states) == 0 || containsString(states, sprint.State) {
+			sprints = append(sprints, sprint)
+		}
+	}
+	return &jira.SprintsList{Values: sprints, Total: len(sprints), IsLast: true}, nil
+}
+
+func (f *FakeJira) CreateSprint(boardID int, name string, startDate, endDate string) (jira.Sprint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	sprint := jira.Sprint{
+		ID:            f.nextID,
+		Name:          name,
+		OriginBoardID: boardID,
+		State:         "future",
+	}
+	if t, err := parseSprintDate(startDate); err == nil {
+		sprint.StartDate = &t
+	}
+	if t, err := parseSprintDate(endDate); err == nil {
+		sprint.EndDate = &t
+	}
+	f.Sprints[boardID] = append(f.Sprints[boardID], sprint)
+	return sprint, nil
+}
+
+func (f *FakeJira) findSprint(sprintID int) *jira.Sprint {
+	for boardID := range f.Sprints {
+		for idx := range f.Sprints[boardID] {
+			if f.Sprints[boardID][idx].ID == sprintID {
+				return &f.Sprints[boardID][idx]
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FakeJira) UpdateSprint(sprintID int, args map[string]string) (jira.Sprint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sprint := f.findSprint(sprintID)
+	if sprint == nil {
+		return jira.Sprint{}, fmt.Errorf("sprint %d not found", sprintID)
+	}
+	if state, ok := args["state"]; ok {
+		sprint.State = state
+	}
+	if t, err := parseSprintDate(args["startDate"]); err == nil {
+		sprint.StartDate = &t
+	}
+	if t, err := parseSprintDate(args["endDate"]); err == nil {
+		sprint.EndDate = &t
+	}
+	return *sprint, nil
+}
+
+func (f *FakeJira) DeleteSprint(sprintID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for boardID, sprints := range f.Sprints {
+		for idx, sprint := range sprints {
+			if sprint.ID == sprintID {
+				f.Sprints[boardID] = append(sprints[:idx], sprints[idx+1:]...)
+				delete(f.SprintIssues, sprintID)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("sprint %d not found", sprintID)
+}
+
+func (f *FakeJira) MoveIssuesToSprint(sprintID int, issueIDs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.findSprint(sprintID) == nil {
+		return fmt.Errorf("sprint %d not found", sprintID)
+	}
+	// An issue belongs to one sprint only.
+	for id, ids := range f.SprintIssues {
+		kept := ids[:0]
+		for _, issueID := range ids {
+			if !containsString(issueIDs, issueID) {
+				kept = append(kept, issueID)
+			}
+		}
+		f.SprintIssues[id] = kept
+	}
+	f.SprintIssues[sprintID] = append(f.SprintIssues[sprintID], issueIDs...)
+	return nil
+}
+
+// FakeConfluence stores the pages in memory.
+type FakeConfluence struct {
+	// Contents is keyed by page ID.
+	Contents map[string]Content
+	// Attachments is keyed by page ID, AttachmentData by attachment ID.
+	Attachments    map[string][]Attachment
+	AttachmentData map[string][]byte
+	// Labels and Restrictions are keyed by page ID.
+	Labels       map[string][]string
+	Restrictions map[string][]ContentRestriction
+	// NoRestrictionAPI fails SetRestrictions like Confluence Server.
+	NoRestrictionAPI bool
+
+	nextID int
+	// The weekly pages are written while the reports fetch concurrently.
+	mu sync.Mutex
+}
+
+func newFakeConfluence() *FakeConfluence {
+	return &FakeConfluence{
+		Contents:       make(map[string]Content),
+		Attachments:    make(map[string][]Attachment),
+		AttachmentData: make(map[string][]byte),
+		Labels:         make(map[string][]string),
+		Restrictions:   make(map[string][]ContentRestriction),
+	}
+}
+
+func (f *FakeConfluence) GetContentByTitle(space string, title string) (Content, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.contentByTitle(space, title), nil
+}
+
+func (f *FakeConfluence) contentByTitle(space string, title string) Content {
+	for _, c := range f.Contents {
+		if c.Space.Key == space && c.Title == title {
+			return c
+		}
+	}
+	return Content{}
+}
+
+func (f *FakeConfluence) GetContent(id string) (Content, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.Contents[id]
+	if !ok {
+		return Content{}, fmt.Errorf("content %s not found", id)
+	}
+	return c, nil
+}
+
+func (f *FakeConfluence) CreateContent(content *Content) (Content, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c := f.contentByTitle(content.Space.Key, content.Title); c.Id != "" {
+		return Content{}, fmt.Errorf("a page with title %q already exists", content.Title)
+	}
+	f.nextID++
+	c := *content
+	c.Id = strconv.Itoa(f.nextID)
+	c.Version.Number = 1
+	c.Links.WebUI = "/pages/viewpage.action?pageId=" + c.Id
+	c.History = &ContentHistory{CreatedDate: reportClock().Format(time.RFC3339)}
+	f.Contents[c.Id] = c
+	return c, nil
+}
+
+func (f *FakeConfluence) UpdateContent(content *Content) (Content, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, ok := f.Contents[content.Id]
+	if !ok {
+		return Content{}, fmt.Errorf("content %s not found", content.Id)
+	}
+	if content.Version.Number != old.Version.Number+1 {
+		return Content{}, errContentVersionConflict
+	}
+	c := *content
+	if len(c.Ancestors) == 0 {
+		c.Ancestors = old.Ancestors
+	}
+	c.Links = old.Links
+	c.History = old.History
+	f.Contents[c.Id] = c
+	return c, nil
+}
+
+func (f *FakeConfluence) DeleteContent(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Contents[id]; !ok {
+		return fmt.Errorf("content %s not found", id)
+	}
+	delete(f.Contents, id)
+	return nil
+}
+
+func (f *FakeConfluence) GetAttachments(contentID string) ([]Attachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Contents[contentID]; !ok {
+		return nil, fmt.Errorf("content %s not found", contentID)
+	}
+	return f.Attachments[contentID], nil
+}
+
+func (f *FakeConfluence) CreateAttachment(contentID string, file attachmentFile) (Attachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range f.Attachments[contentID] {
+		if a.Title == file.Name {
+			return Attachment{}, fmt.Errorf("attachment %q of content %s already exists", file.Name, contentID)
+		}
+	}
+	f.nextID++
+	a := Attachment{Id: "att" + strconv.Itoa(f.nextID), Title: file.Name}
+	a.Version.Number = 1
+	f.Attachments[contentID] = append(f.Attachments[contentID], a)
+	f.AttachmentData[a.Id] = file.Data
+	return a, nil
+}
+
+func (f *FakeConfluence) UpdateAttachment(contentID string, attachmentID string, file attachmentFile) (Attachment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for idx, a := range f.Attachments[contentID] {
+		if a.Id == attachmentID {
+			a.Version.Number++
+			f.Attachments[contentID][idx] = a
+			f.AttachmentData[a.Id] = file.Data
+			return a, nil
+		}
+	}
+	return Attachment{}, fmt.Errorf("attachment %s of content %s not found", attachmentID, contentID)
+}
+
+func (f *FakeConfluence) DeleteAttachment(attachmentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for contentID, attachments := range f.Attachments {
+		for idx, a := range attachments {
+			if a.Id == attachmentID {
+				f.Attachments[contentID] = append(attachments[:idx:idx], attachments[idx+1:]...)
+				delete(f.AttachmentData, attachmentID)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("attachment %s not found", attachmentID)
+}
+
+func (f *FakeConfluence) GetChildPages(contentID string) ([]Content, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Contents[contentID]; !ok {
+		return nil, fmt.Errorf("content %s not found", contentID)
+	}
+	return f.children(contentID), nil
+}
+
+func (f *FakeConfluence) AddLabels(contentID string, labels []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Contents[contentID]; !ok {
+		return fmt.Errorf("content %s not found", contentID)
+	}
+	for _, label := range labels {
+		found := false
+		for _, l := range f.Labels[contentID] {
+			found = found || l == label
+		}
+		if !found {
+			f.Labels[contentID] = append(f.Labels[contentID], label)
+		}
+	}
+	return nil
+}
+
+func (f *FakeConfluence) SetRestrictions(contentID string, restrictions []ContentRestriction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.Contents[contentID]; !ok {
+		return fmt.Errorf("content %s not found", contentID)
+	}
+	if f.NoRestrictionAPI {
+		return errors.NotSupportedf("PUT rest/api/content/{id}/restriction")
+	}
+	f.Restrictions[contentID] = restrictions
+	return nil
+}
+
+// Children returns the pages whose direct parent is parentID, ordered by title.
+func (f *FakeConfluence) Children(parentID string) []Content {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.children(parentID)
+}
+
+func (f *FakeConfluence) children(parentID string) []Content {
+	var children []Content
+	for _, c := range f.Contents {
+		if len(c.Ancestors) > 0 && c.Ancestors[len(c.Ancestors)-1].Id == parentID {
+			children = append(children, c)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Title < children[j].Title })
+	return children
+}
+
+type FakeMessage struct {
+	Channel  string
+	User     string
+	Text     string
+	ThreadTS string
+	Blocks   []SlackBlock
+}
+
+// FakeSlack records the posted messages.
+type FakeSlack struct {
+	Users    []slack.User
+	Messages []FakeMessage
+
+	// The daily digests are sent while the reports fetch concurrently.
+	mu sync.Mutex
+}
+
+func newFakeSlack() *FakeSlack {
+	return &FakeSlack{}
+}
+
+func (f *FakeSlack) PostMessage(channel string, user string, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Messages = append(f.Messages, FakeMessage{Channel: channel, User: user, Text: text})
+	return nil
+}
+
+// PostBlocks uses the message index as the timestamp.
+func (f *FakeSlack) PostBlocks(channel string, user string, threadTS string, text string, blocks []SlackBlock) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Messages = append(f.Messages, FakeMessage{Channel: channel, User: user, Text: text, ThreadTS: threadTS, Blocks: blocks})
+	return strconv.Itoa(len(f.Messages)), nil
+}
+
+func (f *FakeSlack) GetUsers() ([]slack.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Users, nil
+}
+
+// useFakes wires all the services to fresh in-memory fakes.
+func useFakes() (*FakeGithub, *FakeJira, *FakeConfluence, *FakeSlack) {
+	gh, jr, cf, sl := newFakeGithub(), newFakeJira(), newFakeConfluence(), newFakeSlack()
+	githubSearcher = gh
+	issueSearcher = jr
+	sprintManager = jr
+	contentStore = cf
+	messagePoster = sl
+	return gh, jr, cf, sl
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
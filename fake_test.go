@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+func TestFakeJiraSearch(t *testing.T) {
+	tests := []struct {
+		name             string
+		serverMaxResults int
+		opts             *jira.SearchOptions
+		keys             string
+		maxResults       int
+	}{
+		{name: "no options", keys: "A-1,A-2,A-3"},
+		{name: "first page", opts: &jira.SearchOptions{MaxResults: 2}, keys: "A-1,A-2", maxResults: 2},
+		{name: "last page", opts: &jira.SearchOptions{StartAt: 2, MaxResults: 2}, keys: "A-3", maxResults: 2},
+		{name: "past the end", opts: &jira.SearchOptions{StartAt: 3, MaxResults: 2}, maxResults: 2},
+		{name: "server cap", serverMaxResults: 1, opts: &jira.SearchOptions{MaxResults: 50}, keys: "A-1", maxResults: 1},
+		{name: "server cap without max results", serverMaxResults: 2, opts: &jira.SearchOptions{}, keys: "A-1,A-2", maxResults: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeJira()
+			f.ServerMaxResults = tt.serverMaxResults
+			f.Issues["project = A"] = []jira.Issue{{Key: "A-1"}, {Key: "A-2"}, {Key: "A-3"}}
+
+			issues, resp, err := f.Search("project = A", tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, issue := range issues {
+				keys = append(keys, issue.Key)
+			}
+			if strings.Join(keys, ",") != tt.keys {
+				t.Errorf("keys %v, want %s", keys, tt.keys)
+			}
+			if resp.Total != 3 || resp.MaxResults != tt.maxResults {
+				t.Errorf("total %d max results %d, want 3 and %d", resp.Total, resp.MaxResults, tt.maxResults)
+			}
+		})
+	}
+}
+
+func TestFakeJiraSprints(t *testing.T) {
+	f := newFakeJira()
+	first, _ := f.CreateSprint(1, "Sprint 1", "2026-10-12T00:00:00.000Z", "2026-10-19T00:00:00.000Z")
+	second, _ := f.CreateSprint(1, "Sprint 2", "", "")
+	if _, err := f.UpdateSprint(first.ID, map[string]string{"state": "active"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		state string
+		names string
+	}{
+		{name: "all", names: "Sprint 1,Sprint 2"},
+		{name: "active", state: "active", names: "Sprint 1"},
+		{name: "future and closed", state: "future,closed", names: "Sprint 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := f.GetAllSprints(1, &jira.GetAllSprintsOptions{State: tt.state})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, sprint := range list.Values {
+				names = append(names, sprint.Name)
+			}
+			if strings.Join(names, ",") != tt.names {
+				t.Errorf("sprints %v, want %s", names, tt.names)
+			}
+		})
+	}
+
+	// An issue moves out of its old sprint.
+	if err := f.MoveIssuesToSprint(first.ID, []string{"10", "11"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.MoveIssuesToSprint(second.ID, []string{"11"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(f.SprintIssues[first.ID], ","); got != "10" {
+		t.Errorf("issues of the first sprint %s", got)
+	}
+	if err := f.MoveIssuesToSprint(999, []string{"10"}); err == nil {
+		t.Error("moved issues to a missing sprint")
+	}
+}
+
+func TestFakeConfluenceContent(t *testing.T) {
+	setupFakes(t)
+	f := newFakeConfluence()
+	page := func(title string, ancestors ...Ancestor) *Content {
+		c := &Content{Type: "page", Title: title, Ancestors: ancestors}
+		c.Space.Key = "TT"
+		return c
+	}
+	parent, err := f.CreateContent(page("Parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := page("Child", Ancestor{Id: parent.Id})
+	created, err := f.CreateContent(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content func() *Content
+		wantErr bool
+	}{
+		{name: "duplicate title", content: func() *Content { return child }, wantErr: true},
+		{name: "stale version", content: func() *Content {
+			c := created
+			return &c
+		}, wantErr: true},
+		{name: "next version", content: func() *Content {
+			c := created
+			c.Version.Number++
+			c.Ancestors = nil
+			return &c
+		}},
+		{name: "missing page", content: func() *Content { return &Content{Id: "404"} }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if c := tt.content(); len(c.Id) == 0 {
+				_, err = f.CreateContent(c)
+			} else {
+				_, err = f.UpdateContent(c)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// An update keeps the parent.
+	if children := f.Children(parent.Id); len(children) != 1 || children[0].Version.Number != 2 {
+		t.Errorf("children %+v", children)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/juju/errors"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -14,9 +13,6 @@ import (
 
 var repoQuery string
 
-var allSQLInfraMembers []string
-var allSQLInfraMemberEmals []string
-
 var github2Email map[string]string
 
 const (
@@ -33,7 +29,7 @@ func (s IssueSlice) Less(i, j int) bool {
 	return s[i].GetHTMLURL() < s[j].GetHTMLURL()
 }
 
-func getIssuesByQuery(bySort string, queryArg string) IssueSlice {
+func getIssuesByQuery(bySort string, queryArg string) (IssueSlice, error) {
 	opt := github.SearchOptions{
 		Sort: bySort,
 	}
@@ -43,41 +39,78 @@ func getIssuesByQuery(bySort string, queryArg string) IssueSlice {
 	query := bytes.NewBufferString(repoQuery)
 	query.WriteString(queryArg)
 
+	for {
+		var (
+			issues   []github.Issue
+			nextPage int
+		)
+		err := callGithub(func() (err error) {
+			issues, nextPage, err = githubSearcher.SearchIssues(globalCtx, query.String(), &opt)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "query:%s", query.String())
+		}
+
+		allIssues = append(allIssues, issues...)
+
+		if nextPage == 0 {
+			break
+		}
+		opt.ListOptions.Page = nextPage
+	}
+
+	sort.Sort(allIssues)
+	return allIssues, nil
+}
+
+// callGithub calls fn, and retries it after the rate limit resets.
+func callGithub(fn func() error) error {
 	retryCount := 0
 	for {
-		issues, resp, err := githubClient.Search.Issues(globalCtx, query.String(), &opt)
-		if err1, ok := err.(*github.RateLimitError); ok {
-			dur := err1.Rate.Reset.Time.Sub(time.Now())
-			if dur < 0 {
-				dur = time.Minute
-			}
+		githubRateGate.wait()
+		err := fn()
+		if dur, ok := githubRetryAfter(err); ok {
 			retryCount++
 			if retryCount <= 10 {
 				fmt.Printf("meet RateLimitError, wait %s and retry %d\n", dur, retryCount)
-				time.Sleep(dur)
+				githubRateGate.pauseUntil(time.Now().Add(dur))
 				continue
 			}
 		}
+		return err
+	}
+}
 
-		perror(errors.Trace(err))
-
-		allIssues = append(allIssues, issues.Issues...)
-
-		if resp.NextPage == 0 {
-			break
+// githubRetryAfter returns how long to wait if err is caused by the rate limit.
+func githubRetryAfter(err error) (time.Duration, bool) {
+	switch e := err.(type) {
+	case *github.RateLimitError:
+		dur := e.Rate.Reset.Time.Sub(time.Now())
+		if dur < 0 {
+			dur = time.Minute
+		}
+		return dur, true
+	case *github.AbuseRateLimitError:
+		if e.RetryAfter != nil {
+			return *e.RetryAfter, true
 		}
-		opt.ListOptions.Page = resp.NextPage
+		return time.Minute, true
 	}
-
-	sort.Sort(allIssues)
-	return allIssues
+	return 0, false
 }
 
-func getIssues(bySort string, queryArgs map[string]string) IssueSlice {
+func getIssues(bySort string, queryArgs map[string]string) (IssueSlice, error) {
 	query := bytes.NewBufferString("")
 
-	for key, value := range queryArgs {
-		query.WriteString(fmt.Sprintf(" %s:%s", key, value))
+	// Keep the query stable, so the same search always has the same query.
+	keys := make([]string, 0, len(queryArgs))
+	for key := range queryArgs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		query.WriteString(fmt.Sprintf(" %s:%s", key, queryArgs[key]))
 	}
 
 	return getIssuesByQuery(bySort, query.String())
@@ -91,21 +124,30 @@ func generateDateRangeQuery(start string, end *string) string {
 	}
 }
 
-func getCreatedIssues(start string, end *string) []github.Issue {
+func getCreatedIssues(start string, end *string) (IssueSlice, error) {
+	if githubEvents != nil {
+		return githubEvents.createdIssues(start, end, false)
+	}
 	return getIssues("created", map[string]string{
 		"is":      "issue",
 		"created": generateDateRangeQuery(start, end),
 	})
 }
 
-func getCreatedPullRequests(start string, end *string) []github.Issue {
+func getCreatedPullRequests(start string, end *string) (IssueSlice, error) {
+	if githubEvents != nil {
+		return githubEvents.createdIssues(start, end, true)
+	}
 	return getIssues("created", map[string]string{
 		"is":      "pr",
 		"created": generateDateRangeQuery(start, end),
 	})
 }
 
-func getPullReuestsMentioned(start string, end *string, mentions string) []github.Issue {
+func getPullReuestsMentioned(start string, end *string, mentions string) (IssueSlice, error) {
+	if githubEvents != nil {
+		return githubEvents.mentionedPullRequests(start, end, mentions)
+	}
 	return getIssues("updated", map[string]string{
 		"is":       "pr",
 		"mentions": mentions,
@@ -114,7 +156,10 @@ func getPullReuestsMentioned(start string, end *string, mentions string) []githu
 	})
 }
 
-func getReviewPullRequests(user string, start string, end *string) []github.Issue {
+func getReviewPullRequests(user string, start string, end *string) (IssueSlice, error) {
+	if githubEvents != nil {
+		return githubEvents.reviewPullRequests(user, start, end)
+	}
 	return getIssues("updated", map[string]string{
 		"is":        "open",
 		"type":      "pr",
@@ -124,6 +169,28 @@ func getReviewPullRequests(user string, start string, end *string) []github.Issu
 	})
 }
 
+// getMergedPullRequests returns the pull requests merged in the range, the
+// stored events have no merges, so it always searches.
+func getMergedPullRequests(start string, end *string) (IssueSlice, error) {
+	return getIssues("updated", map[string]string{
+		"is":     "merged",
+		"type":   "pr",
+		"merged": generateDateRangeQuery(start, end),
+	})
+}
+
+// getReviewRequestedPullRequests returns the open pull requests which are waiting for the user's review.
+func getReviewRequestedPullRequests(user string) (IssueSlice, error) {
+	if githubEvents != nil {
+		return githubEvents.reviewRequestedPullRequests(user)
+	}
+	return getIssues("updated", map[string]string{
+		"is":               "open",
+		"type":             "pr",
+		"review-requested": user,
+	})
+}
+
 func initRepoQuery() {
 	s := strings.Join(config.Github.Repos, " repo:")
 	repoQuery = "repo:" + s
@@ -131,14 +198,15 @@ func initRepoQuery() {
 
 func initTeamMembers() {
 	github2Email = make(map[string]string)
-	for _, team := range config.Teams {
+	for idx := range config.Teams {
+		team := &config.Teams[idx]
+		team.adjust()
 		for _, member := range team.Members {
-			if team.Name == "SQL-Infra" {
-				allSQLInfraMembers = append(allSQLInfraMembers, member.Github)
-				allSQLInfraMemberEmals = append(allSQLInfraMemberEmals, strconv.Quote(member.Email))
-			}
-
 			github2Email[member.Github] = member.Email
 		}
 	}
+
+	var err error
+	teams, err = selectTeams(teamName)
+	perror(errors.Trace(err))
 }
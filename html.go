@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/andygrunwald/go-jira"
-	"github.com/google/go-github/github"
+	"github.com/juju/errors"
 	"html"
 	"strings"
 	"time"
@@ -22,10 +22,6 @@ func formatPageEndForHtmlOutput(buf *bytes.Buffer) {
 	buf.WriteString(`</ac:layout>`)
 }
 
-func formatHeadLineHtmlOutput(buf *bytes.Buffer, headlineTag string, headlineText string) {
-	buf.WriteString(fmt.Sprintf(`<%s>%s</%s>`, headlineTag, headlineText, headlineTag))
-}
-
 func formatSectionBeginForHtmlOutput(buf *bytes.Buffer) {
 	buf.WriteString(`<ac:layout-section ac:type="single"><ac:layout-cell><hr/>`)
 	buf.WriteString("\n")
@@ -45,6 +41,11 @@ func formatLabelForHtmlOutput(name string, color string) string {
 	return s
 }
 
+// confluencePageLink links to the page of the title in the same space.
+func confluencePageLink(title string) string {
+	return fmt.Sprintf(`<ac:link><ri:page ri:content-title="%s" /></ac:link>`, html.EscapeString(title))
+}
+
 func formatJiraIssueForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue) {
 	html := `
 	<p><ac:structured-macro ac:name="jira" ac:schema-version="1">
@@ -70,36 +71,6 @@ func getIssueProgressField(issue *jira.Issue) string {
 	return ""
 }
 
-func formatJiraIssueWithProgressForHtmlOutput(buf *bytes.Buffer, epic *jira.Issue, issue *jira.Issue, repeatChecker IssueRepeatChecker) {
-	issueType := strings.ToLower(issue.Fields.Type.Name)
-	switch issueType {
-	case "epic":
-		formatEpicIssueWithProgressForHtmlOutput(buf, issue, repeatChecker)
-	default:
-		formatNormalIssueWithProgressForHtmlOutput(buf, epic, issue)
-	}
-}
-
-func getThisWeekWorkLogs(issue *jira.Issue) []jira.WorklogRecord {
-	if issue.Fields.Worklog == nil {
-		return nil
-	}
-
-	allWorklogs := issue.Fields.Worklog.Worklogs
-	if len(allWorklogs) == 0 {
-		return nil
-	}
-	now := time.Now()
-	workLogs := make([]jira.WorklogRecord, 0, len(allWorklogs))
-	for i := len(allWorklogs) - 1; i >= 0; i-- {
-		if now.Sub(time.Time(*allWorklogs[i].Updated)) > 7*24*time.Hour {
-			break
-		}
-		workLogs = append(workLogs, allWorklogs[i])
-	}
-	return workLogs
-}
-
 func lastestThisWeekWorkLogs(issue *jira.Issue) *jira.WorklogRecord {
 	if issue.Fields.Worklog == nil {
 		return nil
@@ -110,7 +81,7 @@ func lastestThisWeekWorkLogs(issue *jira.Issue) *jira.WorklogRecord {
 		return nil
 	}
 
-	now := time.Now()
+	now := reportClock()
 	lastestWorkLog := allWorklogs[len(allWorklogs)-1]
 	if now.Sub(time.Time(*lastestWorkLog.Updated)) > 7*24*time.Hour {
 		return nil
@@ -118,11 +89,8 @@ func lastestThisWeekWorkLogs(issue *jira.Issue) *jira.WorklogRecord {
 	return &lastestWorkLog
 }
 
-func formatWorkLogForHtmlOutput(worklog *jira.WorklogRecord) string {
-	return worklog.Comment
-}
-
-func formatNormalIssueForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue) {
+// jiraMacro returns the Confluence Jira macro of the issue.
+func jiraMacro(key string) string {
 	html := `
     <ac:structured-macro ac:name="jira" ac:schema-version="1">
       <ac:parameter ac:name="server">%s</ac:parameter>
@@ -130,95 +98,103 @@ func formatNormalIssueForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue) {
       <ac:parameter ac:name="key">%s</ac:parameter>
     </ac:structured-macro>`
 
-	buf.WriteString(fmt.Sprintf(html, config.Jira.Server, config.Jira.ServerID, issue.Key))
+	return fmt.Sprintf(html, config.Jira.Server, config.Jira.ServerID, key)
 }
 
-func formatNormalIssueWithProgressForHtmlOutput(buf *bytes.Buffer, epic *jira.Issue, issue *jira.Issue) {
-	htmlOutput := `
-    <ac:structured-macro ac:name="jira" ac:schema-version="1">
-      <ac:parameter ac:name="server">%s</ac:parameter>
-      <ac:parameter ac:name="serverId">%s</ac:parameter>
-      <ac:parameter ac:name="key">%s</ac:parameter>
-    </ac:structured-macro> %s`
-
+func newWeeklyIssue(epic *jira.Issue, issue *jira.Issue) WeeklyIssue {
 	var progress string
 	if epic != nil && epic.Fields.Assignee != nil && issue.Fields.Assignee != nil && epic.Fields.Assignee.Key != issue.Fields.Assignee.Key {
-		assignee := fmt.Sprintf("@%s ", issue.Fields.Assignee.DisplayName)
-		progress = assignee
+		progress = fmt.Sprintf("@%s ", issue.Fields.Assignee.DisplayName)
 	}
 	workLog := lastestThisWeekWorkLogs(issue)
 	if workLog != nil {
-		progress = progress + formatWorkLogForHtmlOutput(workLog)
+		progress = progress + workLog.Comment
 	}
 
-	if len(progress) != 0 {
-		progress = fmt.Sprintf(": %s", html.EscapeString(progress))
+	weeklyIssue := WeeklyIssue{
+		Key:      issue.Key,
+		Summary:  issue.Fields.Summary,
+		Type:     issue.Fields.Type.Name,
+		Progress: strings.TrimSpace(progress),
 	}
-	buf.WriteString(fmt.Sprintf(htmlOutput, config.Jira.Server, config.Jira.ServerID, issue.Key, progress))
-}
-
-func formatUnorderedListIssuesForHtmlOutput(buf *bytes.Buffer, epic *jira.Issue, issues []jira.Issue, repeatChecker IssueRepeatChecker) {
-	if len(issues) == 0 {
-		return
+	if issue.Fields.Assignee != nil {
+		weeklyIssue.Assignee = issue.Fields.Assignee.EmailAddress
+	}
+	if issue.Fields.Status != nil {
+		weeklyIssue.Done = issue.Fields.Status.StatusCategory.Key == jiraStatusCategoryDone
 	}
+	return weeklyIssue
+}
 
-	// start unorderd list
-	buf.WriteString(`<ul>`)
-	for _, issue := range issues {
-		exists := repeatChecker.Check(issue.Key)
-		if exists {
+// newWeeklyIssues skips the issues which are already on the page, and expands
+// the epics to the issues in them.
+func newWeeklyIssues(epic *jira.Issue, issues []jira.Issue, repeatChecker IssueRepeatChecker, epicIssues EpicIssues) ([]WeeklyIssue, error) {
+	weeklyIssues := make([]WeeklyIssue, 0, len(issues))
+	for idx := range issues {
+		issue := &issues[idx]
+		if repeatChecker.Check(issue.Key) {
+			continue
+		}
+		if strings.ToLower(issue.Fields.Type.Name) != "epic" {
+			weeklyIssues = append(weeklyIssues, newWeeklyIssue(epic, issue))
 			continue
 		}
-		buf.WriteString(`<li>`)
-		formatJiraIssueWithProgressForHtmlOutput(buf, epic, &issue, repeatChecker)
-		buf.WriteString(`</li>`)
-	}
-
-	buf.WriteString(`</ul>`)
-}
 
-func formatNextWeekPlansForHtmlOutput(buf *bytes.Buffer, issues []jira.Issue, repeatChecker IssueRepeatChecker) {
-	if len(issues) == 0 {
-		return
+		weeklyIssue := newWeeklyIssue(nil, issue)
+		issuesInEpic, ok := epicIssues[issue.Key]
+		if !ok {
+			var err error
+			issuesInEpic, err = queryIssuesInEpic(issue)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		children, err := newWeeklyIssues(issue, issuesInEpic, repeatChecker, epicIssues)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		weeklyIssue.Children = children
+		weeklyIssues = append(weeklyIssues, weeklyIssue)
 	}
+	return weeklyIssues, nil
+}
 
-	// start unorderd list
-	buf.WriteString(`<ul>`)
-	for _, issue := range issues {
-		exists := repeatChecker.Check(issue.Key)
-		if exists {
-			continue
+// EpicIssues maps the epic key to the issues in the epic.
+type EpicIssues map[string][]jira.Issue
+
+// fetchEpicIssues fetches the issues of the epics concurrently.
+func fetchEpicIssues(epics []jira.Issue) (EpicIssues, error) {
+	results := make([][]jira.Issue, len(epics))
+	errs := make([]error, len(epics))
+	runParallel(len(epics), func(idx int) {
+		results[idx], errs[idx] = queryIssuesInEpic(&epics[idx])
+	})
+
+	epicIssues := make(EpicIssues, len(epics))
+	for idx, epic := range epics {
+		if errs[idx] != nil {
+			return nil, errors.Trace(errs[idx])
 		}
-		buf.WriteString(`<li>`)
-		formatNormalIssueWithProgressForHtmlOutput(buf, nil, &issue)
-		buf.WriteString(`</li>`)
+		epicIssues[epic.Key] = results[idx]
 	}
-	buf.WriteString(`</ul>`)
+	return epicIssues, nil
 }
 
-func formatEpicIssueWithProgressForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue, repeatChecker IssueRepeatChecker) {
-	// format epic issue self.
-	formatNormalIssueWithProgressForHtmlOutput(buf, nil, issue)
-
-	// format issues belongs to this epic.
+func queryIssuesInEpic(issue *jira.Issue) ([]jira.Issue, error) {
 	// TODO: make jql this configurable.
 	// format sub-tasks in epic.
-	var issuesInEpic []jira.Issue
 	if len(issue.Fields.Subtasks) > 0 {
 		subKeys := make([]string, 0, len(issue.Fields.Subtasks))
 		for _, subtask := range issue.Fields.Subtasks {
 			subKeys = append(subKeys, subtask.Key)
 		}
-		issuesInEpic = queryJiraIssuesWithOptions(fmt.Sprintf(`"Epic Link" = %s AND %s OR (key in (%v) AND %s)`,
+		return queryJiraIssuesWithOptions(fmt.Sprintf(`"Epic Link" = %s AND %s OR (key in (%v) AND %s)`,
 			issue.Key, config.Jira.WeeklyPersonalIssues, strings.Join(subKeys, ","), config.Jira.WeeklyPersonalIssues), &allFieldsOpts)
-	} else {
-		issuesInEpic = queryJiraIssuesWithOptions(fmt.Sprintf(`"Epic Link" = %s AND %s`, issue.Key, config.Jira.WeeklyPersonalIssues), &allFieldsOpts)
 	}
-
-	formatUnorderedListIssuesForHtmlOutput(buf, issue, issuesInEpic, repeatChecker)
+	return queryJiraIssuesWithOptions(fmt.Sprintf(`"Epic Link" = %s AND %s`, issue.Key, config.Jira.WeeklyPersonalIssues), &allFieldsOpts)
 }
 
-func formatJiraIssueToExpandForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue, parentIssue *jira.Issue) {
+func formatJiraIssueToExpandForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue, parentIssue *jira.Issue) error {
 	// start of the expand
 	buf.WriteString(fmt.Sprintf(`
 	<ac:structured-macro ac:name="expand" ac:schema-version="1">
@@ -237,17 +213,26 @@ func formatJiraIssueToExpandForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue,
 	</ac:structured-macro></p>
 	`, config.Jira.Server, issue.Key, issue.Key, config.Jira.ServerID))
 
-	var epicIssues []jira.Issue
+	var (
+		epicIssues []jira.Issue
+		err        error
+	)
 	if parentIssue != nil {
-		epicIssues = queryJiraIssues(fmt.Sprintf(`issue in linkedIssues(%s) AND type != "Version Release" and type = Epic and key != %s`, issue.Key, parentIssue.Key))
+		epicIssues, err = queryJiraIssues(fmt.Sprintf(`issue in linkedIssues(%s) AND type != "Version Release" and type = Epic and key != %s`, issue.Key, parentIssue.Key))
 	} else {
-		epicIssues = queryJiraIssues(fmt.Sprintf(`issue in linkedIssues(%s) AND type != "Version Release" and type = Epic`, issue.Key))
+		epicIssues, err = queryJiraIssues(fmt.Sprintf(`issue in linkedIssues(%s) AND type != "Version Release" and type = Epic`, issue.Key))
+	}
+
+	if err != nil {
+		return errors.Trace(err)
 	}
 
 	for _, epicIssue := range epicIssues {
 		// make expands for epic issue.
 		formatJiraIssueForHtmlOutput(buf, &epicIssue)
-		formatJiraIssueToExpandForHtmlOutput(buf, &epicIssue, issue)
+		if err := formatJiraIssueToExpandForHtmlOutput(buf, &epicIssue, issue); err != nil {
+			return errors.Trace(err)
+		}
 	}
 
 	// end of the expand
@@ -255,56 +240,7 @@ func formatJiraIssueToExpandForHtmlOutput(buf *bytes.Buffer, issue *jira.Issue,
 	</ac:rich-text-body>
 	</ac:structured-macro>
 	`)
-}
-
-func formatGitHubIssueForHtmlOutput(issue github.Issue) string {
-	isFromTeam := false
-	login := issue.GetUser().GetLogin()
-
-	for _, id := range allSQLInfraMembers {
-		if strings.EqualFold(id, login) {
-			isFromTeam = true
-			break
-		}
-	}
-
-	var labelColor = jiraLabelColorGrey
-	if issue.GetState() == "closed" {
-		labelColor = jiraLabelColorGreen
-	}
-
-	s := fmt.Sprintf(
-		`%s <a href="%s">%s</a> by @%s`,
-		formatLabelForHtmlOutput(regexRepo.FindStringSubmatch(issue.GetHTMLURL())[1], labelColor),
-		issue.GetHTMLURL(),
-		html.EscapeString(issue.GetTitle()),
-		html.EscapeString(issue.GetUser().GetLogin()),
-	)
-
-	if issue.Assignees != nil && len(issue.Assignees) > 0 {
-		s += fmt.Sprintf(", assigned to")
-		for _, assigne := range issue.Assignees {
-			s += fmt.Sprintf(" @%s", assigne.GetLogin())
-		}
-	}
-
-	if !isFromTeam {
-		s += " " + formatLabelForHtmlOutput("Community", jiraLabelColorBlue)
-	}
-
-	return s
-}
-
-func formatGitHubIssuesForHtmlOutput(buf *bytes.Buffer, issues []github.Issue) {
-	if len(issues) == 0 {
-		buf.WriteString("<p><i>None</i></p>\n")
-		return
-	}
-	buf.WriteString("<ul>")
-	for _, issue := range issues {
-		buf.WriteString(fmt.Sprintf("<li>%s</li>\n", formatGitHubIssueForHtmlOutput(issue)))
-	}
-	buf.WriteString("</ul>")
+	return nil
 }
 
 func genWeeklyReportToc(buf *bytes.Buffer) {
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// The record/replay transports sit under the GitHub, Jira, Confluence and
+// Slack clients. The exchanges are stored one file per request, keyed by
+// the method, URL and body of the request, so the concurrent fetches can be
+// replayed in any order. The request headers are never stored, since they
+// carry the credentials, and neither is the token in the Slack form bodies.
+
+const recordClockFile = "clock.json"
+
+type httpExchange struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	Body        string      `json:"body"`
+}
+
+func exchangeKey(method, url string, body []byte) string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s %s\n", method, url)
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))[:16]
+}
+
+// exchangeFiles counts the requests per key, the same request may be sent
+// more than once, e.g. looking up a page before and after creating it.
+type exchangeFiles struct {
+	dir string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (f *exchangeFiles) next(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[key]++
+	return path.Join(f.dir, fmt.Sprintf("%s-%d.json", key, f.counts[key]))
+}
+
+// redactedFormFields are the form values which carry the credentials.
+var redactedFormFields = []string{"token"}
+
+// redactRequestBody hides the credentials in the form body of the request,
+// the exchanges are stored and keyed by the redacted body.
+func redactRequestBody(req *http.Request, body []byte) []byte {
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return body
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return body
+	}
+	for _, field := range redactedFormFields {
+		if _, ok := values[field]; ok {
+			values.Set(field, "REDACTED")
+		}
+	}
+	return []byte(values.Encode())
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+type recordTransport struct {
+	transport http.RoundTripper
+	files     *exchangeFiles
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	storedBody := redactRequestBody(req, reqBody)
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	exchange := httpExchange{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: string(storedBody),
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		Body:        string(body),
+	}
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	file := t.files.next(exchangeKey(req.Method, exchange.URL, storedBody))
+	if err = ioutil.WriteFile(file, data, 0600); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return resp, nil
+}
+
+type replayTransport struct {
+	files *exchangeFiles
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	file := t.files.next(exchangeKey(req.Method, req.URL.String(), redactRequestBody(req, reqBody)))
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, errors.NotFoundf("recorded exchange for %s %s", req.Method, req.URL)
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var exchange httpExchange
+	if err = json.Unmarshal(data, &exchange); err != nil {
+		return nil, errors.Annotatef(err, "exchange:%s", file)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.StatusCode, http.StatusText(exchange.StatusCode)),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          ioutil.NopCloser(bytes.NewBufferString(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
+
+// newRecordTransport records the exchanges sent through transport to dir, and
+// saves the current time, so the replay generates the same queries.
+func newRecordTransport(dir string, now time.Time, transport http.RoundTripper) (http.RoundTripper, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := json.Marshal(now)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = ioutil.WriteFile(path.Join(dir, recordClockFile), data, 0600); err != nil {
+		return nil, errors.Trace(err)
+	}
+	files := &exchangeFiles{dir: dir, counts: make(map[string]int)}
+	return &recordTransport{transport: transport, files: files}, nil
+}
+
+// newReplayTransport serves the exchanges recorded in dir, and returns
+// the time of the recording.
+func newReplayTransport(dir string) (http.RoundTripper, time.Time, error) {
+	var now time.Time
+	data, err := ioutil.ReadFile(path.Join(dir, recordClockFile))
+	if err != nil {
+		return nil, now, errors.Trace(err)
+	}
+	if err = json.Unmarshal(data, &now); err != nil {
+		return nil, now, errors.Trace(err)
+	}
+	files := &exchangeFiles{dir: dir, counts: make(map[string]int)}
+	return &replayTransport{files: files}, now, nil
+}
+
+// reportClock is the time of the report, it's the recorded time in the replay mode.
+var reportClock = time.Now
+
+func initHTTPTransport() (http.RoundTripper, error) {
+	if len(recordDir) > 0 && len(replayDir) > 0 {
+		return nil, errors.New("--record and --replay can't be used together")
+	}
+	if len(recordDir) > 0 {
+		now := time.Now()
+		reportClock = func() time.Time { return now }
+		return newRecordTransport(recordDir, now, http.DefaultTransport)
+	}
+	if len(replayDir) > 0 {
+		transport, now, err := newReplayTransport(replayDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		reportClock = func() time.Time { return now }
+		return transport, nil
+	}
+	return http.DefaultTransport, nil
+}
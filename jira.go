@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/juju/errors"
+	"github.com/ngaut/log"
 
 	jira "github.com/andygrunwald/go-jira"
 )
@@ -13,169 +16,193 @@ import (
 const (
 	dayFormat  = "2006-01-02"
 	dateFormat = "2006-01-02T15:04:05Z07:00"
-	// We use one week for a sprint
-	sprintDuration = 7 * 24 * time.Hour
+	// jiraPageSize is the page size we ask for, the server may cap it lower.
+	jiraPageSize = 1000
+	// jiraChangelogPageSize is the most histories Jira returns in a page, and in the search result.
+	jiraChangelogPageSize = 100
 )
 
-// Get the board ID by project and boardType.
-// Here we assume that you must create a board in the project and
-// the function will return the first board ID.
-func getBoardID(project string, boardType string) int {
-	opts := jira.BoardListOptions{
-		BoardType:      boardType,
-		ProjectKeyOrID: project,
+const (
+	defaultInProgressStatusCategory = "indeterminate"
+	defaultDueSoonDays              = 2
+	// defaultTimeTrackingDayHours is the Jira default, 1d is equal to 8h.
+	defaultTimeTrackingDayHours = 8
+)
+
+func (j *Jira) adjust() {
+	if len(j.InProgressStatuses) == 0 && len(j.InProgressStatusCategories) == 0 {
+		j.InProgressStatusCategories = []string{defaultInProgressStatusCategory}
+	}
+	if j.DueSoonDays <= 0 {
+		j.DueSoonDays = defaultDueSoonDays
+	}
+	if j.TimeTrackingDayHours <= 0 {
+		j.TimeTrackingDayHours = defaultTimeTrackingDayHours
 	}
+}
 
-	boards, _, err := jiraClient.Board.GetAllBoards(&opts)
-	perror(errors.Trace(err))
+// inProgressJQL returns the JQL condition which matches the in progress issues.
+func (j *Jira) inProgressJQL() string {
+	var conds []string
+	if len(j.InProgressStatuses) > 0 {
+		conds = append(conds, fmt.Sprintf("status in (%s)", quoteJQLValues(j.InProgressStatuses)))
+	}
+	if len(j.InProgressStatusCategories) > 0 {
+		conds = append(conds, fmt.Sprintf("statusCategory in (%s)", quoteJQLValues(j.InProgressStatusCategories)))
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
 
-	return boards.Values[0].ID
+func quoteJQLValues(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	return strings.Join(quoted, ",")
 }
 
-func getSprints(boardID int, state string) []jira.Sprint {
-	opts := jira.GetAllSprintsOptions{
-		State: state,
+// Get the board ID by project, boardType and name.
+// If the name is empty, the project must have only one board of the boardType.
+func getBoardID(project string, boardType string, name string) (int, error) {
+	opts := jira.BoardListOptions{
+		BoardType:      boardType,
+		Name:           name,
+		ProjectKeyOrID: project,
 	}
 
-	// TODO: support pagination
-	sprints, _, err := jiraClient.Board.GetAllSprintsWithOptions(boardID, &opts)
-	perror(errors.Trace(err))
+	var boards []jira.Board
+	for {
+		page, err := sprintManager.GetAllBoards(&opts)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		boards = append(boards, page.Values...)
+		if page.IsLast || len(page.Values) == 0 {
+			break
+		}
+		opts.StartAt += len(page.Values)
+	}
 
-	return sprints.Values
-}
+	// The name option matches partially.
+	var matched []jira.Board
+	for _, board := range boards {
+		if len(name) == 0 || board.Name == name {
+			matched = append(matched, board)
+		}
+	}
+	if len(matched) == 0 {
+		return 0, errors.NotFoundf("%s board %q of project %s", boardType, name, project)
+	}
+	if len(matched) > 1 {
+		return 0, errors.Errorf("project %s has %d %s boards, please specify the board name", project, len(matched), boardType)
+	}
 
-// Returns the only active sprint
-func getActiveSprint(boardID int) jira.Sprint {
-	sprints := getSprints(boardID, "active")
-	return sprints[0]
+	return matched[0].ID, nil
 }
 
-func getLatestPassedSprint(boardID int) *jira.Sprint {
-	sprints, _, err := jiraClient.Board.GetAllSprints(strconv.Itoa(boardID))
-	perror(errors.Trace(err))
+func getSprints(boardID int, state string) ([]jira.Sprint, error) {
+	opts := jira.GetAllSprintsOptions{
+		State: state,
+	}
 
-	now := time.Now()
-	minDiff := time.Hour * 7 * 24
-	var minSprint *jira.Sprint
-	for idx, sprint := range sprints {
-		// 1. Sprint Start Date < Now
-		// 2. Sprint End Date < Now
-		// 3. Min(Now - Sprint End Date)
-		if sprint.StartDate.After(now) {
-			continue
+	var sprints []jira.Sprint
+	for {
+		page, err := sprintManager.GetAllSprints(boardID, &opts)
+		if err != nil {
+			return nil, errors.Annotatef(err, "board:%d", boardID)
 		}
-		if sprint.EndDate.After(now) {
-			continue
-		}
-		diff := now.Sub(*sprint.EndDate)
-		if diff < minDiff {
-			minSprint = &sprints[idx]
+		sprints = append(sprints, page.Values...)
+		if page.IsLast || len(page.Values) == 0 {
+			break
 		}
+		opts.StartAt += len(page.Values)
 	}
 
-	return minSprint
+	return sprints, nil
 }
 
-func getNearestFutureSprint(boardID int) *jira.Sprint {
-	sprints, _, err := jiraClient.Board.GetAllSprints(strconv.Itoa(boardID))
-	perror(errors.Trace(err))
+// Returns the last closed sprint, or nil if there is no closed sprint.
+func getLatestClosedSprint(boardID int) (*jira.Sprint, error) {
+	sprints, err := getSprints(boardID, "closed")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
-	now := time.Now()
-	minDiff := time.Hour * 7 * 24
-	var minSprint *jira.Sprint
+	var latest *jira.Sprint
 	for idx, sprint := range sprints {
-		// 1. Sprint End Date > Now
-		// 2. Min(Sprint Start Date - Now)
-		if sprint.EndDate.Before(now) {
+		if sprint.EndDate == nil {
 			continue
 		}
-		diff := (*sprint.StartDate).Sub(now)
-		if diff < minDiff {
-			minSprint = &sprints[idx]
+		if latest == nil || sprint.EndDate.After(*latest.EndDate) {
+			latest = &sprints[idx]
 		}
 	}
-
-	return minSprint
+	return latest, nil
 }
 
-func createSprint(boardID int, name string, startDate, endDate string) jira.Sprint {
-	apiEndpoint := "rest/agile/1.0/sprint"
-	sprint := map[string]string{
-		"name":          name,
-		"startDate":     startDate,
-		"endDate":       endDate,
-		"originBoardId": strconv.Itoa(boardID),
+func createSprint(boardID int, name string, startDate, endDate string) (jira.Sprint, error) {
+	sprint, err := sprintManager.CreateSprint(boardID, name, startDate, endDate)
+	if err != nil {
+		return jira.Sprint{}, errors.Annotatef(err, "sprint:%s", name)
 	}
-	req, err := jiraClient.NewRequest("POST", apiEndpoint, sprint)
-	perror(errors.Trace(err))
-
-	responseSprint := new(jira.Sprint)
-	_, err = jiraClient.Do(req, responseSprint)
-	perror(errors.Trace(err))
 
-	return *responseSprint
+	return sprint, nil
 }
 
-func createNextSprint(boardID int, startDate time.Time) jira.Sprint {
+// createNextSprint returns the sprint starts at startDate, it creates the sprint
+// if the sprint doesn't exist.
+func createNextSprint(boardID int, startDate time.Time) (jira.Sprint, error) {
 	// We assuem the sprint starts at 00:00 and ends at 00:00
 	// E.g, current sprint time range is 2018-09-28T00:00:00+08:00 2018-10-05T00:00:00+08:00
 	// So the next sprint is 2018-10-05T00:00:00+08:00, 2018-10-12T00:00:00+08:00
 	// The sprint name is 2018-10-05 - 2018-10-11
-	endDate := startDate.Add(sprintDuration)
+	startDate = startDate.In(config.Sprint.location())
+	endDate := startDate.AddDate(0, 0, config.Sprint.Days)
 
-	name := fmt.Sprintf("%s - %s", startDate.Format(dayFormat), endDate.Add(-time.Second).Format(dayFormat))
+	name := config.Sprint.sprintName(startDate, endDate)
 
-	sprints := getSprints(boardID, "future")
+	sprints, err := getSprints(boardID, "future")
+	if err != nil {
+		return jira.Sprint{}, errors.Trace(err)
+	}
 	for _, sprint := range sprints {
 		if sprint.Name == name {
-			return sprint
+			return sprint, nil
 		}
 	}
 
 	return createSprint(boardID, name, startDate.Format(dateFormat), endDate.Format(dateFormat))
 }
 
-func deleteSprint(sprintID int) {
-	apiEndpoint := "rest/agile/1.0/sprint/" + strconv.Itoa(sprintID)
-	req, err := jiraClient.NewRequest("DELETE", apiEndpoint, nil)
-	perror(errors.Trace(err))
-
-	_, err = jiraClient.Do(req, nil)
-	perror(errors.Trace(err))
+func deleteSprint(sprintID int) error {
+	err := sprintManager.DeleteSprint(sprintID)
+	return errors.Annotatef(err, "sprint:%d", sprintID)
 }
 
-func updateSprintTime(sprintID int, startDate, endDate string) jira.Sprint {
+func updateSprintTime(sprintID int, startDate, endDate string) (jira.Sprint, error) {
 	return updateSprint(sprintID, map[string]string{
 		"startDate": startDate,
 		"endDate":   endDate,
 	})
 }
 
-func updateSprintState(sprintID int, state string) jira.Sprint {
+func updateSprintState(sprintID int, state string) (jira.Sprint, error) {
 	return updateSprint(sprintID, map[string]string{
 		"state": state,
 	})
 }
 
-func updateSprint(sprintID int, args map[string]string) jira.Sprint {
-	apiEndpoint := "rest/agile/1.0/sprint/" + strconv.Itoa(sprintID)
-
-	req, err := jiraClient.NewRequest("POST", apiEndpoint, args)
-	perror(errors.Trace(err))
-
-	responseSprint := new(jira.Sprint)
-	_, err = jiraClient.Do(req, responseSprint)
-	perror(errors.Trace(err))
+func updateSprint(sprintID int, args map[string]string) (jira.Sprint, error) {
+	sprint, err := sprintManager.UpdateSprint(sprintID, args)
+	if err != nil {
+		return jira.Sprint{}, errors.Annotatef(err, "sprint:%d", sprintID)
+	}
 
-	return *responseSprint
+	return sprint, nil
 }
 
 // A pagination-aware alternative for SprintService.MoveIssuesToSprint.
-//
-// https://developer.atlassian.com/cloud/jira/software/rest/#api-rest-agile-1-0-sprint-sprintId-issue-post
-func moveIssuesToSprint(sprintID int, issues []jira.Issue) {
-	apiEndpoint := fmt.Sprintf("rest/agile/1.0/sprint/%d/issue", sprintID)
-
+func moveIssuesToSprint(sprintID int, issues []jira.Issue) error {
 	// The maximum number of issues that can be moved in one operation is 50.
 	batchMax := 50
 	buffer := make([]string, 0, batchMax)
@@ -183,41 +210,84 @@ func moveIssuesToSprint(sprintID int, issues []jira.Issue) {
 	for idx, ise := range issues {
 		buffer = append(buffer, ise.ID)
 		if len(buffer) == batchMax || idx+1 == total {
-			payload := jira.IssuesWrapper{Issues: buffer}
-			req, err := jiraClient.NewRequest("POST", apiEndpoint, payload)
-			perror(errors.Trace(err))
-			_, err = jiraClient.Do(req, nil)
-			perror(errors.Trace(err))
+			err := sprintManager.MoveIssuesToSprint(sprintID, buffer)
+			if err != nil {
+				return errors.Annotatef(err, "sprint:%d", sprintID)
+			}
 
 			// clear buffer
 			buffer = buffer[:0]
 		}
 	}
+	return nil
 }
 
-func queryJiraIssues(jql string) []jira.Issue {
-	issues, _, err := jiraClient.Issue.Search(jql, &jira.SearchOptions{
-		MaxResults: 1000,
-	})
-	if err != nil {
-		perror(errors.Annotate(err, fmt.Sprintf("jql:%s", jql)))
+func queryJiraIssues(jql string) ([]jira.Issue, error) {
+	return queryJiraIssuesWithOptions(jql, nil)
+}
+
+// queryJiraIssuesWithOptions returns all the issues page by page,
+// opts.StartAt is ignored and opts.MaxResults is used as the page size.
+func queryJiraIssuesWithOptions(jql string, opts *jira.SearchOptions) ([]jira.Issue, error) {
+	var pageOpts jira.SearchOptions
+	if opts != nil {
+		pageOpts = *opts
 	}
-	return issues
+	pageOpts.StartAt = 0
+	if pageOpts.MaxResults <= 0 {
+		pageOpts.MaxResults = jiraPageSize
+	}
+
+	var allIssues []jira.Issue
+	warned := false
+	retryCount := 0
+	for {
+		jiraRateGate.wait()
+		issues, resp, err := issueSearcher.Search(jql, &pageOpts)
+		if dur, ok := jiraRetryAfter(resp); ok && retryCount < 10 {
+			retryCount++
+			log.Warnf("jira rate limit exceeded, wait %s and retry %d", dur, retryCount)
+			jiraRateGate.pauseUntil(time.Now().Add(dur))
+			continue
+		}
+		if err != nil {
+			return nil, errors.Annotate(err, fmt.Sprintf("jql:%s", jql))
+		}
+		allIssues = append(allIssues, issues...)
+
+		if len(issues) == 0 || len(allIssues) >= resp.Total {
+			break
+		}
+		if !warned && len(issues) < pageOpts.MaxResults {
+			log.Warnf("jira server truncates the page to %d issues (asked %d, total %d), fetch the remaining pages, jql:%s",
+				len(issues), pageOpts.MaxResults, resp.Total, jql)
+			warned = true
+		}
+		pageOpts.StartAt += len(issues)
+	}
+	return allIssues, nil
 }
 
-func queryJiraIssuesWithOptions(jql string, opts *jira.SearchOptions) []jira.Issue {
-	issues, _, err := jiraClient.Issue.Search(jql, opts)
-	if err != nil {
-		perror(errors.Annotate(err, fmt.Sprintf("jql:%s", jql)))
+// jiraRetryAfter returns how long to wait if the server responds 429 Too Many Requests.
+func jiraRetryAfter(resp *jira.Response) (time.Duration, bool) {
+	if resp == nil || resp.Response == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
 	}
-	return issues
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 10 * time.Second, true
 }
 
-func getIssueWorklogs(key string) []jira.WorklogRecord {
-	workLogs, _, err := jiraClient.Issue.GetWorklogs(key)
+func getIssueWorklogs(key string) ([]jira.WorklogRecord, error) {
+	workLogs, err := issueSearcher.GetWorklogs(key)
 	if err != nil {
-		perror(errors.Annotate(err, fmt.Sprintf("issue key:%s", key)))
+		return nil, errors.Annotate(err, fmt.Sprintf("issue key:%s", key))
 	}
 
-	return workLogs.Worklogs
+	return workLogs.Worklogs, nil
+}
+
+func parseSprintDate(date string) (time.Time, error) {
+	return time.Parse(dateFormat, date)
 }
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/juju/errors"
+	"github.com/spf13/cobra"
+)
+
+// jiraChangelogTimeFormat is the time format of the changelog histories.
+const jiraChangelogTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+const jiraStatusCategoryDone = "done"
+
+// JiraIssueTiming is the status timeline of a resolved issue, in days.
+type JiraIssueTiming struct {
+	Key      string    `json:"key"`
+	Type     string    `json:"type"`
+	Epic     string    `json:"epic"`
+	Assignee string    `json:"assignee"`
+	Created  time.Time `json:"created"`
+	Resolved time.Time `json:"resolved"`
+	// Started is the first time the issue was in progress.
+	Started    *time.Time         `json:"started,omitempty"`
+	LeadDays   float64            `json:"lead_days"`
+	CycleDays  *float64           `json:"cycle_days,omitempty"`
+	StatusDays map[string]float64 `json:"status_days"`
+}
+
+// JiraTimeGroup is the lead time, the cycle time and the average days in
+// every status of the issues of a member, an issue type or an epic.
+type JiraTimeGroup struct {
+	By         string             `json:"by"`
+	Name       string             `json:"name"`
+	Issues     int                `json:"issues"`
+	LeadDays   Percentiles        `json:"lead_days"`
+	CycleDays  Percentiles        `json:"cycle_days"`
+	StatusDays map[string]float64 `json:"status_days"`
+}
+
+// JiraMetrics is the metrics of the issues resolved in [Start, End].
+type JiraMetrics struct {
+	Team  string `json:"team"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Statuses are the status columns, in the order of the status categories.
+	Statuses []string          `json:"statuses"`
+	Groups   []JiraTimeGroup   `json:"groups"`
+	Issues   []JiraIssueTiming `json:"issues"`
+}
+
+// jiraStatusCategories maps the status names to the status category keys.
+type jiraStatusCategories map[string]string
+
+func getJiraStatusCategories() (jiraStatusCategories, error) {
+	statuses, err := issueSearcher.GetStatuses()
+	if err != nil {
+		return nil, errors.Annotate(err, "jira statuses")
+	}
+	categories := make(jiraStatusCategories, len(statuses))
+	for _, status := range statuses {
+		categories[strings.ToLower(status.Name)] = status.StatusCategory.Key
+	}
+	return categories, nil
+}
+
+// inProgress matches the statuses like config.Jira.inProgressJQL does.
+func (c jiraStatusCategories) inProgress(status string) bool {
+	for _, s := range config.Jira.InProgressStatuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	category := c[strings.ToLower(status)]
+	for _, key := range config.Jira.InProgressStatusCategories {
+		if strings.EqualFold(key, category) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c jiraStatusCategories) done(status string) bool {
+	return c[strings.ToLower(status)] == jiraStatusCategoryDone
+}
+
+// order sorts the statuses by the category, to do, in progress and then done.
+func (c jiraStatusCategories) order(statuses []string) {
+	rank := func(status string) int {
+		switch c[strings.ToLower(status)] {
+		case "new":
+			return 0
+		case jiraStatusCategoryDone:
+			return 2
+		}
+		return 1
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if ri, rj := rank(statuses[i]), rank(statuses[j]); ri != rj {
+			return ri < rj
+		}
+		return statuses[i] < statuses[j]
+	})
+}
+
+func jiraIssueEpic(issue jira.Issue) string {
+	if len(config.Jira.EpicLinkField) > 0 {
+		if epic, ok := issue.Fields.Unknowns[config.Jira.EpicLinkField].(string); ok && len(epic) > 0 {
+			return epic
+		}
+	} else if issue.Fields.Epic != nil && len(issue.Fields.Epic.Key) > 0 {
+		return issue.Fields.Epic.Key
+	}
+	return "No Epic"
+}
+
+type jiraStatusChange struct {
+	time time.Time
+	from string
+	to   string
+}
+
+// jiraStatusChanges returns the status changes in time order.
+func jiraStatusChanges(issue jira.Issue) ([]jiraStatusChange, error) {
+	if issue.Changelog == nil {
+		return nil, nil
+	}
+	var changes []jiraStatusChange
+	for _, history := range issue.Changelog.Histories {
+		for _, item := range history.Items {
+			if item.Field != "status" {
+				continue
+			}
+			t, err := time.Parse(jiraChangelogTimeFormat, history.Created)
+			if err != nil {
+				return nil, errors.Annotatef(err, "changelog of %s", issue.Key)
+			}
+			changes = append(changes, jiraStatusChange{time: t, from: item.FromString, to: item.ToString})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].time.Before(changes[j].time) })
+	return changes, nil
+}
+
+func days(d time.Duration) float64 {
+	return d.Hours() / 24
+}
+
+// newJiraIssueTiming replays the status changes from the creation to the
+// resolution, the time after the resolution is not counted.
+func newJiraIssueTiming(team *Team, issue jira.Issue, categories jiraStatusCategories) (JiraIssueTiming, error) {
+	timing := JiraIssueTiming{
+		Key:        issue.Key,
+		Type:       issue.Fields.Type.Name,
+		Epic:       jiraIssueEpic(issue),
+		Assignee:   "Unassigned",
+		Created:    time.Time(issue.Fields.Created),
+		Resolved:   time.Time(issue.Fields.Resolutiondate),
+		StatusDays: make(map[string]float64),
+	}
+	if issue.Fields.Assignee != nil {
+		timing.Assignee = teamMemberName(team, ReportPerson{
+			Name:  issue.Fields.Assignee.DisplayName,
+			Email: issue.Fields.Assignee.EmailAddress,
+		})
+	}
+
+	changes, err := jiraStatusChanges(issue)
+	if err != nil {
+		return timing, errors.Trace(err)
+	}
+	status := ""
+	if issue.Fields.Status != nil {
+		status = issue.Fields.Status.Name
+	}
+	if len(changes) > 0 {
+		status = changes[0].from
+	}
+	if timing.Resolved.IsZero() {
+		// The issue is done without a resolution, it's resolved by the last change to done.
+		for _, change := range changes {
+			if categories.done(change.to) {
+				timing.Resolved = change.time
+			}
+		}
+	}
+
+	since := timing.Created
+	for _, change := range changes {
+		if change.time.After(timing.Resolved) {
+			break
+		}
+		if len(status) > 0 {
+			timing.StatusDays[status] += days(change.time.Sub(since))
+		}
+		if timing.Started == nil && categories.inProgress(change.to) {
+			started := change.time
+			timing.Started = &started
+		}
+		status, since = change.to, change.time
+	}
+	if len(status) > 0 && !categories.done(status) && since.Before(timing.Resolved) {
+		timing.StatusDays[status] += days(timing.Resolved.Sub(since))
+	}
+
+	timing.LeadDays = days(timing.Resolved.Sub(timing.Created))
+	if timing.Started != nil {
+		cycle := days(timing.Resolved.Sub(*timing.Started))
+		timing.CycleDays = &cycle
+	}
+	return timing, nil
+}
+
+// newJiraTimeGroups groups the timings by the key of every timing.
+func newJiraTimeGroups(by string, timings []JiraIssueTiming, key func(JiraIssueTiming) string) []JiraTimeGroup {
+	grouped := make(map[string][]JiraIssueTiming)
+	var names []string
+	for _, timing := range timings {
+		name := key(timing)
+		if _, ok := grouped[name]; !ok {
+			names = append(names, name)
+		}
+		grouped[name] = append(grouped[name], timing)
+	}
+	sort.Strings(names)
+
+	groups := make([]JiraTimeGroup, 0, len(names))
+	for _, name := range names {
+		var lead, cycle []float64
+		statusDays := make(map[string]float64)
+		for _, timing := range grouped[name] {
+			lead = append(lead, timing.LeadDays)
+			if timing.CycleDays != nil {
+				cycle = append(cycle, *timing.CycleDays)
+			}
+			for status, d := range timing.StatusDays {
+				statusDays[status] += d
+			}
+		}
+		for status := range statusDays {
+			statusDays[status] /= float64(len(grouped[name]))
+		}
+		groups = append(groups, JiraTimeGroup{
+			By:         by,
+			Name:       name,
+			Issues:     len(grouped[name]),
+			LeadDays:   newPercentiles(lead),
+			CycleDays:  newPercentiles(cycle),
+			StatusDays: statusDays,
+		})
+	}
+	return groups
+}
+
+// collectJiraMetrics expands the changelogs of the team's issues resolved in
+// [start, end], the issues failed to replay are recorded to summary and skipped.
+func collectJiraMetrics(team *Team, start string, end string, summary *RunSummary) (*JiraMetrics, error) {
+	categories, err := getJiraStatusCategories()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	members := strings.Join(team.QuotedEmails(), ",")
+	jql := fmt.Sprintf(`assignee in (%s) AND resolved >= "%s" AND resolved < "%s" ORDER BY resolved`,
+		members, start, nextDay(end))
+	opts := allFieldsOpts
+	opts.Expand = "changelog"
+	issues, err := queryJiraIssuesWithOptions(jql, &opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	errs := completeJiraChangelogs(issues)
+
+	metrics := &JiraMetrics{Team: team.Name, Start: start, End: end, Issues: []JiraIssueTiming{}}
+	seen := make(map[string]bool)
+	for idx, issue := range issues {
+		if issue.Fields == nil {
+			continue
+		}
+		if summary.Record(issue.Key+" changelog", errs[idx]) {
+			continue
+		}
+		timing, err := newJiraIssueTiming(team, issue, categories)
+		if summary.Record(issue.Key, err) {
+			continue
+		}
+		metrics.Issues = append(metrics.Issues, timing)
+		for status := range timing.StatusDays {
+			if !seen[status] {
+				seen[status] = true
+				metrics.Statuses = append(metrics.Statuses, status)
+			}
+		}
+	}
+	categories.order(metrics.Statuses)
+
+	keys := map[string]func(JiraIssueTiming) string{
+		"member": func(t JiraIssueTiming) string { return t.Assignee },
+		"type":   func(t JiraIssueTiming) string { return t.Type },
+		"epic":   func(t JiraIssueTiming) string { return t.Epic },
+	}
+	for _, by := range metrics.Groupings() {
+		metrics.Groups = append(metrics.Groups, newJiraTimeGroups(by, metrics.Issues, keys[by])...)
+	}
+	return metrics, nil
+}
+
+// completeJiraChangelogs fetches all the histories of the issues which have
+// a full page of histories in the search result, the errors are per issue.
+func completeJiraChangelogs(issues []jira.Issue) []error {
+	errs := make([]error, len(issues))
+	runParallel(len(issues), func(idx int) {
+		changelog := issues[idx].Changelog
+		if changelog == nil || len(changelog.Histories) < jiraChangelogPageSize {
+			return
+		}
+		histories, err := issueSearcher.GetChangelog(issues[idx].Key)
+		if err != nil {
+			errs[idx] = errors.Annotatef(err, "issue key:%s", issues[idx].Key)
+			return
+		}
+		changelog.Histories = histories
+	})
+	return errs
+}
+
+// nextDay returns the day after the day, the end day of the JQL range is exclusive.
+func nextDay(day string) string {
+	t, err := time.Parse(dayFormat, day)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, 1).Format(dayFormat)
+}
+
+// Groupings are the ways the issues are grouped by.
+func (m *JiraMetrics) Groupings() []string {
+	return []string{"member", "type", "epic"}
+}
+
+// GroupsBy returns the groups of one grouping.
+func (m *JiraMetrics) GroupsBy(by string) []JiraTimeGroup {
+	var groups []JiraTimeGroup
+	for _, group := range m.Groups {
+		if group.By == by {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+var metricsCSV string
+
+func newMetricsJiraCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "jira",
+		Short: "Jira Lead Time And Cycle Time",
+		Run:   runMetricsJiraCommandFunc,
+	}
+	m.Flags().StringVar(&metricsCSV, "csv", "", "Write the metrics to the CSV file too")
+	return m
+}
+
+func runMetricsJiraCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("metrics jira")
+	start, end, err := metricsDateRange()
+	if summary.Record("date range", err) {
+		summary.Exit()
+	}
+
+	var all []*JiraMetrics
+	for _, team := range teams {
+		metrics, err := collectJiraMetrics(team, start, end, summary)
+		if summary.Record(team.Name, err) {
+			continue
+		}
+		all = append(all, metrics)
+		summary.Record(team.Name+" snapshot", saveDataSnapshot(team, snapshotKindJiraMetrics, metrics, jiraSnapshotMetrics(metrics)))
+		if len(config.Metrics.Parent) == 0 || printToConsole {
+			summary.Record(team.Name+" output", renderJiraMetrics(os.Stdout, reportFormat, metrics))
+			continue
+		}
+		var body bytes.Buffer
+		err = renderJiraMetrics(&body, reportFormatConfluence, metrics)
+		if !summary.Record(team.Name+" output", err) {
+			title := fmt.Sprintf("%s Jira Metrics %s ~ %s", team.Name, start, end)
+			summary.Record(title, publishGeneratedPage(team, pageKindJiraMetrics, config.Metrics.Parent, title, body.String()))
+		}
+	}
+	if len(metricsCSV) > 0 {
+		summary.Record(metricsCSV, writeJiraMetricsCSVFile(metricsCSV, all))
+	}
+	summary.Exit()
+}
+
+// renderJiraMetrics renders the metrics as JSON, Confluence tables or text tables.
+func renderJiraMetrics(w io.Writer, format string, metrics *JiraMetrics) error {
+	switch format {
+	case reportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Trace(enc.Encode(metrics))
+	case reportFormatConfluence:
+		return errors.Trace(executeTemplate(w, metrics, "", "jira-metrics.confluence.tmpl"))
+	case "", reportFormatText:
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "%s Jira issues resolved in %s ~ %s, p50 / p90 days\n", metrics.Team, metrics.Start, metrics.End)
+		for _, by := range metrics.Groupings() {
+			buf.WriteString("\n")
+			tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "%s\tIssues\tLead Time\tCycle Time\t%s\n", strings.Title(by), strings.Join(metrics.Statuses, "\t"))
+			for _, group := range metrics.GroupsBy(by) {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", group.Name, group.Issues, group.LeadDays, group.CycleDays,
+					strings.Join(group.statusColumns(metrics.Statuses), "\t"))
+			}
+			tw.Flush()
+		}
+		_, err := w.Write(buf.Bytes())
+		return errors.Trace(err)
+	}
+	return errors.NotSupportedf("metrics format %s, use one of %s,%s,%s", format, reportFormatText, reportFormatJSON, reportFormatConfluence)
+}
+
+// statusColumns returns the average days in the statuses.
+func (g JiraTimeGroup) statusColumns(statuses []string) []string {
+	columns := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		columns = append(columns, fmt.Sprintf("%.1f", g.StatusDays[status]))
+	}
+	return columns
+}
+
+// writeJiraMetricsCSV writes one row per group, the status columns are the
+// average days in the statuses.
+func writeJiraMetricsCSV(w io.Writer, all []*JiraMetrics) error {
+	var statuses []string
+	seen := make(map[string]bool)
+	for _, metrics := range all {
+		for _, status := range metrics.Statuses {
+			if !seen[status] {
+				seen[status] = true
+				statuses = append(statuses, status)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	header := []string{"team", "start", "end", "by", "name", "issues",
+		"lead_days_p50", "lead_days_p90", "cycle_days_p50", "cycle_days_p90"}
+	for _, status := range statuses {
+		header = append(header, status+" days")
+	}
+	if err := cw.Write(header); err != nil {
+		return errors.Trace(err)
+	}
+	for _, metrics := range all {
+		for _, group := range metrics.Groups {
+			row := []string{metrics.Team, metrics.Start, metrics.End, group.By, group.Name, fmt.Sprint(group.Issues)}
+			row = append(row, group.LeadDays.csvColumns()...)
+			row = append(row, group.CycleDays.csvColumns()...)
+			for _, status := range statuses {
+				row = append(row, fmt.Sprintf("%.2f", group.StatusDays[status]))
+			}
+			if err := cw.Write(row); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	cw.Flush()
+	return errors.Trace(cw.Error())
+}
+
+// csvColumns returns the p50 and the p90, they are empty if there is no value.
+func (p Percentiles) csvColumns() []string {
+	if p.Count == 0 {
+		return []string{"", ""}
+	}
+	return []string{fmt.Sprintf("%.2f", p.P50), fmt.Sprintf("%.2f", p.P90)}
+}
+
+func writeJiraMetricsCSVFile(file string, all []*JiraMetrics) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = writeJiraMetricsCSV(f, all); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+func testChangelogHistory(at time.Time, field string, from string, to string) jira.ChangelogHistory {
+	return jira.ChangelogHistory{
+		Created: at.Format(jiraChangelogTimeFormat),
+		Items:   []jira.ChangelogItems{{Field: field, FromString: from, ToString: to}},
+	}
+}
+
+func TestRunMetricsJiraCommand(t *testing.T) {
+	_, jr, cf, _ := setupFakes(t)
+	config.Metrics.Parent = "Metrics"
+	config.Confluence.Labels = []string{"{kind}"}
+	jr.Statuses = []jira.Status{
+		{Name: "To Do", StatusCategory: jira.StatusCategory{Key: "new"}},
+		{Name: "In Progress", StatusCategory: jira.StatusCategory{Key: "indeterminate"}},
+		{Name: "Done", StatusCategory: jira.StatusCategory{Key: "done"}},
+	}
+
+	// The search result has the first page of the histories, the issue is started
+	// by the history after it.
+	created := testNow.AddDate(0, 0, -5)
+	var histories []jira.ChangelogHistory
+	for i := 0; i < jiraChangelogPageSize; i++ {
+		histories = append(histories, testChangelogHistory(created.Add(time.Duration(i)*time.Minute), "summary", "", fmt.Sprint(i)))
+	}
+	full := append(append([]jira.ChangelogHistory{}, histories...),
+		testChangelogHistory(created.AddDate(0, 0, 1), "status", "To Do", "In Progress"),
+		testChangelogHistory(created.AddDate(0, 0, 4), "status", "In Progress", "Done"))
+	issue := testJiraIssue("TIKV-1", "Support TTL", "alice@example.com", "Done", "done")
+	issue.Fields.Created = jira.Time(created)
+	issue.Fields.Resolutiondate = jira.Time(created.AddDate(0, 0, 4))
+	issue.Changelog = &jira.Changelog{Histories: histories}
+	jr.Changelogs["TIKV-1"] = full
+	jr.Issues[`assignee in ("alice@example.com","bob@example.com") AND resolved >= "2026-10-10" AND resolved < "2026-10-17" ORDER BY resolved`] = []jira.Issue{issue}
+	if _, err := createContent(config.Confluence.Space, "", "Metrics", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	summary := newRunSummary("metrics jira")
+	metrics, err := collectJiraMetrics(teams[0], "2026-10-10", "2026-10-16", summary)
+	if err != nil || summary.Failed() {
+		t.Fatalf("collect %v %s", err, summary)
+	}
+	if len(metrics.Issues) != 1 || metrics.Issues[0].CycleDays == nil || *metrics.Issues[0].CycleDays != 3 {
+		t.Fatalf("timings %+v", metrics.Issues)
+	}
+
+	runMetricsJiraCommandFunc(nil, nil)
+	title := "Team Jira Metrics 2026-10-10 ~ 2026-10-16"
+	page, _ := cf.GetContentByTitle(config.Confluence.Space, title)
+	if len(page.Id) == 0 {
+		t.Fatalf("page %q is not created", title)
+	}
+	if body := page.Body.Storage.Value; !strings.Contains(body, "Jira Lead Time And Cycle Time") || !strings.Contains(body, regionBegin("jira-metrics")) {
+		t.Errorf("page body:\n%s", body)
+	}
+	if got := strings.Join(cf.Labels[page.Id], ","); got != pageKindJiraMetrics {
+		t.Errorf("labels %q", got)
+	}
+}
+
+func TestJiraServiceGetChangelog(t *testing.T) {
+	histories := make([]jira.ChangelogHistory, 150)
+	for i := range histories {
+		histories[i] = testChangelogHistory(testNow.Add(time.Duration(i)*time.Minute), "summary", "", fmt.Sprint(i))
+	}
+
+	for _, server := range []bool{false, true} {
+		t.Run(fmt.Sprintf("server %v", server), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/rest/api/2/issue/TIKV-1/changelog") && !server:
+					startAt, _ := strconv.Atoi(r.URL.Query().Get("startAt"))
+					end := startAt + jiraChangelogPageSize
+					if end > len(histories) {
+						end = len(histories)
+					}
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"total":  len(histories),
+						"isLast": end == len(histories),
+						"values": histories[startAt:end],
+					})
+				case strings.HasSuffix(r.URL.Path, "/rest/api/2/issue/TIKV-1") && server:
+					if r.URL.Query().Get("expand") != "changelog" {
+						t.Errorf("query %s", r.URL.RawQuery)
+					}
+					json.NewEncoder(w).Encode(jira.Issue{Key: "TIKV-1", Changelog: &jira.Changelog{Histories: histories}})
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			client, err := jira.NewClient(srv.Client(), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := (&jiraService{client: client}).GetChangelog("TIKV-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(histories) || got[len(got)-1].Created != histories[len(histories)-1].Created {
+				t.Errorf("got %d histories", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/spf13/cobra"
+)
+
+// The kinds of the generated pages, used in the {kind} of the labels.
+const (
+	pageKindWeeklyPersonal = "weekly-personal"
+	pageKindWeeklyTeam     = "weekly-team"
+	pageKindWeeklyDueDate  = "weekly-due-date"
+	pageKindRelease        = "release"
+	pageKindJiraMetrics    = "jira-metrics"
+	pageKindTimesheet      = "timesheet"
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^a-z0-9_.\-]+`)
+
+// pageLabels fills the placeholders of config.Confluence.Labels, the labels are
+// lower-cased, the characters Confluence doesn't allow are replaced by "-", and
+// the empty or duplicate ones are skipped. The team is nil for the pages of no team.
+func pageLabels(team *Team, kind string, member string, now time.Time) []string {
+	year, week := now.ISOWeek()
+	var teamName string
+	if team != nil {
+		teamName = team.Name
+	}
+	replacer := strings.NewReplacer(
+		"{team}", teamName,
+		"{kind}", kind,
+		"{week}", fmt.Sprintf("%d-w%02d", year, week),
+		"{member}", member,
+	)
+	var labels []string
+	seen := make(map[string]bool)
+	for _, label := range config.Confluence.Labels {
+		label = strings.ToLower(replacer.Replace(label))
+		label = strings.Trim(invalidLabelChars.ReplaceAllString(label, "-"), "-")
+		if len(label) == 0 || seen[label] {
+			continue
+		}
+		seen[label] = true
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// pageRestrictions converts config.Confluence.Restrictions, the configured user
+// is always allowed, or the reporter couldn't update its own pages.
+func pageRestrictions() []ContentRestriction {
+	r := config.Confluence.Restrictions
+	var restrictions []ContentRestriction
+	add := func(operation string, users []string, groups []string) {
+		if len(users) == 0 && len(groups) == 0 {
+			return
+		}
+		restriction := ContentRestriction{Operation: operation}
+		restriction.Restrictions.User = []RestrictionUser{}
+		restriction.Restrictions.Group = []RestrictionGroup{}
+		seen := make(map[string]bool)
+		for _, user := range append([]string{config.Confluence.User}, users...) {
+			if len(user) == 0 || seen[user] {
+				continue
+			}
+			seen[user] = true
+			restriction.Restrictions.User = append(restriction.Restrictions.User, RestrictionUser{Type: "known", Username: user})
+		}
+		for _, group := range groups {
+			restriction.Restrictions.Group = append(restriction.Restrictions.Group, RestrictionGroup{Type: "group", Name: group})
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	add("read", r.ViewUsers, r.ViewGroups)
+	add("update", r.EditUsers, r.EditGroups)
+	return restrictions
+}
+
+// applyPageSettings adds the configured labels and restrictions to the generated page.
+func applyPageSettings(content Content, team *Team, kind string, member string) error {
+	if len(content.Id) == 0 {
+		// The page is not created in the dry run.
+		return nil
+	}
+	if labels := pageLabels(team, kind, member, reportClock()); len(labels) > 0 {
+		if err := contentStore.AddLabels(content.Id, labels); err != nil {
+			return errors.Annotatef(err, "label title:%s", content.Title)
+		}
+	}
+	if restrictions := pageRestrictions(); len(restrictions) > 0 {
+		err := contentStore.SetRestrictions(content.Id, restrictions)
+		if errors.IsNotSupported(err) {
+			// The labels are still added on Confluence Server and Data Center.
+			log.Warnf("skip the restrictions of page %q: %v", content.Title, err)
+		} else if err != nil {
+			return errors.Annotatef(err, "restrict title:%s", content.Title)
+		}
+	}
+	return nil
+}
+
+const (
+	archiveActionMove  = "move"
+	archiveActionTrash = "trash"
+	archiveActionGroup = "group"
+)
+
+var (
+	archiveDays   int
+	archiveAction string
+	archiveTo     string
+)
+
+func newArchiveCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "archive",
+		Short: "Archive The Old Pages Under The Weekly Paths",
+		Run:   runArchiveCommandFunc,
+	}
+	m.Flags().IntVar(&archiveDays, "days", 180, "Archive the pages created more than the days ago")
+	m.Flags().StringVar(&archiveAction, "action", archiveActionGroup,
+		"One of move (under the --to page), trash, or group (into the year/month pages under the path)")
+	m.Flags().StringVar(&archiveTo, "to", "", "Title of the page the pages are moved under, required by --action move")
+	return m
+}
+
+func runArchiveCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("archive")
+	switch archiveAction {
+	case archiveActionMove:
+		if len(archiveTo) == 0 {
+			summary.Record("--to", errors.NotValidf("empty --to for --action move"))
+		}
+	case archiveActionTrash, archiveActionGroup:
+	default:
+		summary.Record("--action", errors.NotSupportedf("action %q", archiveAction))
+	}
+	if archiveDays <= 0 {
+		summary.Record("--days", errors.NotValidf("days %d", archiveDays))
+	}
+	if summary.Failed() {
+		summary.Exit()
+	}
+
+	cutoff := reportClock().AddDate(0, 0, -archiveDays)
+	for _, folder := range archiveFolders() {
+		summary.Record(folder, archiveFolder(folder, cutoff, summary))
+	}
+	summary.Exit()
+}
+
+// archiveFolders returns the weekly paths of the teams, the teams may share them.
+func archiveFolders() []string {
+	var folders []string
+	seen := make(map[string]bool)
+	for _, team := range teams {
+		for _, folder := range []string{team.WeeklyPath, team.WeeklyDueDatePath} {
+			if len(folder) == 0 || seen[folder] {
+				continue
+			}
+			seen[folder] = true
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+// archiveGroupPattern matches the year and month pages created by --action group.
+func archiveGroupPattern(folder string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(folder) + ` \d{4}(-\d{2})?$`)
+}
+
+// archivedPage is a page to archive and its parsed creation time, the created
+// dates may be in different time zones.
+type archivedPage struct {
+	Content
+	created time.Time
+}
+
+// archiveFolder archives the child pages of the folder created before the cutoff,
+// the failed pages are recorded to summary and skipped.
+func archiveFolder(folder string, cutoff time.Time, summary *RunSummary) error {
+	space := config.Confluence.Space
+	parent, err := getContentByTitle(space, folder)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(parent.Id) == 0 {
+		return errors.NotFoundf("page %q", folder)
+	}
+
+	var target Content
+	if archiveAction == archiveActionMove {
+		if target, err = getContentByTitle(space, archiveTo); err != nil {
+			return errors.Trace(err)
+		}
+		if len(target.Id) == 0 {
+			return errors.NotFoundf("page %q", archiveTo)
+		}
+	}
+
+	children, err := contentStore.GetChildPages(parent.Id)
+	if err != nil {
+		return errors.Annotatef(err, "children title:%s", folder)
+	}
+	groupPattern := archiveGroupPattern(folder)
+	var pages []archivedPage
+	for _, child := range children {
+		if groupPattern.MatchString(child.Title) {
+			continue
+		}
+		created, err := contentCreated(child)
+		if summary.Record(child.Title, err) || !created.Before(cutoff) {
+			continue
+		}
+		pages = append(pages, archivedPage{Content: child, created: created})
+	}
+	// The oldest first, so the year and month pages are created in order.
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].created.Before(pages[j].created)
+	})
+
+	for _, page := range pages {
+		switch archiveAction {
+		case archiveActionMove:
+			fmt.Printf("move %q under %q\n", page.Title, archiveTo)
+			_, err = moveContent(page.Id, target.Id)
+		case archiveActionTrash:
+			fmt.Printf("trash %q\n", page.Title)
+			err = trashContentTree(page.Id)
+		case archiveActionGroup:
+			err = groupContent(space, folder, page)
+		}
+		summary.Record(page.Title, err)
+	}
+	return nil
+}
+
+// contentCreated returns the creation time in the history of the page.
+func contentCreated(content Content) (time.Time, error) {
+	if content.History == nil || len(content.History.CreatedDate) == 0 {
+		return time.Time{}, errors.NotFoundf("created date of page %q", content.Title)
+	}
+	created, err := time.Parse(time.RFC3339, content.History.CreatedDate)
+	return created, errors.Annotatef(err, "created date of page %q", content.Title)
+}
+
+// trashContentTree deletes the page and all its descendants, the children first.
+func trashContentTree(id string) error {
+	children, err := contentStore.GetChildPages(id)
+	if err != nil {
+		return errors.Annotatef(err, "children content:%s", id)
+	}
+	for _, child := range children {
+		if err = trashContentTree(child.Id); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(deleteContent(id))
+}
+
+// groupContent moves the page under "<folder> YYYY-MM", which is under "<folder> YYYY"
+// under the folder, by the month the page was created.
+func groupContent(space string, folder string, page archivedPage) error {
+	yearTitle := fmt.Sprintf("%s %d", folder, page.created.Year())
+	monthTitle := fmt.Sprintf("%s %s", folder, page.created.Format("2006-01"))
+	if err := createConfluencePath(space, folder, yearTitle); err != nil {
+		return errors.Trace(err)
+	}
+	if err := createConfluencePath(space, yearTitle, monthTitle); err != nil {
+		return errors.Trace(err)
+	}
+	month, err := getContentByTitle(space, monthTitle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("move %q under %q\n", page.Title, monthTitle)
+	_, err = moveContent(page.Id, month.Id)
+	return errors.Trace(err)
+}
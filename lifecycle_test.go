@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunArchiveCommand(t *testing.T) {
+	_, _, cf, _ := setupFakes(t)
+	oldDays, oldAction, oldTo := archiveDays, archiveAction, archiveTo
+	defer func() {
+		archiveDays, archiveAction, archiveTo = oldDays, oldAction, oldTo
+	}()
+	archiveDays, archiveAction, archiveTo = 180, archiveActionGroup, ""
+
+	if _, err := createContent(config.Confluence.Space, "", config.Confluence.WeeklyDueDatePath, ""); err != nil {
+		t.Fatal(err)
+	}
+	folder, err := createContent(config.Confluence.Space, "", config.Confluence.WeeklyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The created dates are in different time zones, "Late" is created
+	// earlier than "Early" though its date sorts later as a string.
+	created := map[string]string{
+		"Early":  "2025-01-31T23:30:00-05:00",
+		"Late":   "2025-02-01T01:00:00Z",
+		"Recent": testNow.AddDate(0, 0, -7).Format(time.RFC3339),
+	}
+	for _, title := range []string{"Early", "Late", "Recent"} {
+		c, err := createContent(config.Confluence.Space, folder.Id, title, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.History = &ContentHistory{CreatedDate: created[title]}
+		cf.Contents[c.Id] = c
+	}
+
+	output := captureStdout(t, func() {
+		runArchiveCommandFunc(nil, nil)
+	})
+	want := `move "Late" under "Weekly Reports 2025-02"
+move "Early" under "Weekly Reports 2025-01"
+`
+	if output != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output, want)
+	}
+	for title, month := range map[string]string{"Early": "Weekly Reports 2025-01", "Late": "Weekly Reports 2025-02", "Recent": config.Confluence.WeeklyPath} {
+		page, _ := cf.GetContentByTitle(config.Confluence.Space, title)
+		parent, _ := cf.GetContentByTitle(config.Confluence.Space, month)
+		if got := page.Ancestors[len(page.Ancestors)-1].Id; got != parent.Id {
+			t.Errorf("%s is under %s, want %s", title, got, month)
+		}
+	}
+}
+
+func TestApplyPageSettings(t *testing.T) {
+	for _, noAPI := range []bool{false, true} {
+		t.Run(map[bool]string{false: "cloud", true: "server"}[noAPI], func(t *testing.T) {
+			_, _, cf, _ := setupFakes(t)
+			cf.NoRestrictionAPI = noAPI
+			config.Confluence.Labels = []string{"{kind}"}
+			config.Confluence.Restrictions.EditGroups = []string{"team"}
+			page, err := createContent(config.Confluence.Space, "", "Page", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = applyPageSettings(page, nil, pageKindRelease, ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(cf.Labels[page.Id], ","); got != pageKindRelease {
+				t.Errorf("labels %q", got)
+			}
+			if got := len(cf.Restrictions[page.Id]); (got > 0) == noAPI {
+				t.Errorf("%d restrictions", got)
+			}
+		})
+	}
+}
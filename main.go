@@ -3,13 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
-	"github.com/juju/errors"
+	"net/http"
 	"os"
 	"os/user"
 	"path"
+	"strings"
 
 	jira "github.com/andygrunwald/go-jira"
 	"github.com/google/go-github/github"
+	"github.com/juju/errors"
 	"github.com/ngaut/log"
 	"github.com/spf13/cobra"
 	"golang.org/x/oauth2"
@@ -24,19 +26,21 @@ func perror(err error) {
 	os.Exit(1)
 }
 
-func perrmsg(msg string) {
-	perror(fmt.Errorf("%s", msg))
-}
-
 var (
-	token           string
-	configFile      string
-	globalCtx       context.Context
-	config          *Config
-	githubClient    *github.Client
-	jiraClient      *jira.Client
-	conflunceClient *jira.Client
-	printToConsole  bool
+	token          string
+	configFile     string
+	globalCtx      context.Context
+	config         *Config
+	printToConsole bool
+	teamName       string
+	dryRun         bool
+	parallelism    int
+	offline        bool
+	recordDir      string
+	replayDir      string
+	reportFormat   string
+	// teams are the teams selected by --team.
+	teams []*Team
 )
 
 func main() {
@@ -47,11 +51,23 @@ func main() {
 
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "C", "", "Config File, default ~/.work-reporter/config.toml")
 	rootCmd.PersistentFlags().BoolVarP(&printToConsole, "print", "p", false, "Print the output to the console, default false")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Run all the queries but only print the changes to Jira, Confluence and Slack")
+	rootCmd.PersistentFlags().IntVarP(&parallelism, "parallelism", "j", defaultParallelism, "The max number of concurrent fetches")
+	rootCmd.PersistentFlags().BoolVar(&offline, "offline", false, "Render the reports from the cached responses only, implies --dry-run")
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "Record all the HTTP exchanges to the directory")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "Replay the HTTP exchanges recorded in the directory instead of calling the services")
+	rootCmd.PersistentFlags().StringVar(&reportFormat, "format", "", "Report format, one of "+strings.Join(reportFormats(), ",")+", only the slack format is sent to Slack")
+	rootCmd.PersistentFlags().StringVarP(&teamName, "team", "t", "", "Only run for the team, default all the teams in config")
 
 	rootCmd.AddCommand(
 		newDailyCommand(),
 		newWeeklyCommand(),
 		newVersionReleaseCommand(),
+		newCacheCommand(),
+		newServeCommand(),
+		newHistoryCommand(),
+		newMetricsCommand(),
+		newArchiveCommand(),
 	)
 
 	cobra.OnInitialize(initGlobal)
@@ -69,6 +85,7 @@ func initGlobal() {
 	if len(configFile) == 0 {
 		configFile = path.Join(usr.HomeDir, ".work-reporter/config.toml")
 	}
+	templateDir = path.Join(path.Dir(configFile), "templates")
 	cfg, err := NewConfigFromFile(configFile)
 	perror(errors.Trace(err))
 
@@ -77,23 +94,18 @@ func initGlobal() {
 
 	initRepoQuery()
 
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: cfg.Github.Token},
-	)
-
-	tc := oauth2.NewClient(globalCtx, ts)
-	githubClient = github.NewClient(tc)
-
-	initTeamMembers()
-
-	jiraTransport := jira.BasicAuthTransport{
-		Username: config.Jira.User,
-		Password: config.Jira.Password,
+	if len(reportFormat) > 0 {
+		_, err := newReportRenderer(reportFormat)
+		perror(errors.Trace(err))
 	}
 
-	jiraClient, err = jira.NewClient(jiraTransport.Client(), config.Jira.Endpoint)
+	transport, err := initHTTPTransport()
 	perror(errors.Trace(err))
 
+	initTeamMembers()
+	perror(config.Sprint.adjust())
+	config.Jira.adjust()
+
 	// In our company, we use same user and password for Jira and Confluence.
 	if len(config.Confluence.User) == 0 {
 		config.Confluence.User = config.Jira.User
@@ -103,12 +115,79 @@ func initGlobal() {
 		config.Confluence.Password = config.Jira.Password
 	}
 
+	perror(initServices(transport))
+
+	config.Cache.Dir = homePath(usr, config.Cache.Dir, ".work-reporter/cache")
+	cache, err = newCache(config.Cache, offline)
+	perror(errors.Trace(err))
+	if cache.enabled() {
+		useCache(cache)
+	}
+
+	config.Github.EventsDir = homePath(usr, config.Github.EventsDir, ".work-reporter/events")
+	if config.Github.Events {
+		githubEvents = newGithubEventStore(config.Github.EventsDir, config.Github.EventsRetentionDays)
+	}
+	config.History.Dir = homePath(usr, config.History.Dir, ".work-reporter/history")
+	snapshots = newSnapshotStore(config.History.Dir)
+
+	// Nothing can be written without the services.
+	if offline {
+		dryRun = true
+	}
+	if dryRun {
+		useDryRun()
+	}
+}
+
+// initServices creates the GitHub, Jira, Confluence and Slack clients over the transport.
+func initServices(transport http.RoundTripper) error {
+	httpClient := &http.Client{Transport: transport}
+
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: config.Github.Token},
+	)
+
+	tc := oauth2.NewClient(context.WithValue(globalCtx, oauth2.HTTPClient, httpClient), ts)
+	githubSearcher = &githubService{client: github.NewClient(tc)}
+
+	jiraTransport := jira.BasicAuthTransport{
+		Username:  config.Jira.User,
+		Password:  config.Jira.Password,
+		Transport: transport,
+	}
+
+	jiraClient, err := jira.NewClient(jiraTransport.Client(), config.Jira.Endpoint)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	jiraSvc := &jiraService{client: jiraClient}
+	issueSearcher = jiraSvc
+	sprintManager = jiraSvc
+
 	// A little tricky here, both JIRA and Confluence use the same REST style.
 	confluenceTransport := jira.BasicAuthTransport{
-		Username: config.Confluence.User,
-		Password: config.Confluence.Password,
+		Username:  config.Confluence.User,
+		Password:  config.Confluence.Password,
+		Transport: transport,
 	}
 
-	conflunceClient, err = jira.NewClient(confluenceTransport.Client(), config.Confluence.Endpoint)
-	perror(errors.Trace(err))
+	conflunceClient, err := jira.NewClient(confluenceTransport.Client(), config.Confluence.Endpoint)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	contentStore = &confluenceService{client: conflunceClient}
+
+	messagePoster = &slackService{token: config.Slack.Token, httpClient: httpClient}
+	return nil
+}
+
+// homePath returns defaultDir in the home directory if dir is empty, and expands "~/".
+func homePath(usr *user.User, dir string, defaultDir string) string {
+	if len(dir) == 0 {
+		return path.Join(usr.HomeDir, defaultDir)
+	} else if strings.HasPrefix(dir, "~/") {
+		return path.Join(usr.HomeDir, dir[2:])
+	}
+	return dir
 }
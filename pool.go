@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultParallelism = 4
+
+// runParallel calls fn for every index in [0, n) with at most parallelism
+// workers. fn should store its result by the index, so the callers can
+// consume the results in a deterministic order.
+func runParallel(n int, fn func(idx int)) {
+	workers := parallelism
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				fn(idx)
+			}
+		}()
+	}
+	for idx := 0; idx < n; idx++ {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// rateGate is shared by all the workers calling the same service. Once a
+// worker meets the rate limit, all the workers wait until the limit resets
+// instead of hitting the limit again and again.
+type rateGate struct {
+	mu       sync.Mutex
+	resumeAt time.Time
+}
+
+func (g *rateGate) wait() {
+	g.mu.Lock()
+	dur := time.Until(g.resumeAt)
+	g.mu.Unlock()
+	if dur > 0 {
+		time.Sleep(dur)
+	}
+}
+
+func (g *rateGate) pauseUntil(t time.Time) {
+	g.mu.Lock()
+	if t.After(g.resumeAt) {
+		g.resumeAt = t
+	}
+	g.mu.Unlock()
+}
+
+var (
+	githubRateGate rateGate
+	jiraRateGate   rateGate
+)
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/nlopes/slack/slackutilsx"
+)
+
+const (
+	reportFormatSlack       = "slack"
+	reportFormatSlackBlocks = "slack-blocks"
+	reportFormatConfluence  = "confluence"
+	reportFormatMarkdown    = "markdown"
+	reportFormatText        = "text"
+	reportFormatJSON        = "json"
+)
+
+// ReportRenderer renders the report to one output format.
+type ReportRenderer interface {
+	Render(w io.Writer, report *Report) error
+}
+
+var reportRenderers = map[string]ReportRenderer{
+	reportFormatSlack:       slackRenderer{},
+	reportFormatSlackBlocks: slackBlocksRenderer{},
+	reportFormatConfluence:  confluenceRenderer{},
+	reportFormatMarkdown:    markdownRenderer{},
+	reportFormatText:        textRenderer{},
+	reportFormatJSON:        jsonRenderer{},
+}
+
+func reportFormats() []string {
+	formats := make([]string, 0, len(reportRenderers))
+	for format := range reportRenderers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func newReportRenderer(format string) (ReportRenderer, error) {
+	r, ok := reportRenderers[format]
+	if !ok {
+		return nil, errors.NotSupportedf("report format %s, use one of %s", format, strings.Join(reportFormats(), ","))
+	}
+	return r, nil
+}
+
+func renderReport(format string, report *Report) (string, error) {
+	r, err := newReportRenderer(format)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var buf bytes.Buffer
+	if err = r.Render(&buf, report); err != nil {
+		return "", errors.Trace(err)
+	}
+	return buf.String(), nil
+}
+
+// reportOutput returns the report format, and whether the report is sent to Slack.
+func reportOutput() (string, bool) {
+	format := reportFormat
+	if len(format) == 0 {
+		format = reportFormatSlack
+		if config.Slack.Blocks {
+			format = reportFormatSlackBlocks
+		}
+	}
+	return format, !printToConsole && (format == reportFormatSlack || format == reportFormatSlackBlocks)
+}
+
+// outputReport prints the report in the --format format, or sends it to the
+// Slack channel. It's sent only if it's rendered for Slack and not printed to
+// the console.
+func outputReport(channel string, report *Report) error {
+	format, toSlack := reportOutput()
+	if toSlack && format == reportFormatSlackBlocks {
+		return postSlackMessages(channel, newReportSlackMessages(report, config.Slack.Thread))
+	}
+
+	s, err := renderReport(format, report)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !toSlack {
+		fmt.Println(s)
+		return nil
+	}
+	return sendToSlack(channel, "%s", s)
+}
+
+type slackRenderer struct{}
+
+func (r slackRenderer) Render(w io.Writer, report *Report) error {
+	return executeTemplate(w, report, "", report.Kind+".slack.tmpl", "report.slack.tmpl")
+}
+
+// formatPerson mentions the Jira users by the email, and shows the GitHub login of the others.
+func (r slackRenderer) formatPerson(p ReportPerson) string {
+	if len(p.Email) > 0 {
+		return buildSlackMention(p.Email)
+	}
+	return "@" + slackutilsx.EscapeMessage(p.Login)
+}
+
+func (r slackRenderer) formatPersons(persons []ReportPerson, sep string) string {
+	s := make([]string, 0, len(persons))
+	for _, p := range persons {
+		s = append(s, r.formatPerson(p))
+	}
+	return strings.Join(s, sep)
+}
+
+func (r slackRenderer) formatItem(item ReportItem) string {
+	if len(item.Key) > 0 {
+		dueDate := "None"
+		if item.DueDate != nil {
+			dueDate = item.DueDate.Format(dayFormat)
+		}
+		return fmt.Sprintf("[ %s / %s ] DueDate:%s %s",
+			slackutilsx.EscapeMessage(item.Status),
+			slackutilsx.EscapeMessage(item.Priority),
+			dueDate,
+			r.formatItemBody(item),
+		)
+	}
+
+	var s string
+	if item.Closed {
+		s += "_(Closed)_ "
+	}
+	for _, tag := range item.Tags {
+		s += fmt.Sprintf("_(%s)_ ", slackutilsx.EscapeMessage(tag))
+	}
+	return s + r.formatItemBody(item)
+}
+
+// formatItemBody formats the link and the people of the item.
+func (r slackRenderer) formatItemBody(item ReportItem) string {
+	s := fmt.Sprintf("<%s|%s>", item.Link, slackutilsx.EscapeMessage(item.Title))
+	if len(item.Key) > 0 {
+		if len(item.Assignees) > 0 {
+			s += " assigned to " + r.formatPersons(item.Assignees, " ")
+		}
+		return s
+	}
+
+	if item.Author != nil {
+		s += " by " + r.formatPerson(*item.Author)
+	}
+	if len(item.Mentions) > 0 {
+		s += " mentioned: " + r.formatPersons(item.Mentions, ",")
+	}
+	if len(item.Assignees) > 0 {
+		s += ", assigned to " + r.formatPersons(item.Assignees, " ")
+	}
+	return s
+}
+
+// formatItemContext formats the status of the item, it's shown as the
+// context line under the item in Block Kit messages.
+func (r slackRenderer) formatItemContext(item ReportItem) []string {
+	var context []string
+	if len(item.Key) > 0 {
+		context = append(context, item.Key, item.Status, item.Priority)
+		if item.DueDate != nil {
+			context = append(context, "Due "+item.DueDate.Format(dayFormat))
+		}
+	} else {
+		if len(item.Repo) > 0 {
+			context = append(context, item.Repo)
+		}
+		if item.Closed {
+			context = append(context, "Closed")
+		}
+		context = append(context, item.Tags...)
+	}
+	for idx := range context {
+		context[idx] = slackutilsx.EscapeMessage(context[idx])
+	}
+	return context
+}
+
+// confluenceRenderer renders the report to the Confluence storage format,
+// each section is a layout section of the page.
+type confluenceRenderer struct{}
+
+func (r confluenceRenderer) Render(w io.Writer, report *Report) error {
+	return executeTemplate(w, report, "", report.Kind+".confluence.tmpl", "report.confluence.tmpl")
+}
+
+// renderSection renders a section alone, for the pages which are not built as a report.
+func (r confluenceRenderer) renderSection(w io.Writer, section *ReportSection) error {
+	return executeTemplate(w, section, "section", "report.confluence.tmpl")
+}
+
+func (r confluenceRenderer) renderItems(w io.Writer, items []ReportItem) error {
+	return executeTemplate(w, items, "items", "report.confluence.tmpl")
+}
+
+func confluencePersons(persons []ReportPerson) string {
+	s := make([]string, 0, len(persons))
+	for _, p := range persons {
+		s = append(s, "@"+html.EscapeString(personName(p)))
+	}
+	return strings.Join(s, " ")
+}
+
+func confluenceItem(item ReportItem) string {
+	if len(item.Key) > 0 {
+		// The jira macro shows the status, assignee and so on by itself.
+		return jiraMacro(item.Key)
+	}
+
+	var s string
+	labelColor := jiraLabelColorGrey
+	if item.Closed {
+		labelColor = jiraLabelColorGreen
+	}
+	if len(item.Repo) > 0 {
+		s += formatLabelForHtmlOutput(item.Repo, labelColor) + " "
+	}
+	s += fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(item.Link), html.EscapeString(item.Title))
+	if item.Author != nil {
+		s += " by " + confluencePersons([]ReportPerson{*item.Author})
+	}
+	if len(item.Mentions) > 0 {
+		s += " mentioned: " + confluencePersons(item.Mentions)
+	}
+	if len(item.Assignees) > 0 {
+		s += ", assigned to " + confluencePersons(item.Assignees)
+	}
+	for _, tag := range item.Tags {
+		s += " " + formatLabelForHtmlOutput(tag, jiraLabelColorBlue)
+	}
+	return s
+}
+
+// personName returns the GitHub login, or the name of the Jira user.
+func personName(p ReportPerson) string {
+	switch {
+	case len(p.Login) > 0:
+		return p.Login
+	case len(p.Name) > 0:
+		return p.Name
+	default:
+		return p.Email
+	}
+}
+
+// plainItemText is shared by the Markdown and the plain text renderers,
+// title formats the title and the link of the item.
+func plainItemText(item ReportItem, title string) string {
+	persons := func(persons []ReportPerson) string {
+		s := make([]string, 0, len(persons))
+		for _, p := range persons {
+			s = append(s, "@"+personName(p))
+		}
+		return strings.Join(s, " ")
+	}
+
+	var s string
+	if len(item.Key) > 0 {
+		s = fmt.Sprintf("[%s / %s] %s", item.Status, item.Priority, title)
+		if item.DueDate != nil {
+			s += ", due " + item.DueDate.Format(dayFormat)
+		}
+	} else {
+		if len(item.Repo) > 0 {
+			s = item.Repo + " "
+		}
+		if item.Closed {
+			s += "(Closed) "
+		}
+		for _, tag := range item.Tags {
+			s += fmt.Sprintf("(%s) ", tag)
+		}
+		s += title
+		if item.Author != nil {
+			s += " by " + persons([]ReportPerson{*item.Author})
+		}
+	}
+	if len(item.Mentions) > 0 {
+		s += " mentioned: " + persons(item.Mentions)
+	}
+	if len(item.Assignees) > 0 {
+		s += ", assigned to " + persons(item.Assignees)
+	}
+	return s
+}
+
+type markdownRenderer struct{}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `&lt;`, `>`, `&gt;`,
+)
+
+func (r markdownRenderer) Render(w io.Writer, report *Report) error {
+	var buf bytes.Buffer
+	buf.WriteString("# " + markdownEscaper.Replace(report.Title))
+	if len(report.Team) > 0 {
+		buf.WriteString(fmt.Sprintf(" (%s)", markdownEscaper.Replace(report.Team)))
+	}
+	buf.WriteString("\n")
+	for _, section := range report.Sections {
+		buf.WriteString(fmt.Sprintf("\n## %s\n\n", markdownEscaper.Replace(section.Title)))
+		if len(section.Description) > 0 {
+			buf.WriteString(fmt.Sprintf("> %s\n\n", markdownEscaper.Replace(section.Description)))
+		}
+		if len(section.Items) == 0 {
+			buf.WriteString("_None_\n")
+		}
+		for _, item := range section.Items {
+			title := markdownEscaper.Replace(item.Title)
+			if len(item.Key) > 0 {
+				title = item.Key + " " + title
+			}
+			buf.WriteString(fmt.Sprintf("- %s\n", plainItemText(item, fmt.Sprintf("[%s](%s)", title, item.Link))))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return errors.Trace(err)
+}
+
+type textRenderer struct{}
+
+func (r textRenderer) Render(w io.Writer, report *Report) error {
+	var buf bytes.Buffer
+	buf.WriteString(report.Title)
+	if len(report.Team) > 0 {
+		buf.WriteString(fmt.Sprintf(" (%s)", report.Team))
+	}
+	buf.WriteString("\n")
+	for _, section := range report.Sections {
+		buf.WriteString(fmt.Sprintf("\n%s\n", section.Title))
+		if len(section.Description) > 0 {
+			buf.WriteString(fmt.Sprintf("  %s\n", section.Description))
+		}
+		if len(section.Items) == 0 {
+			buf.WriteString("  None\n")
+		}
+		for _, item := range section.Items {
+			title := item.Title
+			if len(item.Key) > 0 {
+				title = item.Key + " " + title
+			}
+			buf.WriteString(fmt.Sprintf("  - %s\n", plainItemText(item, fmt.Sprintf("%s <%s>", title, item.Link))))
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return errors.Trace(err)
+}
+
+type jsonRenderer struct{}
+
+func (r jsonRenderer) Render(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Trace(enc.Encode(report))
+}
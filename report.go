@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/github"
+)
+
+// Report is the structured report, the renderers turn it into Slack messages,
+// Confluence pages, Markdown and so on.
+type Report struct {
+	// Kind selects the templates of the report, see templates.go.
+	Kind     string           `json:"kind"`
+	Title    string           `json:"title"`
+	Team     string           `json:"team,omitempty"`
+	Sections []*ReportSection `json:"sections"`
+}
+
+type ReportSection struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Items       []ReportItem `json:"items"`
+}
+
+// ReportPerson is a GitHub user if Login is set, otherwise a Jira user.
+type ReportPerson struct {
+	Login string `json:"login,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// ReportItem is a GitHub issue, pull request or a Jira issue.
+type ReportItem struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+	// Repo is the repository of a GitHub item.
+	Repo string `json:"repo,omitempty"`
+	// Key is the key of a Jira issue.
+	Key       string         `json:"key,omitempty"`
+	Status    string         `json:"status,omitempty"`
+	Priority  string         `json:"priority,omitempty"`
+	DueDate   *time.Time     `json:"due_date,omitempty"`
+	Closed    bool           `json:"closed,omitempty"`
+	Author    *ReportPerson  `json:"author,omitempty"`
+	Assignees []ReportPerson `json:"assignees,omitempty"`
+	Mentions  []ReportPerson `json:"mentions,omitempty"`
+	// Tags are the extra labels, e.g. the external label of the team.
+	Tags []string `json:"tags,omitempty"`
+}
+
+func (r *Report) AddSection(title string, description string, items []ReportItem) {
+	if items == nil {
+		items = []ReportItem{}
+	}
+	r.Sections = append(r.Sections, &ReportSection{
+		Title:       title,
+		Description: description,
+		Items:       items,
+	})
+}
+
+// involves returns whether the person is mentioned by or assigned to the item.
+func (item ReportItem) involves(login string, email string) bool {
+	persons := append(append([]ReportPerson{}, item.Mentions...), item.Assignees...)
+	for _, p := range persons {
+		if (len(p.Login) > 0 && strings.EqualFold(p.Login, login)) ||
+			(len(p.Email) > 0 && strings.EqualFold(p.Email, email)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterItems returns a copy of the report with the items matched by fn,
+// the sections without any matched items are dropped.
+func (r *Report) filterItems(fn func(ReportItem) bool) *Report {
+	filtered := &Report{Kind: r.Kind, Title: r.Title, Team: r.Team}
+	for _, section := range r.Sections {
+		var items []ReportItem
+		for _, item := range section.Items {
+			if fn(item) {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			filtered.AddSection(section.Title, section.Description, items)
+		}
+	}
+	return filtered
+}
+
+func newGithubReportItem(team *Team, issue github.Issue) ReportItem {
+	item := ReportItem{
+		Title:  issue.GetTitle(),
+		Link:   issue.GetHTMLURL(),
+		Status: issue.GetState(),
+		Closed: issue.GetState() == "closed",
+		Author: &ReportPerson{Login: issue.GetUser().GetLogin()},
+	}
+	if m := regexRepo.FindStringSubmatch(item.Link); m != nil {
+		item.Repo = m[1]
+	}
+	for _, assignee := range issue.Assignees {
+		item.Assignees = append(item.Assignees, ReportPerson{Login: assignee.GetLogin()})
+	}
+	if !team.IsMember(item.Author.Login) {
+		item.Tags = append(item.Tags, team.ExternalLabel)
+	}
+	return item
+}
+
+func newGithubReportItems(team *Team, issues []github.Issue) []ReportItem {
+	items := make([]ReportItem, 0, len(issues))
+	for _, issue := range issues {
+		items = append(items, newGithubReportItem(team, issue))
+	}
+	return items
+}
+
+func newJiraReportItem(issue jira.Issue) ReportItem {
+	item := ReportItem{
+		Link:     jiraIssueLink(issue.Key),
+		Key:      issue.Key,
+		Status:   "Unknown",
+		Priority: "Unknown",
+	}
+	if issue.Fields == nil {
+		return item
+	}
+	item.Title = issue.Fields.Summary
+	if issue.Fields.Status != nil {
+		item.Status = issue.Fields.Status.Name
+		item.Closed = issue.Fields.Status.StatusCategory.Key == "done"
+	}
+	if issue.Fields.Priority != nil {
+		item.Priority = issue.Fields.Priority.Name
+	}
+	if dueDate := time.Time(issue.Fields.Duedate); !dueDate.IsZero() {
+		item.DueDate = &dueDate
+	}
+	if issue.Fields.Assignee != nil {
+		item.Assignees = []ReportPerson{{
+			Name:  issue.Fields.Assignee.DisplayName,
+			Email: issue.Fields.Assignee.EmailAddress,
+		}}
+	}
+	return item
+}
+
+func newJiraReportItems(issues []jira.Issue) []ReportItem {
+	items := make([]ReportItem, 0, len(issues))
+	for _, issue := range issues {
+		items = append(items, newJiraReportItem(issue))
+	}
+	return items
+}
+
+func jiraIssueLink(key string) string {
+	return config.Jira.Endpoint + "/browse/" + key
+}
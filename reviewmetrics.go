@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	"github.com/spf13/cobra"
+)
+
+// PullRequestTiming is the review timeline of a pull request.
+type PullRequestTiming struct {
+	Repo    string    `json:"repo"`
+	Number  int       `json:"number"`
+	Link    string    `json:"link"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+	// Ready is the time the pull request was ready for review, it's the
+	// created time unless the pull request was opened as a draft.
+	Ready       time.Time  `json:"ready"`
+	FirstReview *time.Time `json:"first_review,omitempty"`
+	Approved    *time.Time `json:"approved,omitempty"`
+	Merged      *time.Time `json:"merged,omitempty"`
+	// Rounds counts the distinct commits reviewed.
+	Rounds    int              `json:"rounds"`
+	Reviewers []ReviewerTiming `json:"reviewers,omitempty"`
+}
+
+// ReviewerTiming is the reviews of one reviewer on a pull request.
+type ReviewerTiming struct {
+	Login       string     `json:"login"`
+	FirstReview time.Time  `json:"first_review"`
+	Approved    *time.Time `json:"approved,omitempty"`
+	Rounds      int        `json:"rounds"`
+}
+
+// Percentiles are the 50th and the 90th percentiles of Count values.
+type Percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+}
+
+// ReviewLatency is the latency of a repository or a reviewer, in hours.
+type ReviewLatency struct {
+	Name             string      `json:"name"`
+	PullRequests     int         `json:"pull_requests"`
+	FirstReviewHours Percentiles `json:"first_review_hours"`
+	ApprovalHours    Percentiles `json:"approval_hours"`
+	MergeHours       Percentiles `json:"merge_hours"`
+	Rounds           Percentiles `json:"rounds"`
+}
+
+// ReviewMetrics is the review latency of the pull requests created in [Start, End].
+type ReviewMetrics struct {
+	Team         string              `json:"team"`
+	Start        string              `json:"start"`
+	End          string              `json:"end"`
+	Repos        []ReviewLatency     `json:"repos"`
+	Reviewers    []ReviewLatency     `json:"reviewers"`
+	PullRequests []PullRequestTiming `json:"pull_requests"`
+}
+
+func listPullRequestReviews(owner string, repo string, number int) ([]*github.PullRequestReview, error) {
+	var all []*github.PullRequestReview
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		var (
+			reviews  []*github.PullRequestReview
+			nextPage int
+		)
+		err := callGithub(func() (err error) {
+			reviews, nextPage, err = githubSearcher.ListReviews(globalCtx, owner, repo, number, opt)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "reviews:%s/%s#%d", owner, repo, number)
+		}
+		all = append(all, reviews...)
+		if nextPage == 0 {
+			return all, nil
+		}
+		opt.Page = nextPage
+	}
+}
+
+func listPullRequestTimeline(owner string, repo string, number int) ([]*github.Timeline, error) {
+	var all []*github.Timeline
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		var (
+			events   []*github.Timeline
+			nextPage int
+		)
+		err := callGithub(func() (err error) {
+			events, nextPage, err = githubSearcher.ListTimeline(globalCtx, owner, repo, number, opt)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Annotatef(err, "timeline:%s/%s#%d", owner, repo, number)
+		}
+		all = append(all, events...)
+		if nextPage == 0 {
+			return all, nil
+		}
+		opt.Page = nextPage
+	}
+}
+
+// newPullRequestTiming replays the reviews and the timeline, the reviews of
+// the author and the pending reviews are ignored.
+func newPullRequestTiming(repo string, pr github.Issue, reviews []*github.PullRequestReview, timeline []*github.Timeline) PullRequestTiming {
+	timing := PullRequestTiming{
+		Repo:    repo,
+		Number:  pr.GetNumber(),
+		Link:    pr.GetHTMLURL(),
+		Author:  pr.GetUser().GetLogin(),
+		Created: pr.GetCreatedAt(),
+		Ready:   pr.GetCreatedAt(),
+	}
+	readySet := false
+	for _, event := range timeline {
+		switch event.GetEvent() {
+		case "ready_for_review":
+			if !readySet && event.CreatedAt != nil {
+				timing.Ready, readySet = event.GetCreatedAt(), true
+			}
+		case "merged":
+			if event.CreatedAt != nil {
+				merged := event.GetCreatedAt()
+				timing.Merged = &merged
+			}
+		}
+	}
+
+	sorted := make([]*github.PullRequestReview, 0, len(reviews))
+	for _, review := range reviews {
+		if review.SubmittedAt == nil || review.GetState() == "PENDING" ||
+			strings.EqualFold(review.GetUser().GetLogin(), timing.Author) {
+			continue
+		}
+		sorted = append(sorted, review)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].SubmittedAt.Before(*sorted[j].SubmittedAt) })
+
+	commits := make(map[string]bool)
+	// reviewers indexes timing.Reviewers by the login.
+	reviewers := make(map[string]int)
+	reviewerCommits := make(map[string]map[string]bool)
+	for _, review := range sorted {
+		submitted := *review.SubmittedAt
+		login := review.GetUser().GetLogin()
+		approved := review.GetState() == "APPROVED"
+		if timing.FirstReview == nil {
+			timing.FirstReview = &submitted
+		}
+		if approved && timing.Approved == nil {
+			timing.Approved = &submitted
+		}
+		commits[review.GetCommitID()] = true
+
+		idx, ok := reviewers[login]
+		if !ok {
+			idx = len(timing.Reviewers)
+			reviewers[login] = idx
+			reviewerCommits[login] = make(map[string]bool)
+			timing.Reviewers = append(timing.Reviewers, ReviewerTiming{Login: login, FirstReview: submitted})
+		}
+		reviewer := &timing.Reviewers[idx]
+		if approved && reviewer.Approved == nil {
+			reviewer.Approved = &submitted
+		}
+		reviewerCommits[login][review.GetCommitID()] = true
+		reviewer.Rounds = len(reviewerCommits[login])
+	}
+	timing.Rounds = len(commits)
+	return timing
+}
+
+// hoursSince returns the hours from start to t, the reviews of the drafts count as 0.
+func hoursSince(start time.Time, t time.Time) float64 {
+	return math.Max(0, t.Sub(start).Hours())
+}
+
+// newPercentiles returns the percentiles by the nearest rank.
+func newPercentiles(values []float64) Percentiles {
+	p := Percentiles{Count: len(values)}
+	if len(values) == 0 {
+		return p
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := func(percent float64) float64 {
+		idx := int(math.Ceil(percent/100*float64(len(sorted)))) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return sorted[idx]
+	}
+	p.P50, p.P90 = rank(50), rank(90)
+	return p
+}
+
+// latencySamples collects the samples of a repository or a reviewer.
+type latencySamples struct {
+	pullRequests int
+	firstReview  []float64
+	approval     []float64
+	merge        []float64
+	rounds       []float64
+}
+
+func (s *latencySamples) latency(name string) ReviewLatency {
+	return ReviewLatency{
+		Name:             name,
+		PullRequests:     s.pullRequests,
+		FirstReviewHours: newPercentiles(s.firstReview),
+		ApprovalHours:    newPercentiles(s.approval),
+		MergeHours:       newPercentiles(s.merge),
+		Rounds:           newPercentiles(s.rounds),
+	}
+}
+
+func sortedLatencies(samples map[string]*latencySamples) []ReviewLatency {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	latencies := make([]ReviewLatency, 0, len(names))
+	for _, name := range names {
+		latencies = append(latencies, samples[name].latency(name))
+	}
+	return latencies
+}
+
+// newReviewMetrics aggregates the timings by repository, and by reviewer for the team members.
+func newReviewMetrics(team *Team, start string, end string, timings []PullRequestTiming) *ReviewMetrics {
+	repos := make(map[string]*latencySamples)
+	reviewers := make(map[string]*latencySamples)
+	for _, timing := range timings {
+		repo, ok := repos[timing.Repo]
+		if !ok {
+			repo = &latencySamples{}
+			repos[timing.Repo] = repo
+		}
+		repo.pullRequests++
+		if timing.FirstReview != nil {
+			repo.firstReview = append(repo.firstReview, hoursSince(timing.Ready, *timing.FirstReview))
+			repo.rounds = append(repo.rounds, float64(timing.Rounds))
+		}
+		if timing.Approved != nil {
+			repo.approval = append(repo.approval, hoursSince(timing.Ready, *timing.Approved))
+		}
+		if timing.Merged != nil {
+			repo.merge = append(repo.merge, hoursSince(timing.Created, *timing.Merged))
+		}
+
+		for _, r := range timing.Reviewers {
+			if !team.IsMember(r.Login) {
+				continue
+			}
+			reviewer, ok := reviewers[r.Login]
+			if !ok {
+				reviewer = &latencySamples{}
+				reviewers[r.Login] = reviewer
+			}
+			reviewer.pullRequests++
+			reviewer.firstReview = append(reviewer.firstReview, hoursSince(timing.Ready, r.FirstReview))
+			reviewer.rounds = append(reviewer.rounds, float64(r.Rounds))
+			if r.Approved != nil {
+				reviewer.approval = append(reviewer.approval, hoursSince(timing.Ready, *r.Approved))
+			}
+			if timing.Merged != nil {
+				reviewer.merge = append(reviewer.merge, hoursSince(timing.Created, *timing.Merged))
+			}
+		}
+	}
+
+	if timings == nil {
+		timings = []PullRequestTiming{}
+	}
+	return &ReviewMetrics{
+		Team:         team.Name,
+		Start:        start,
+		End:          end,
+		Repos:        sortedLatencies(repos),
+		Reviewers:    sortedLatencies(reviewers),
+		PullRequests: timings,
+	}
+}
+
+// collectReviewMetrics fetches the reviews and the timelines of the pull
+// requests created in [start, end], the failed pull requests are recorded
+// to summary and skipped.
+func collectReviewMetrics(team *Team, start string, end string, summary *RunSummary) (*ReviewMetrics, error) {
+	prs, err := getCreatedPullRequests(start, &end)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	timings := make([]PullRequestTiming, len(prs))
+	errs := make([]error, len(prs))
+	runParallel(len(prs), func(idx int) {
+		pr := prs[idx]
+		m := regexRepo.FindStringSubmatch(pr.GetHTMLURL())
+		if m == nil {
+			errs[idx] = errors.NotValidf("pull request link %s", pr.GetHTMLURL())
+			return
+		}
+		parts := strings.SplitN(m[1], "/", 2)
+		reviews, err := listPullRequestReviews(parts[0], parts[1], pr.GetNumber())
+		if err != nil {
+			errs[idx] = err
+			return
+		}
+		timeline, err := listPullRequestTimeline(parts[0], parts[1], pr.GetNumber())
+		if err != nil {
+			errs[idx] = err
+			return
+		}
+		timings[idx] = newPullRequestTiming(m[1], pr, reviews, timeline)
+	})
+
+	var collected []PullRequestTiming
+	for idx, pr := range prs {
+		if summary.Record(pr.GetHTMLURL(), errs[idx]) {
+			continue
+		}
+		collected = append(collected, timings[idx])
+	}
+	return newReviewMetrics(team, start, end, collected), nil
+}
+
+var (
+	metricsStart string
+	metricsEnd   string
+)
+
+func newMetricsCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "metrics",
+		Short: "Report Metrics",
+	}
+	m.PersistentFlags().StringVar(&metricsStart, "start", "", "Start date like 2006-01-02, default 7 days before the end")
+	m.PersistentFlags().StringVar(&metricsEnd, "end", "", "End date like 2006-01-02, default today")
+	m.AddCommand(&cobra.Command{
+		Use:   "review",
+		Short: "Pull Request Review Latency",
+		Run:   runMetricsReviewCommandFunc,
+	})
+	m.AddCommand(newMetricsJiraCommand())
+	m.AddCommand(newMetricsTimesheetCommand())
+	return m
+}
+
+// metricsDateRange returns the --start and --end dates, the range is inclusive.
+func metricsDateRange() (string, string, error) {
+	end := metricsEnd
+	if len(end) == 0 {
+		end = reportClock().Format(dayFormat)
+	}
+	endDay, err := time.Parse(dayFormat, end)
+	if err != nil {
+		return "", "", errors.NotValidf("end date %s", end)
+	}
+	start := metricsStart
+	if len(start) == 0 {
+		start = endDay.AddDate(0, 0, -6).Format(dayFormat)
+	}
+	if _, err = time.Parse(dayFormat, start); err != nil {
+		return "", "", errors.NotValidf("start date %s", start)
+	}
+	return start, end, nil
+}
+
+func runMetricsReviewCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("metrics review")
+	start, end, err := metricsDateRange()
+	if summary.Record("date range", err) {
+		summary.Exit()
+	}
+
+	for _, team := range teams {
+		metrics, err := collectReviewMetrics(team, start, end, summary)
+		if summary.Record(team.Name, err) {
+			continue
+		}
+		summary.Record(team.Name+" snapshot", saveDataSnapshot(team, snapshotKindReviewMetrics, metrics, reviewSnapshotMetrics(metrics)))
+		summary.Record(team.Name+" output", renderReviewMetrics(os.Stdout, reportFormat, metrics))
+	}
+	summary.Exit()
+}
+
+// renderReviewMetrics renders the metrics as JSON, Confluence tables or text tables.
+func renderReviewMetrics(w io.Writer, format string, metrics *ReviewMetrics) error {
+	switch format {
+	case reportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Trace(enc.Encode(metrics))
+	case reportFormatConfluence:
+		return errors.Trace(executeTemplate(w, metrics, "", "review-metrics.confluence.tmpl"))
+	case "", reportFormatText:
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "%s pull request review latency (%s ~ %s), p50 / p90 hours\n", metrics.Team, metrics.Start, metrics.End)
+		for _, table := range []struct {
+			title     string
+			latencies []ReviewLatency
+		}{{"Repo", metrics.Repos}, {"Reviewer", metrics.Reviewers}} {
+			buf.WriteString("\n")
+			tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(tw, "%s\tPRs\tFirst Review\tApproval\tMerge\tRounds\n", table.title)
+			for _, l := range table.latencies {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", l.Name, l.PullRequests,
+					l.FirstReviewHours, l.ApprovalHours, l.MergeHours, l.Rounds)
+			}
+			tw.Flush()
+		}
+		_, err := w.Write(buf.Bytes())
+		return errors.Trace(err)
+	}
+	return errors.NotSupportedf("metrics format %s, use one of %s,%s,%s", format, reportFormatText, reportFormatJSON, reportFormatConfluence)
+}
+
+func (p Percentiles) String() string {
+	if p.Count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f / %.1f", p.P50, p.P90)
+}
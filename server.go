@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	slackSignatureVersion = "v0"
+	// slackRequestMaxAge rejects the replayed requests.
+	slackRequestMaxAge   = 5 * time.Minute
+	slackRequestMaxBody  = 1 << 20
+	slackResponseTimeout = 10 * time.Second
+	// slackMaxResponses is how many times Slack accepts posts to a response_url.
+	slackMaxResponses = 5
+)
+
+const slashCommandUsage = "Usage: `/report daily [team]`, `/report me` or `/report due [team]`"
+
+var serveAddr string
+
+func newServeCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve Slack Slash Commands and GitHub Webhooks",
+		Run:   runServeCommandFunc,
+	}
+	m.Flags().StringVar(&serveAddr, "addr", ":8080", "Listen address, the slash command URL is /slack/commands, the webhook URL is /github/webhook")
+	return m
+}
+
+func runServeCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("serve")
+	if len(config.Slack.SigningSecret) == 0 && len(config.Github.WebhookSecret) == 0 {
+		summary.Record("serve", errors.NotFoundf("slack signing-secret or github webhook-secret in config"))
+		summary.Exit()
+	}
+
+	handler := newSlashCommandHandler(config.Slack.SigningSecret)
+	mux := http.NewServeMux()
+	if len(config.Slack.SigningSecret) > 0 {
+		mux.Handle("/slack/commands", handler)
+	}
+	if len(config.Github.WebhookSecret) > 0 {
+		// Share the store with the reports, so they never read a half written event.
+		store := githubEvents
+		if store == nil {
+			store = newGithubEventStore(config.Github.EventsDir, config.Github.EventsRetentionDays)
+		}
+		mux.Handle("/github/webhook", newGithubWebhookHandler(config.Github.WebhookSecret, store))
+	}
+	srv := &http.Server{Addr: serveAddr, Handler: mux}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Infof("shutting down, waiting for the running commands")
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Errorf("shutdown: %v", err)
+		}
+	}()
+
+	log.Infof("serve on %s", serveAddr)
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	handler.wait()
+	summary.Record("listen "+serveAddr, err)
+	summary.Exit()
+}
+
+// verifySlackSignature verifies the request is signed by the signing secret,
+// see https://api.slack.com/authentication/verifying-requests-from-slack.
+func verifySlackSignature(header http.Header, body []byte, secret string, now time.Time) error {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.NotValidf("slack request timestamp %q", ts)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return errors.NotValidf("slack request timestamp %s, it's %s away from now", ts, age)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%s:", slackSignatureVersion, ts)
+	mac.Write(body)
+	expected := slackSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return errors.Unauthorizedf("slack request signature")
+	}
+	return nil
+}
+
+type slashCommand struct {
+	Name        string
+	Args        []string
+	UserID      string
+	ResponseURL string
+}
+
+func parseSlashCommand(form url.Values) slashCommand {
+	cmd := slashCommand{
+		UserID:      form.Get("user_id"),
+		ResponseURL: form.Get("response_url"),
+	}
+	fields := strings.Fields(form.Get("text"))
+	if len(fields) > 0 {
+		cmd.Name = strings.ToLower(fields[0])
+		cmd.Args = fields[1:]
+	}
+	return cmd
+}
+
+// slashCommandResponse is the message posted to the response_url.
+type slashCommandResponse struct {
+	ResponseType string       `json:"response_type"`
+	Text         string       `json:"text"`
+	Blocks       []SlackBlock `json:"blocks,omitempty"`
+}
+
+// slashCommandHandler acknowledges the commands at once, since Slack waits
+// for only 3 seconds, and posts the reports to the response_url later.
+type slashCommandHandler struct {
+	secret string
+	now    func() time.Time
+	// build builds the report of the command.
+	build func(cmd slashCommand) (*Report, *RunSummary, error)
+	// respond posts the message to the response_url.
+	respond func(responseURL string, message slashCommandResponse) error
+
+	// The reports are built one by one, the report builders share the caches.
+	mu      sync.Mutex
+	running sync.WaitGroup
+}
+
+func newSlashCommandHandler(secret string) *slashCommandHandler {
+	client := &http.Client{Timeout: slackResponseTimeout}
+	return &slashCommandHandler{
+		secret: secret,
+		now:    time.Now,
+		build:  buildSlashCommandReport,
+		respond: func(responseURL string, message slashCommandResponse) error {
+			return postSlashCommandResponse(client, responseURL, message)
+		},
+	}
+}
+
+func (h *slashCommandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, slackRequestMaxBody))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err = verifySlackSignature(r.Header, body, h.secret, h.now()); err != nil {
+		log.Warnf("reject slack request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	cmd := parseSlashCommand(form)
+	switch cmd.Name {
+	case "daily", "me", "due":
+	default:
+		writeSlashCommandResponse(w, slashCommandUsage)
+		return
+	}
+
+	log.Infof("slack command %s %v from %s", cmd.Name, cmd.Args, cmd.UserID)
+	h.running.Add(1)
+	go func() {
+		defer h.running.Done()
+		h.handle(cmd)
+	}()
+	writeSlashCommandResponse(w, fmt.Sprintf("Generating the %s report...", cmd.Name))
+}
+
+func (h *slashCommandHandler) handle(cmd slashCommand) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report, summary, err := h.build(cmd)
+	if err != nil {
+		h.reply(cmd, slashCommandResponse{Text: fmt.Sprintf("Failed to generate the %s report: %v", cmd.Name, err)})
+		return
+	}
+
+	var messages []slashCommandResponse
+	if config.Slack.Blocks {
+		for _, message := range newReportSlackMessages(report, false) {
+			messages = append(messages, slashCommandResponse{Text: message.Text, Blocks: message.Blocks})
+		}
+	} else {
+		s, err := renderReport(reportFormatSlack, report)
+		if err != nil {
+			h.reply(cmd, slashCommandResponse{Text: fmt.Sprintf("Failed to render the %s report: %v", cmd.Name, err)})
+			return
+		}
+		for _, part := range splitSlackText(s, slackMaxMessageText) {
+			messages = append(messages, slashCommandResponse{Text: part})
+		}
+	}
+	var failures string
+	if summary.Failed() {
+		failures = "```" + summary.String() + "```"
+		messages = append(messages, slashCommandResponse{Text: failures})
+	}
+
+	// The last post tells the user the report is truncated, with the failures.
+	if len(messages) > slackMaxResponses {
+		sent := slackMaxResponses - 1
+		log.Warnf("slack command %s from %s: the report has %d messages, only %d are sent", cmd.Name, cmd.UserID, len(messages), sent)
+		text := fmt.Sprintf("The %s report is truncated, only %d of its %d messages are sent.", cmd.Name, sent, len(messages))
+		if len(failures) > 0 {
+			text += "\n" + failures
+		}
+		messages = append(messages[:sent], slashCommandResponse{Text: text})
+	}
+	for _, message := range messages {
+		h.reply(cmd, message)
+	}
+}
+
+// reply sends the message only to the user who runs the command.
+func (h *slashCommandHandler) reply(cmd slashCommand, message slashCommandResponse) {
+	message.ResponseType = "ephemeral"
+	if err := h.respond(cmd.ResponseURL, message); err != nil {
+		log.Errorf("respond slack command %s from %s: %v", cmd.Name, cmd.UserID, err)
+	}
+}
+
+func (h *slashCommandHandler) wait() {
+	h.running.Wait()
+}
+
+func writeSlashCommandResponse(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(slashCommandResponse{ResponseType: "ephemeral", Text: text}); err != nil {
+		log.Errorf("write slack response: %v", err)
+	}
+}
+
+func postSlashCommandResponse(client *http.Client, responseURL string, message slashCommandResponse) error {
+	if len(responseURL) == 0 {
+		return errors.NotFoundf("response_url")
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := client.Post(responseURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("slack response_url returns %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// buildSlashCommandReport builds the report with the same builders as the daily command.
+func buildSlashCommandReport(cmd slashCommand) (*Report, *RunSummary, error) {
+	summary := newRunSummary("serve " + cmd.Name)
+	start := reportClock().UTC().Add(-24 * time.Hour).Format(githubUTCDateFormat)
+
+	var (
+		team   *Team
+		member Member
+		err    error
+	)
+	if len(cmd.Args) > 0 && cmd.Name != "me" {
+		team, err = findTeam(cmd.Args[0])
+	} else {
+		team, member, err = findSlackUserMember(cmd.UserID)
+	}
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	switch cmd.Name {
+	case "daily":
+		return buildDailyReport(team, start, summary), summary, nil
+	case "me":
+		reviews, err := getReviewRequestedPullRequests(member.Github)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		return newDailyDigest(team, buildDailyReport(team, start, summary), member, reviews), summary, nil
+	case "due":
+		report := &Report{Kind: "due", Title: "Due Date Report", Team: team.Name}
+		addDueDateSections(report, team, summary)
+		return report, summary, nil
+	}
+	return nil, nil, errors.NotSupportedf("command %s", cmd.Name)
+}
+
+func findTeam(name string) (*Team, error) {
+	for _, team := range teams {
+		if strings.EqualFold(team.Name, name) {
+			return team, nil
+		}
+	}
+	return nil, errors.NotFoundf("team %s", name)
+}
+
+// findSlackUserMember finds the team member by the email of the Slack user.
+func findSlackUserMember(userID string) (*Team, Member, error) {
+	email, err := slackMemberEmail(userID)
+	if err != nil {
+		return nil, Member{}, errors.Trace(err)
+	}
+	for _, team := range teams {
+		for _, member := range team.Members {
+			if strings.EqualFold(member.Email, email) {
+				return team, member, nil
+			}
+		}
+	}
+	return nil, Member{}, errors.NotFoundf("team member of %s", email)
+}
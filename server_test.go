@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nlopes/slack"
+)
+
+func signSlackRequest(req *http.Request, body string, secret string, ts time.Time) {
+	timestamp := fmt.Sprint(ts.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%s:%s", slackSignatureVersion, timestamp, body)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", slackSignatureVersion+"="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestSlashCommandHandler(t *testing.T) {
+	const secret = "signing-secret"
+	form := url.Values{"text": {"daily Team"}, "user_id": {"UALICE0001"}, "response_url": {"https://hooks.slack.com/commands/1"}}
+
+	tests := []struct {
+		name string
+		text string
+		// signedAt is the timestamp of the request, relative to testNow.
+		signedAt time.Duration
+		secret   string
+		status   int
+		reply    string
+		// built is whether the report is built and posted to the response_url.
+		built bool
+	}{
+		{name: "valid", signedAt: -time.Minute, secret: secret, status: http.StatusOK, reply: "Generating the daily report", built: true},
+		{name: "stale timestamp", signedAt: -10 * time.Minute, secret: secret, status: http.StatusUnauthorized},
+		{name: "future timestamp", signedAt: 10 * time.Minute, secret: secret, status: http.StatusUnauthorized},
+		{name: "bad signature", signedAt: -time.Minute, secret: "other-secret", status: http.StatusUnauthorized},
+		{name: "unknown command", text: "weekly", signedAt: -time.Minute, secret: secret, status: http.StatusOK, reply: "Usage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakes(t)
+			var (
+				mu       sync.Mutex
+				builds   []slashCommand
+				messages []slashCommandResponse
+			)
+			h := newSlashCommandHandler(secret)
+			h.now = func() time.Time { return testNow }
+			h.build = func(cmd slashCommand) (*Report, *RunSummary, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				builds = append(builds, cmd)
+				report := &Report{Kind: "daily", Title: "Daily Report", Team: "Team"}
+				return report, newRunSummary("serve " + cmd.Name), nil
+			}
+			h.respond = func(responseURL string, message slashCommandResponse) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if responseURL != form.Get("response_url") {
+					t.Errorf("respond to %s", responseURL)
+				}
+				messages = append(messages, message)
+				return nil
+			}
+
+			values := url.Values{}
+			for k, v := range form {
+				values[k] = v
+			}
+			if len(tt.text) > 0 {
+				values.Set("text", tt.text)
+			}
+			body := values.Encode()
+			req := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(body))
+			signSlackRequest(req, body, tt.secret, testNow.Add(tt.signedAt))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			h.wait()
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.reply) {
+				t.Errorf("reply %q doesn't contain %q", w.Body.String(), tt.reply)
+			}
+			if !tt.built {
+				if len(builds) > 0 || len(messages) > 0 {
+					t.Errorf("built %v and responded %v", builds, messages)
+				}
+				return
+			}
+			if len(builds) != 1 || builds[0].Name != "daily" || strings.Join(builds[0].Args, ",") != "Team" || builds[0].UserID != "UALICE0001" {
+				t.Errorf("built %+v", builds)
+			}
+			if len(messages) != 1 || messages[0].ResponseType != "ephemeral" || !strings.Contains(messages[0].Text, "Daily Report") {
+				t.Errorf("responded %+v", messages)
+			}
+		})
+	}
+}
+
+const testPullRequestEvent = `{
+  "action": "opened",
+  "pull_request": {
+    "number": 1,
+    "state": "open",
+    "title": "Fix the planner",
+    "body": "PTAL @alice",
+    "html_url": "https://github.com/%s/pull/1",
+    "user": {"login": "carol"}
+  },
+  "repository": {"full_name": "%s"},
+  "sender": {"login": "carol"}
+}`
+
+func TestGithubWebhookHandler(t *testing.T) {
+	const secret = "webhook-secret"
+	sign := func(body string, secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name      string
+		repo      string
+		signature func(body string) string
+		status    int
+		stored    bool
+	}{
+		{name: "valid", repo: "pingcap/tidb", signature: func(body string) string { return sign(body, secret) }, status: http.StatusNoContent, stored: true},
+		{name: "bad signature", repo: "pingcap/tidb", signature: func(body string) string { return sign(body, "other-secret") }, status: http.StatusUnauthorized},
+		{name: "no signature", repo: "pingcap/tidb", signature: func(body string) string { return "" }, status: http.StatusUnauthorized},
+		{name: "other repo", repo: "pingcap/pd", signature: func(body string) string { return sign(body, secret) }, status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakes(t)
+			store := newGithubEventStore(t.TempDir(), 0)
+			h := newGithubWebhookHandler(secret, store)
+			h.now = func() time.Time { return testNow }
+
+			body := fmt.Sprintf(testPullRequestEvent, tt.repo, tt.repo)
+			req := httptest.NewRequest(http.MethodPost, "/github/webhook", strings.NewReader(body))
+			req.Header.Set("X-GitHub-Event", "pull_request")
+			req.Header.Set("X-GitHub-Delivery", "delivery-1")
+			if signature := tt.signature(body); len(signature) > 0 {
+				req.Header.Set("X-Hub-Signature-256", signature)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			events, err := store.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.stored {
+				if len(events) > 0 {
+					t.Errorf("stored %+v", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("stored %d events", len(events))
+			}
+			event := events[0]
+			if event.Delivery != "delivery-1" || event.Action != "opened" || !event.Time.Equal(testNow) ||
+				event.Actor != "carol" || strings.Join(event.Mentions, ",") != "alice" {
+				t.Errorf("stored %+v", event)
+			}
+		})
+	}
+}
+
+func TestSlashCommandTruncated(t *testing.T) {
+	setupFakes(t)
+	var messages []slashCommandResponse
+	h := newSlashCommandHandler("")
+	h.build = func(cmd slashCommand) (*Report, *RunSummary, error) {
+		report := &Report{Kind: "daily", Title: "Daily Report", Team: "Team"}
+		var items []ReportItem
+		for i := 0; i < 300; i++ {
+			items = append(items, ReportItem{Title: strings.Repeat("x", 100), Link: fmt.Sprintf("https://github.com/pingcap/tidb/pull/%d", i)})
+		}
+		report.AddSection("Pull Requests", "", items)
+		summary := newRunSummary("serve " + cmd.Name)
+		summary.Record("due dates", fmt.Errorf("jira is down"))
+		return report, summary, nil
+	}
+	h.respond = func(responseURL string, message slashCommandResponse) error {
+		messages = append(messages, message)
+		return nil
+	}
+
+	h.handle(slashCommand{Name: "daily", UserID: "UALICE0001", ResponseURL: "https://hooks.slack.com/commands/1"})
+	if len(messages) != slackMaxResponses {
+		t.Fatalf("responded %d messages, want %d", len(messages), slackMaxResponses)
+	}
+	last := messages[len(messages)-1].Text
+	if !strings.Contains(last, "The daily report is truncated, only 4 of its") || !strings.Contains(last, "jira is down") {
+		t.Errorf("the last message %q", last)
+	}
+}
+
+func TestSlackMemberRefresh(t *testing.T) {
+	_, _, _, sl := setupFakes(t)
+	if id, err := slackMemberID("alice@example.com"); err != nil || id != "UALICE0001" {
+		t.Fatalf("alice %s %v", id, err)
+	}
+
+	// Carol joins later, she isn't found until the member list is old enough.
+	sl.Users = append(sl.Users, slack.User{ID: "UCAROL0001", Profile: slack.UserProfile{Email: "Carol@example.com"}})
+	if _, err := slackMemberID("carol@example.com"); !errors.IsNotFound(err) {
+		t.Fatalf("carol is found before the refresh: %v", err)
+	}
+	slackMemberLoaded = slackMemberLoaded.Add(-slackMemberRefreshInterval)
+	if id, err := slackMemberID("carol@example.com"); err != nil || id != "UCAROL0001" {
+		t.Errorf("carol %s %v", id, err)
+	}
+	if email, err := slackMemberEmail("UCAROL0001"); err != nil || email != "carol@example.com" {
+		t.Errorf("email of carol %s %v", email, err)
+	}
+}
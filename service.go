@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	"github.com/nlopes/slack"
+)
+
+// GithubSearcher searches GitHub issues and pull requests, and reads the
+// reviews and the timelines of pull requests.
+type GithubSearcher interface {
+	// SearchIssues returns one page of the search result and the next page number,
+	// the next page number is 0 if it's the last page.
+	SearchIssues(ctx context.Context, query string, opt *github.SearchOptions) ([]github.Issue, int, error)
+	// ListReviews and ListTimeline return one page like SearchIssues.
+	ListReviews(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.PullRequestReview, int, error)
+	ListTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.Timeline, int, error)
+}
+
+// IssueSearcher searches and links Jira issues.
+type IssueSearcher interface {
+	Search(jql string, opts *jira.SearchOptions) ([]jira.Issue, *jira.Response, error)
+	GetWorklogs(key string) (*jira.Worklog, error)
+	AddLink(link *jira.IssueLink) error
+	// GetStatuses returns all the statuses and their categories.
+	GetStatuses() ([]jira.Status, error)
+	// GetChangelog returns all the histories of the issue, the search result has only the first 100.
+	GetChangelog(key string) ([]jira.ChangelogHistory, error)
+}
+
+// SprintManager manages the boards and sprints of Jira agile.
+type SprintManager interface {
+	GetAllBoards(opts *jira.BoardListOptions) (*jira.BoardsList, error)
+	GetAllSprints(boardID int, opts *jira.GetAllSprintsOptions) (*jira.SprintsList, error)
+	CreateSprint(boardID int, name string, startDate, endDate string) (jira.Sprint, error)
+	UpdateSprint(sprintID int, args map[string]string) (jira.Sprint, error)
+	DeleteSprint(sprintID int) error
+	// MoveIssuesToSprint moves at most 50 issues in one call.
+	MoveIssuesToSprint(sprintID int, issueIDs []string) error
+}
+
+// ContentStore stores the Confluence pages.
+type ContentStore interface {
+	GetContentByTitle(space string, title string) (Content, error)
+	GetContent(id string) (Content, error)
+	CreateContent(content *Content) (Content, error)
+	UpdateContent(content *Content) (Content, error)
+	DeleteContent(id string) error
+	GetAttachments(contentID string) ([]Attachment, error)
+	CreateAttachment(contentID string, file attachmentFile) (Attachment, error)
+	// UpdateAttachment uploads the file as a new version of the attachment.
+	UpdateAttachment(contentID string, attachmentID string, file attachmentFile) (Attachment, error)
+	DeleteAttachment(attachmentID string) error
+	// GetChildPages returns all the child pages with their versions and histories.
+	GetChildPages(contentID string) ([]Content, error)
+	AddLabels(contentID string, labels []string) error
+	// SetRestrictions replaces the restrictions of the operations, it returns a
+	// NotSupported error on Confluence Server and Data Center, only Cloud has the API.
+	SetRestrictions(contentID string, restrictions []ContentRestriction) error
+}
+
+// MessagePoster posts messages to Slack.
+type MessagePoster interface {
+	PostMessage(channel string, user string, text string) error
+	// PostBlocks posts a Block Kit message and returns its timestamp, the message
+	// is posted in the thread of threadTS if it's not empty.
+	PostBlocks(channel string, user string, threadTS string, text string, blocks []SlackBlock) (string, error)
+	GetUsers() ([]slack.User, error)
+}
+
+var (
+	githubSearcher GithubSearcher
+	issueSearcher  IssueSearcher
+	sprintManager  SprintManager
+	contentStore   ContentStore
+	messagePoster  MessagePoster
+)
+
+type githubService struct {
+	client *github.Client
+}
+
+func (s *githubService) SearchIssues(ctx context.Context, query string, opt *github.SearchOptions) ([]github.Issue, int, error) {
+	issues, resp, err := s.client.Search.Issues(ctx, query, opt)
+	if err != nil {
+		return nil, 0, err
+	}
+	return issues.Issues, resp.NextPage, nil
+}
+
+func (s *githubService) ListReviews(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.PullRequestReview, int, error) {
+	reviews, resp, err := s.client.PullRequests.ListReviews(ctx, owner, repo, number, opt)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reviews, resp.NextPage, nil
+}
+
+func (s *githubService) ListTimeline(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.Timeline, int, error) {
+	events, resp, err := s.client.Issues.ListIssueTimeline(ctx, owner, repo, number, opt)
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, resp.NextPage, nil
+}
+
+// jiraService implements both IssueSearcher and SprintManager.
+type jiraService struct {
+	client *jira.Client
+}
+
+func (s *jiraService) Search(jql string, opts *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	return s.client.Issue.Search(jql, opts)
+}
+
+func (s *jiraService) GetWorklogs(key string) (*jira.Worklog, error) {
+	workLogs, _, err := s.client.Issue.GetWorklogs(key)
+	return workLogs, err
+}
+
+// jiraChangelogPage is a page of rest/api/2/issue/{key}/changelog.
+type jiraChangelogPage struct {
+	Total  int                     `json:"total"`
+	IsLast bool                    `json:"isLast"`
+	Values []jira.ChangelogHistory `json:"values"`
+}
+
+func (s *jiraService) GetChangelog(key string) ([]jira.ChangelogHistory, error) {
+	var histories []jira.ChangelogHistory
+	for {
+		u := fmt.Sprintf("rest/api/2/issue/%s/changelog?startAt=%d&maxResults=%d", url.PathEscape(key), len(histories), jiraChangelogPageSize)
+		req, err := s.client.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page jiraChangelogPage
+		resp, err := s.client.Do(req, &page)
+		if err != nil && len(histories) == 0 && resp != nil && resp.StatusCode == http.StatusNotFound {
+			// Jira Server has no changelog API, but returns all the histories of one issue.
+			issue, _, err := s.client.Issue.Get(key, &jira.GetQueryOptions{Fields: "status", Expand: "changelog"})
+			if err != nil {
+				return nil, err
+			}
+			if issue.Changelog == nil {
+				return nil, nil
+			}
+			return issue.Changelog.Histories, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		histories = append(histories, page.Values...)
+		if page.IsLast || len(page.Values) == 0 || len(histories) >= page.Total {
+			return histories, nil
+		}
+	}
+}
+
+func (s *jiraService) AddLink(link *jira.IssueLink) error {
+	_, err := s.client.Issue.AddLink(link)
+	return err
+}
+
+func (s *jiraService) GetStatuses() ([]jira.Status, error) {
+	req, err := s.client.NewRequest("GET", "rest/api/2/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []jira.Status
+	_, err = s.client.Do(req, &statuses)
+	return statuses, err
+}
+
+func (s *jiraService) GetAllBoards(opts *jira.BoardListOptions) (*jira.BoardsList, error) {
+	boards, _, err := s.client.Board.GetAllBoards(opts)
+	return boards, err
+}
+
+func (s *jiraService) GetAllSprints(boardID int, opts *jira.GetAllSprintsOptions) (*jira.SprintsList, error) {
+	sprints, _, err := s.client.Board.GetAllSprintsWithOptions(boardID, opts)
+	return sprints, err
+}
+
+func (s *jiraService) CreateSprint(boardID int, name string, startDate, endDate string) (jira.Sprint, error) {
+	apiEndpoint := "rest/agile/1.0/sprint"
+	sprint := map[string]string{
+		"name":          name,
+		"startDate":     startDate,
+		"endDate":       endDate,
+		"originBoardId": strconv.Itoa(boardID),
+	}
+	req, err := s.client.NewRequest("POST", apiEndpoint, sprint)
+	if err != nil {
+		return jira.Sprint{}, err
+	}
+
+	var responseSprint jira.Sprint
+	_, err = s.client.Do(req, &responseSprint)
+	return responseSprint, err
+}
+
+func (s *jiraService) UpdateSprint(sprintID int, args map[string]string) (jira.Sprint, error) {
+	apiEndpoint := "rest/agile/1.0/sprint/" + strconv.Itoa(sprintID)
+	req, err := s.client.NewRequest("POST", apiEndpoint, args)
+	if err != nil {
+		return jira.Sprint{}, err
+	}
+
+	var responseSprint jira.Sprint
+	_, err = s.client.Do(req, &responseSprint)
+	return responseSprint, err
+}
+
+func (s *jiraService) DeleteSprint(sprintID int) error {
+	apiEndpoint := "rest/agile/1.0/sprint/" + strconv.Itoa(sprintID)
+	req, err := s.client.NewRequest("DELETE", apiEndpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Do(req, nil)
+	return err
+}
+
+// https://developer.atlassian.com/cloud/jira/software/rest/#api-rest-agile-1-0-sprint-sprintId-issue-post
+func (s *jiraService) MoveIssuesToSprint(sprintID int, issueIDs []string) error {
+	apiEndpoint := fmt.Sprintf("rest/agile/1.0/sprint/%d/issue", sprintID)
+	payload := jira.IssuesWrapper{Issues: issueIDs}
+	req, err := s.client.NewRequest("POST", apiEndpoint, payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Do(req, nil)
+	return err
+}
+
+// A little tricky here, both JIRA and Confluence use the same REST style,
+// so we use the jira.Client to access Confluence too.
+type confluenceService struct {
+	client *jira.Client
+}
+
+func (s *confluenceService) GetContentByTitle(space string, title string) (Content, error) {
+	opts := struct {
+		Title    string `url:"title"`
+		SpaceKey string `url:"spaceKey"`
+		Expand   string `url:"expand"`
+	}{
+		Title:    title,
+		SpaceKey: space,
+		Expand:   "body.storage,version.number,space.key",
+	}
+
+	apiEndpoint, err := addOptions("rest/api/content", opts)
+	if err != nil {
+		return Content{}, err
+	}
+
+	req, err := s.client.NewRequest("GET", apiEndpoint, nil)
+	if err != nil {
+		return Content{}, err
+	}
+
+	res := struct {
+		Results []Content `json:"results"`
+	}{}
+
+	if _, err = s.client.Do(req, &res); err != nil {
+		return Content{}, err
+	}
+
+	if len(res.Results) == 0 {
+		return Content{}, nil
+	}
+
+	return res.Results[0], nil
+}
+
+func (s *confluenceService) GetContent(id string) (Content, error) {
+	apiEndpoint := fmt.Sprintf("rest/api/content/%s?expand=body.storage,version.number,space.key", id)
+
+	req, err := s.client.NewRequest("GET", apiEndpoint, nil)
+	if err != nil {
+		return Content{}, err
+	}
+
+	var content Content
+	_, err = s.client.Do(req, &content)
+	return content, err
+}
+
+func (s *confluenceService) CreateContent(content *Content) (Content, error) {
+	req, err := s.client.NewRequest("POST", "rest/api/content", content)
+	if err != nil {
+		return Content{}, err
+	}
+
+	var respContent Content
+	_, err = s.client.Do(req, &respContent)
+	return respContent, err
+}
+
+func (s *confluenceService) UpdateContent(content *Content) (Content, error) {
+	req, err := s.client.NewRequest("PUT", "rest/api/content/"+content.Id, content)
+	if err != nil {
+		return Content{}, err
+	}
+
+	var respContent Content
+	resp, err := s.client.Do(req, &respContent)
+	if resp != nil && resp.StatusCode == http.StatusConflict {
+		return Content{}, errContentVersionConflict
+	}
+	return respContent, err
+}
+
+func (s *confluenceService) DeleteContent(id string) error {
+	req, err := s.client.NewRequest("DELETE", "rest/api/content/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Do(req, nil)
+	return err
+}
+
+func (s *confluenceService) GetAttachments(contentID string) ([]Attachment, error) {
+	apiEndpoint := fmt.Sprintf("rest/api/content/%s/child/attachment?expand=version&limit=200", contentID)
+	req, err := s.client.NewRequest("GET", apiEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := struct {
+		Results []Attachment `json:"results"`
+	}{}
+	_, err = s.client.Do(req, &res)
+	return res.Results, err
+}
+
+// confluencePageSize is the page size we ask for, the server may cap it lower.
+const confluencePageSize = 100
+
+func (s *confluenceService) GetChildPages(contentID string) ([]Content, error) {
+	var pages []Content
+	for start := 0; ; {
+		apiEndpoint := fmt.Sprintf("rest/api/content/%s/child/page?expand=version,history&start=%d&limit=%d",
+			contentID, start, confluencePageSize)
+		req, err := s.client.NewRequest("GET", apiEndpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res := struct {
+			Results []Content `json:"results"`
+			Links   struct {
+				Next string `json:"next"`
+			} `json:"_links"`
+		}{}
+		if _, err = s.client.Do(req, &res); err != nil {
+			return nil, err
+		}
+		pages = append(pages, res.Results...)
+		if len(res.Results) == 0 || len(res.Links.Next) == 0 {
+			return pages, nil
+		}
+		start += len(res.Results)
+	}
+}
+
+func (s *confluenceService) AddLabels(contentID string, labels []string) error {
+	type label struct {
+		Prefix string `json:"prefix"`
+		Name   string `json:"name"`
+	}
+	body := make([]label, 0, len(labels))
+	for _, name := range labels {
+		body = append(body, label{Prefix: "global", Name: name})
+	}
+	req, err := s.client.NewRequest("POST", fmt.Sprintf("rest/api/content/%s/label", contentID), body)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Do(req, nil)
+	return err
+}
+
+func (s *confluenceService) SetRestrictions(contentID string, restrictions []ContentRestriction) error {
+	req, err := s.client.NewRequest("PUT", fmt.Sprintf("rest/api/content/%s/restriction", contentID), restrictions)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req, nil)
+	if err != nil && resp != nil {
+		switch resp.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return errors.NotSupportedf("PUT rest/api/content/{id}/restriction (%s), it needs Confluence Cloud", resp.Status)
+		}
+	}
+	return err
+}
+
+// attachmentBoundary is fixed, so the recorded uploads can be replayed.
+const attachmentBoundary = "work-reporter-attachment-boundary"
+
+func (s *confluenceService) uploadAttachment(apiEndpoint string, file attachmentFile) (*jira.Response, []byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.SetBoundary(attachmentBoundary); err != nil {
+		return nil, nil, err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, file.Name))
+	header.Set("Content-Type", file.MediaType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = part.Write(file.Data); err != nil {
+		return nil, nil, err
+	}
+	if err = w.WriteField("minorEdit", "true"); err != nil {
+		return nil, nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := s.client.NewMultiPartRequest("POST", apiEndpoint, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var raw json.RawMessage
+	resp, err := s.client.Do(req, &raw)
+	return resp, raw, err
+}
+
+func (s *confluenceService) CreateAttachment(contentID string, file attachmentFile) (Attachment, error) {
+	_, raw, err := s.uploadAttachment(fmt.Sprintf("rest/api/content/%s/child/attachment", contentID), file)
+	if err != nil {
+		return Attachment{}, err
+	}
+	res := struct {
+		Results []Attachment `json:"results"`
+	}{}
+	if err = json.Unmarshal(raw, &res); err != nil {
+		return Attachment{}, err
+	}
+	if len(res.Results) == 0 {
+		return Attachment{}, errors.NotFoundf("attachment %s in the response", file.Name)
+	}
+	return res.Results[0], nil
+}
+
+func (s *confluenceService) UpdateAttachment(contentID string, attachmentID string, file attachmentFile) (Attachment, error) {
+	_, raw, err := s.uploadAttachment(fmt.Sprintf("rest/api/content/%s/child/attachment/%s/data", contentID, attachmentID), file)
+	if err != nil {
+		return Attachment{}, err
+	}
+	var attachment Attachment
+	err = json.Unmarshal(raw, &attachment)
+	return attachment, err
+}
+
+func (s *confluenceService) DeleteAttachment(attachmentID string) error {
+	return s.DeleteContent(attachmentID)
+}
+
+// slackService lazily creates the Slack client, so commands which
+// don't touch Slack never need a Slack token.
+type slackService struct {
+	token      string
+	httpClient *http.Client
+	client     *slack.Client
+}
+
+func (s *slackService) getClient() *slack.Client {
+	if s.client == nil {
+		s.client = slack.New(s.token, slack.OptionHTTPClient(s.httpClient))
+	}
+	return s.client
+}
+
+func (s *slackService) PostMessage(channel string, user string, text string) error {
+	_, _, err := s.getClient().PostMessage(channel,
+		slack.MsgOptionUser(user),
+		slack.MsgOptionText(text, false))
+	return err
+}
+
+func (s *slackService) PostBlocks(channel string, user string, threadTS string, text string, blocks []SlackBlock) (string, error) {
+	data, err := json.Marshal(blocks)
+	if err != nil {
+		return "", err
+	}
+	options := []slack.MsgOption{
+		slack.MsgOptionUser(user),
+		slack.MsgOptionText(text, false),
+		// The vendored slack library doesn't support blocks, so set the form value directly.
+		slack.UnsafeMsgOptionEndpoint(slack.APIURL+"chat.postMessage", func(values url.Values) {
+			values.Set("blocks", string(data))
+		}),
+	}
+	if len(threadTS) > 0 {
+		options = append(options, slack.MsgOptionTS(threadTS))
+	}
+	_, ts, err := s.getClient().PostMessage(channel, options...)
+	return ts, err
+}
+
+func (s *slackService) GetUsers() ([]slack.User, error) {
+	return s.getClient().GetUsers()
+}
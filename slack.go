@@ -1,231 +1,150 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andygrunwald/go-jira"
-	"github.com/google/go-github/github"
 	"github.com/juju/errors"
-	"github.com/nlopes/slack"
+	"github.com/ngaut/log"
 	"github.com/nlopes/slack/slackutilsx"
 )
 
-// Use getSlackClient() to access it with lazy initialize feature.
-var slackClient *slack.Client = nil
-
-var slackMemberInit = false
-var slackMembers = map[string]string{}
+var (
+	// slackMembers maps the lower case emails to the Slack user IDs.
+	slackMembers  = map[string]string{}
+	slackMemberMu sync.Mutex
+	// slackMemberLoaded is when slackMembers is last loaded, a lookup miss loads
+	// it again after slackMemberRefreshInterval, so the long running serve finds
+	// the people who join later.
+	slackMemberLoaded time.Time
+)
 
-func getSlackClient() *slack.Client {
-	if slackClient == nil {
-		slackClient = slack.New(config.Slack.Token)
-	}
-	return slackClient
-}
+const slackMemberRefreshInterval = 10 * time.Minute
 
-func initSlackMemberCache() {
-	if slackMemberInit {
-		return
+// loadSlackMembers must be called with slackMemberMu held. A failed load isn't
+// retried in slackMemberRefreshInterval either.
+func loadSlackMembers() error {
+	slackMemberLoaded = time.Now()
+	users, err := messagePoster.GetUsers()
+	if err != nil {
+		return errors.Trace(err)
 	}
-	users, err := getSlackClient().GetUsers()
-	perror(errors.Trace(err))
 	if len(users) == 0 {
-		perror(fmt.Errorf("cannot retrieve slack user list. slack app must be granted `users:read` and `users:read.email` permission"))
+		return errors.New("cannot retrieve slack user list. slack app must be granted `users:read` and `users:read.email` permission")
 	}
 
+	members := make(map[string]string, len(users))
 	for _, user := range users {
-		slackMembers[strings.ToLower(user.Profile.Email)] = user.ID
+		members[strings.ToLower(user.Profile.Email)] = user.ID
 	}
-	slackMemberInit = true
+	slackMembers = members
+	return nil
 }
 
-func buildSlackMention(email string) string {
-	initSlackMemberCache()
-	id, ok := slackMembers[strings.ToLower(email)]
-	if !ok {
-		return slackutilsx.EscapeMessage(email)
-	}
-	return fmt.Sprintf("<@%s>", id)
-}
-
-func sendToSlack(format string, args ...interface{}) {
-	channelName := config.Slack.Channel
-	user := config.Slack.User
-
-	if channelName == "" {
-		println("no slack channel name")
-		return
+// lookupSlackMember returns the email and the ID of the first member matched,
+// or empty strings if no member is matched.
+func lookupSlackMember(match func(email string, id string) bool) (string, string, error) {
+	slackMemberMu.Lock()
+	defer slackMemberMu.Unlock()
+	find := func() (string, string) {
+		for email, id := range slackMembers {
+			if match(email, id) {
+				return email, id
+			}
+		}
+		return "", ""
 	}
-
-	if channelName[0] != '#' {
-		channelName = "#" + channelName
+	if email, id := find(); len(id) > 0 || time.Since(slackMemberLoaded) < slackMemberRefreshInterval {
+		return email, id, nil
 	}
-
-	_, _, err := getSlackClient().PostMessage(channelName,
-		slack.MsgOptionUser(user),
-		slack.MsgOptionText(fmt.Sprintf(format, args...), false))
-	if err != nil {
-		perror(fmt.Errorf("can not post msg to slack with err: %v", err))
+	if err := loadSlackMembers(); err != nil {
+		return "", "", errors.Trace(err)
 	}
+	email, id := find()
+	return email, id, nil
 }
 
-func formatSectionForSlackOutput(buf *bytes.Buffer, title string, description string) {
-	buf.WriteString(fmt.Sprintf("*%s*\n", slackutilsx.EscapeMessage(title)))
-	buf.WriteString(fmt.Sprintf("> %s\n", slackutilsx.EscapeMessage(description)))
+func matchSlackEmail(email string) func(string, string) bool {
+	email = strings.ToLower(email)
+	return func(memberEmail string, id string) bool {
+		return memberEmail == email
+	}
 }
 
-func formatGitHubIssueForSlackOutput(issue github.Issue) string {
-	isFromTeam := false
-	login := issue.GetUser().GetLogin()
-
-	for _, id := range allSQLInfraMembers {
-		if strings.EqualFold(id, login) {
-			isFromTeam = true
-			break
-		}
+// buildSlackMention falls back to the plain email if the slack member list
+// can't be retrieved.
+func buildSlackMention(email string) string {
+	_, id, err := lookupSlackMember(matchSlackEmail(email))
+	if err != nil {
+		log.Warnf("build slack mention for %s: %v", email, err)
 	}
-	var tp string
-	if !isFromTeam {
-		tp = " _(NotDDLTeam)_"
-	}
-	var closed string
-	if issue.GetState() == "closed" {
-		closed = " _(Closed)_"
-	}
-
-	s := fmt.Sprintf(
-		"%s%s <%s|%s> by @%s",
-		// slackutilsx.EscapeMessage(regexRepo.FindStringSubmatch(issue.GetHTMLURL())[1]),
-		slackutilsx.EscapeMessage(closed),
-		slackutilsx.EscapeMessage(tp),
-		issue.GetHTMLURL(),
-		slackutilsx.EscapeMessage(issue.GetTitle()),
-		slackutilsx.EscapeMessage(issue.GetUser().GetLogin()),
-	)
-
-	if issue.Assignees != nil && len(issue.Assignees) > 0 {
-		s += fmt.Sprintf(", assigned to")
-		for _, assigne := range issue.Assignees {
-			s += fmt.Sprintf(" @%s", assigne.GetLogin())
-		}
+	if len(id) == 0 {
+		return slackutilsx.EscapeMessage(email)
 	}
-
-	return s
+	return fmt.Sprintf("<@%s>", id)
 }
 
-func formatGithubMentionsPRForSlackOutput(item *GithubItem) string {
-	slackMentions := make([]string, 0, len(item.memtions))
-	for _, memtion := range item.memtions {
-		email, ok := github2Email[memtion]
-		if !ok {
-			continue
-		}
-		slackMentions = append(slackMentions, buildSlackMention(email))
+// slackMemberID returns the Slack user ID of the email.
+func slackMemberID(email string) (string, error) {
+	_, id, err := lookupSlackMember(matchSlackEmail(email))
+	if err != nil {
+		return "", errors.Trace(err)
 	}
-
-	if len(slackMentions) == 0 {
-		return "_None_"
+	if len(id) == 0 {
+		return "", errors.NotFoundf("slack user of %s", email)
 	}
+	return id, nil
+}
 
-	issue := item.issue
-	isFromTeam := false
-	login := issue.GetUser().GetLogin()
-
-	for _, id := range allSQLInfraMembers {
-		if strings.EqualFold(id, login) {
-			isFromTeam = true
-			break
-		}
+// slackMemberEmail returns the email of the Slack user ID.
+func slackMemberEmail(id string) (string, error) {
+	email, _, err := lookupSlackMember(func(memberEmail string, memberID string) bool {
+		return memberID == id
+	})
+	if err != nil {
+		return "", errors.Trace(err)
 	}
-	var tp string
-	if !isFromTeam {
-		tp = " _(NotDDLTeam)_"
-	}
-	var closed string
-	if issue.GetState() == "closed" {
-		closed = " _(Closed)_"
-	}
-
-	s := fmt.Sprintf(
-		"%s%s <%s|%s> by @%s mentioned: %s",
-		// slackutilsx.EscapeMessage(regexRepo.FindStringSubmatch(issue.GetHTMLURL())[1]),
-		slackutilsx.EscapeMessage(closed),
-		slackutilsx.EscapeMessage(tp),
-		issue.GetHTMLURL(),
-		slackutilsx.EscapeMessage(issue.GetTitle()),
-		slackutilsx.EscapeMessage(issue.GetUser().GetLogin()),
-		strings.Join(slackMentions, ","),
-	)
-
-	if issue.Assignees != nil && len(issue.Assignees) > 0 {
-		s += fmt.Sprintf(", assigned to")
-		for _, assigne := range issue.Assignees {
-			s += fmt.Sprintf(" @%s", assigne.GetLogin())
-		}
+	if len(email) == 0 {
+		return "", errors.NotFoundf("email of slack user %s", id)
 	}
-
-	return s
+	return email, nil
 }
 
-func formatJiraIssueForSlackOutput(issue jira.Issue) string {
-	link := fmt.Sprintf("%s/browse/%s", config.Jira.Endpoint, issue.Key)
-	status := "Unknown"
-	if issue.Fields != nil && issue.Fields.Status != nil {
-		status = issue.Fields.Status.Name
-	}
-	priority := "Unknown"
-	if issue.Fields != nil && issue.Fields.Priority != nil {
-		priority = issue.Fields.Priority.Name
-	}
-	assignment := ""
-	if issue.Fields != nil && issue.Fields.Assignee != nil {
-		assignment = fmt.Sprintf("assigned to %s", buildSlackMention(issue.Fields.Assignee.EmailAddress))
-	}
-
-	dueDate, _ := issue.Fields.Duedate.MarshalJSON()
-	return fmt.Sprintf(
-		"[ %s / %s ] DueDate:%s <%s|%s> %s",
-		slackutilsx.EscapeMessage(status),
-		slackutilsx.EscapeMessage(priority),
-		slackutilsx.EscapeMessage(string(dueDate)),
-		link,
-		slackutilsx.EscapeMessage(issue.Fields.Summary),
-		assignment,
-	)
-}
+// slackIDPattern matches the user and channel IDs, the channel names are always lower case.
+var slackIDPattern = regexp.MustCompile(`^[UWCDG][A-Z0-9]{8,}$`)
 
-func formatGitHubIssuesForSlackOutput(buf *bytes.Buffer, issues []github.Issue) {
-	if len(issues) == 0 {
-		buf.WriteString("_None_\n")
-		return
+func slackChannel(channelName string) (string, error) {
+	if channelName == "" {
+		return "", errors.New("no slack channel name")
 	}
-	for _, issue := range issues {
-		buf.WriteString(fmt.Sprintf("• %s\n", formatGitHubIssueForSlackOutput(issue)))
+
+	if channelName[0] != '#' && !slackIDPattern.MatchString(channelName) {
+		channelName = "#" + channelName
 	}
+	return channelName, nil
 }
 
-func formatCollectMentionsPRForSlackOutput(buf *bytes.Buffer, collector map[string]*GithubItem) {
-	if len(collector) == 0 {
-		buf.WriteString("_None_\n")
-		return
-	}
+// sendToSlack posts the text, the long text is split into several messages.
+func sendToSlack(channelName string, format string, args ...interface{}) error {
+	user := config.Slack.User
 
-	for _, item := range collector {
-		buf.WriteString(fmt.Sprintf("• %s\n", formatGithubMentionsPRForSlackOutput(item)))
+	channelName, err := slackChannel(channelName)
+	if err != nil {
+		return errors.Trace(err)
 	}
-}
 
-func formatJiraIssuesForSlackOutput(buf *bytes.Buffer, issues []jira.Issue) {
-	if len(issues) == 0 {
-		buf.WriteString("_None_\n")
-		return
-	}
-	for _, issue := range issues {
-		buf.WriteString(fmt.Sprintf("• %s\n", formatJiraIssueForSlackOutput(issue)))
+	parts := splitSlackText(fmt.Sprintf(format, args...), slackMaxMessageText)
+	for idx, part := range parts {
+		err := messagePoster.PostMessage(channelName, user, part)
+		if err != nil {
+			return errors.Annotatef(err, "can not post msg %d/%d to slack", idx+1, len(parts))
+		}
 	}
+	return nil
 }
 
 func findOutIssuesWithoutDueDate(issues []jira.Issue) []jira.Issue {
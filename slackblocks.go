@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+	"github.com/nlopes/slack/slackutilsx"
+)
+
+// The limits of the Slack messages, see https://api.slack.com/reference/block-kit/blocks.
+const (
+	slackMaxBlocks      = 50
+	slackMaxHeaderText  = 150
+	slackMaxSectionText = 3000
+	slackMaxContexts    = 10
+	// slackMaxMessageText is the text size of a message Slack recommends,
+	// the longer messages are split.
+	slackMaxMessageText = 4000
+	// slackMaxBlocksText keeps a Block Kit message well below the 40000
+	// characters Slack truncates the messages at.
+	slackMaxBlocksText = 12000
+)
+
+// SlackBlock is a Block Kit block, the vendored slack library doesn't support blocks.
+type SlackBlock struct {
+	Type     string      `json:"type"`
+	Text     *SlackText  `json:"text,omitempty"`
+	Elements []SlackText `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (b SlackBlock) textLen() int {
+	n := 0
+	if b.Text != nil {
+		n += len(b.Text.Text)
+	}
+	for _, e := range b.Elements {
+		n += len(e.Text)
+	}
+	return n
+}
+
+// SlackMessage is a Block Kit message, Text is the fallback of the notifications.
+type SlackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks"`
+	// Reply posts the message in the thread of the first message.
+	Reply bool `json:"reply,omitempty"`
+}
+
+func newSlackHeaderBlock(text string) SlackBlock {
+	return SlackBlock{Type: "header", Text: &SlackText{Type: "plain_text", Text: truncateText(text, slackMaxHeaderText)}}
+}
+
+// newSlackSectionBlocks splits the mrkdwn text into sections at the line boundaries.
+func newSlackSectionBlocks(text string) []SlackBlock {
+	parts := splitSlackText(text, slackMaxSectionText)
+	blocks := make([]SlackBlock, 0, len(parts))
+	for _, part := range parts {
+		blocks = append(blocks, SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: part}})
+	}
+	return blocks
+}
+
+func newSlackContextBlock(texts []string) SlackBlock {
+	if len(texts) > slackMaxContexts {
+		texts = append(texts[:slackMaxContexts-1], strings.Join(texts[slackMaxContexts-1:], " · "))
+	}
+	block := SlackBlock{Type: "context"}
+	for _, text := range texts {
+		block.Elements = append(block.Elements, SlackText{Type: "mrkdwn", Text: truncateText(text, slackMaxSectionText)})
+	}
+	return block
+}
+
+// newReportSlackBlocks returns the header blocks and the blocks of every section of the report.
+func newReportSlackBlocks(report *Report) ([]SlackBlock, [][]SlackBlock) {
+	r := slackRenderer{}
+	header := []SlackBlock{newSlackHeaderBlock(reportTitle(report))}
+
+	sections := make([][]SlackBlock, 0, len(report.Sections))
+	for _, section := range report.Sections {
+		blocks := []SlackBlock{{Type: "divider"}}
+		text := fmt.Sprintf("*%s*", slackutilsx.EscapeMessage(section.Title))
+		if len(section.Description) > 0 {
+			text += fmt.Sprintf("\n> %s", slackutilsx.EscapeMessage(section.Description))
+		}
+		if len(section.Items) == 0 {
+			text += "\n_None_"
+		}
+		blocks = append(blocks, newSlackSectionBlocks(text)...)
+		for _, item := range section.Items {
+			blocks = append(blocks, newSlackSectionBlocks("• "+r.formatItemBody(item))...)
+			if context := r.formatItemContext(item); len(context) > 0 {
+				blocks = append(blocks, newSlackContextBlock(context))
+			}
+		}
+		sections = append(sections, blocks)
+	}
+	return header, sections
+}
+
+func reportTitle(report *Report) string {
+	if len(report.Team) == 0 {
+		return report.Title
+	}
+	return fmt.Sprintf("%s (%s)", report.Title, report.Team)
+}
+
+// newReportSlackMessages builds the Block Kit messages of the report. If thread
+// is true, the first message only counts the items of every section, and the
+// sections are replied in its thread.
+func newReportSlackMessages(report *Report, thread bool) []SlackMessage {
+	header, sections := newReportSlackBlocks(report)
+	title := reportTitle(report)
+
+	var messages []SlackMessage
+	if !thread {
+		blocks := header
+		for _, section := range sections {
+			blocks = append(blocks, section...)
+		}
+		for _, part := range splitSlackBlocks(blocks) {
+			messages = append(messages, SlackMessage{Text: title, Blocks: part})
+		}
+		return messages
+	}
+
+	lines := make([]string, 0, len(report.Sections))
+	for _, section := range report.Sections {
+		lines = append(lines, fmt.Sprintf("*%s*: %d", slackutilsx.EscapeMessage(section.Title), len(section.Items)))
+	}
+	summary := append(header, newSlackSectionBlocks(strings.Join(lines, "\n"))...)
+	summary = append(summary, newSlackContextBlock([]string{"Details are in the thread"}))
+	for _, part := range splitSlackBlocks(summary) {
+		messages = append(messages, SlackMessage{Text: title, Blocks: part})
+	}
+	for idx, section := range sections {
+		for _, part := range splitSlackBlocks(section) {
+			messages = append(messages, SlackMessage{Text: report.Sections[idx].Title, Blocks: part, Reply: true})
+		}
+	}
+	return messages
+}
+
+// splitSlackBlocks splits the blocks into messages at the block count and the text size limits.
+func splitSlackBlocks(blocks []SlackBlock) [][]SlackBlock {
+	var (
+		messages [][]SlackBlock
+		current  []SlackBlock
+		size     int
+	)
+	for _, block := range blocks {
+		n := block.textLen()
+		if len(current) > 0 && (len(current) >= slackMaxBlocks || size+n > slackMaxBlocksText) {
+			messages = append(messages, current)
+			current, size = nil, 0
+		}
+		current = append(current, block)
+		size += n
+	}
+	if len(current) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// splitSlackText splits the text at the line boundaries, so every part is at
+// most limit bytes. The lines longer than limit are truncated.
+func splitSlackText(text string, limit int) []string {
+	var (
+		parts   []string
+		current []string
+		size    int
+	)
+	for _, line := range strings.Split(text, "\n") {
+		line = truncateText(line, limit)
+		if len(current) > 0 && size+1+len(line) > limit {
+			parts = append(parts, strings.Join(current, "\n"))
+			current, size = nil, 0
+		}
+		if len(current) > 0 {
+			size++
+		}
+		current = append(current, line)
+		size += len(line)
+	}
+	if len(current) > 0 {
+		parts = append(parts, strings.Join(current, "\n"))
+	}
+	return parts
+}
+
+// truncateText truncates the text to at most limit bytes at a rune boundary.
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	const ellipsis = "…"
+	text = text[:limit-len(ellipsis)]
+	for len(text) > 0 && !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text + ellipsis
+}
+
+// postSlackMessages posts the messages in order, the replies go to the thread
+// of the first message.
+func postSlackMessages(channelName string, messages []SlackMessage) error {
+	channel, err := slackChannel(channelName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var threadTS string
+	for idx, message := range messages {
+		var replyTo string
+		if message.Reply {
+			replyTo = threadTS
+		}
+		ts, err := messagePoster.PostBlocks(channel, config.Slack.User, replyTo, message.Text, message.Blocks)
+		if err != nil {
+			return errors.Annotatef(err, "can not post msg %d/%d to slack", idx+1, len(messages))
+		}
+		if idx == 0 {
+			threadTS = ts
+		}
+	}
+	return nil
+}
+
+// slackBlocksRenderer renders the Block Kit messages as JSON, they can be
+// pasted into the Slack Block Kit Builder.
+type slackBlocksRenderer struct{}
+
+func (r slackBlocksRenderer) Render(w io.Writer, report *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Trace(enc.Encode(newReportSlackMessages(report, config.Slack.Thread)))
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+const snapshotTimeFormat = "20060102T150405Z"
+
+// snapshotOpenTimeout waits for another run which has the database open.
+const snapshotOpenTimeout = 10 * time.Second
+
+// The kinds of the snapshots besides the daily reports.
+const (
+	snapshotKindWeeklyTeam    = "weekly-team"
+	snapshotKindReviewMetrics = "review-metrics"
+	snapshotKindJiraMetrics   = "jira-metrics"
+	snapshotKindTimesheet     = "timesheet"
+)
+
+// The metrics of a snapshot, every metric counts the items by member, by
+// reviewer or by repository.
+const (
+	metricOverdueIssues    = "overdue-issues"
+	metricJiraIssues       = "jira-issues"
+	metricOpenPRs          = "open-prs"
+	metricOpenCommunityPRs = "open-community-prs"
+	metricDoneIssues       = "done-issues"
+	metricInProgressIssues = "in-progress-issues"
+	metricNextWeekIssues   = "next-week-issues"
+	metricReviewedPRs      = "reviewed-prs"
+	metricFirstReviewHours = "first-review-hours"
+	metricResolvedIssues   = "resolved-issues"
+	metricLeadDays         = "lead-days"
+	metricCycleDays        = "cycle-days"
+	metricLoggedHours      = "logged-hours"
+)
+
+// snapshotKindMetrics are the metrics of every kind of the snapshots.
+var snapshotKindMetrics = map[string][]string{
+	"daily":                   {metricOverdueIssues, metricJiraIssues, metricOpenPRs, metricOpenCommunityPRs},
+	snapshotKindWeeklyTeam:    {metricDoneIssues, metricInProgressIssues, metricNextWeekIssues, metricOverdueIssues},
+	snapshotKindReviewMetrics: {metricReviewedPRs, metricFirstReviewHours},
+	snapshotKindJiraMetrics:   {metricResolvedIssues, metricLeadDays, metricCycleDays},
+	snapshotKindTimesheet:     {metricLoggedHours},
+}
+
+// Snapshot is the data of a run and the metrics computed from it.
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Team string    `json:"team"`
+	// Report is the report of a daily run.
+	Report *Report `json:"report,omitempty"`
+	// Data is what the other runs collect, like a TeamWeeklyReport.
+	Data    json.RawMessage           `json:"data,omitempty"`
+	Metrics map[string]map[string]int `json:"metrics"`
+}
+
+// SnapshotStore saves the snapshots in a bbolt database, with a bucket per
+// team, a nested bucket per kind, and the snapshots keyed by the time in the
+// nested buckets. The database is open only during a save or a load, so the
+// runs can share it.
+type SnapshotStore struct {
+	file string
+}
+
+var snapshots *SnapshotStore
+
+func newSnapshotStore(dir string) *SnapshotStore {
+	return &SnapshotStore{file: path.Join(dir, "snapshots.db")}
+}
+
+func (s *SnapshotStore) open() (*bolt.DB, error) {
+	if err := os.MkdirAll(path.Dir(s.file), 0700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	db, err := bolt.Open(s.file, 0600, &bolt.Options{Timeout: snapshotOpenTimeout})
+	return db, errors.Annotatef(err, "snapshot:%s", s.file)
+}
+
+func snapshotKey(t time.Time) []byte {
+	return []byte(t.UTC().Format(snapshotTimeFormat))
+}
+
+func (s *SnapshotStore) save(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	db, err := s.open()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer db.Close()
+	return errors.Trace(db.Update(func(tx *bolt.Tx) error {
+		team, err := tx.CreateBucketIfNotExists([]byte(snapshot.Team))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		kind, err := team.CreateBucketIfNotExists([]byte(snapshot.Kind))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(kind.Put(snapshotKey(snapshot.Time), data))
+	}))
+}
+
+// load returns the snapshots of the team and the kind taken since the time, in time order.
+func (s *SnapshotStore) load(team string, kind string, since time.Time) ([]*Snapshot, error) {
+	if _, err := os.Stat(s.file); os.IsNotExist(err) {
+		return nil, nil
+	}
+	db, err := s.open()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer db.Close()
+
+	var result []*Snapshot
+	err = db.View(func(tx *bolt.Tx) error {
+		teamBucket := tx.Bucket([]byte(team))
+		if teamBucket == nil {
+			return nil
+		}
+		kindBucket := teamBucket.Bucket([]byte(kind))
+		if kindBucket == nil {
+			return nil
+		}
+		c := kindBucket.Cursor()
+		for k, v := c.Seek(snapshotKey(since)); k != nil; k, v = c.Next() {
+			snapshot := &Snapshot{}
+			if err := json.Unmarshal(v, snapshot); err != nil {
+				return errors.Annotatef(err, "snapshot:%s/%s/%s", team, kind, k)
+			}
+			result = append(result, snapshot)
+		}
+		return nil
+	})
+	return result, errors.Trace(err)
+}
+
+// saveSnapshot saves the snapshot taken now, nothing is saved in the dry run.
+func saveSnapshot(snapshot *Snapshot) error {
+	if snapshots == nil || dryRun {
+		return nil
+	}
+	snapshot.Time = reportClock()
+	return errors.Trace(snapshots.save(snapshot))
+}
+
+// saveReportSnapshot saves the report of the team.
+func saveReportSnapshot(team *Team, report *Report) error {
+	return errors.Trace(saveSnapshot(&Snapshot{
+		Kind:    report.Kind,
+		Team:    team.Name,
+		Report:  report,
+		Metrics: newSnapshotMetrics(team, report, reportClock()),
+	}))
+}
+
+// saveDataSnapshot saves what a run of the team collects, like a TeamWeeklyReport.
+func saveDataSnapshot(team *Team, kind string, data interface{}, metrics map[string]map[string]int) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(saveSnapshot(&Snapshot{Kind: kind, Team: team.Name, Data: raw, Metrics: metrics}))
+}
+
+func newMetrics(kind string) map[string]map[string]int {
+	metrics := make(map[string]map[string]int)
+	for _, name := range snapshotKindMetrics[kind] {
+		metrics[name] = make(map[string]int)
+	}
+	return metrics
+}
+
+// newSnapshotMetrics counts the distinct items of the report, the Jira issues
+// by the assignee and the pull requests by the repository.
+func newSnapshotMetrics(team *Team, report *Report, now time.Time) map[string]map[string]int {
+	metrics := newMetrics(report.Kind)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	seen := make(map[string]bool)
+	for _, section := range report.Sections {
+		for _, item := range section.Items {
+			if seen[item.Link] {
+				continue
+			}
+			seen[item.Link] = true
+
+			if len(item.Key) > 0 {
+				assignee := "Unassigned"
+				if len(item.Assignees) > 0 {
+					assignee = teamMemberName(team, item.Assignees[0])
+				}
+				metrics[metricJiraIssues][assignee]++
+				if !item.Closed && item.DueDate != nil && item.DueDate.Before(today) {
+					metrics[metricOverdueIssues][assignee]++
+				}
+				continue
+			}
+
+			if !strings.Contains(item.Link, "/pull/") || item.Closed {
+				continue
+			}
+			metrics[metricOpenPRs][item.Repo]++
+			for _, tag := range item.Tags {
+				if tag == team.ExternalLabel {
+					metrics[metricOpenCommunityPRs][item.Repo]++
+				}
+			}
+		}
+	}
+	return metrics
+}
+
+// teamMemberName returns the member name of the person, or the person's name if
+// it's not a member.
+func teamMemberName(team *Team, p ReportPerson) string {
+	for _, member := range team.Members {
+		if (len(p.Email) > 0 && strings.EqualFold(member.Email, p.Email)) ||
+			(len(p.Login) > 0 && strings.EqualFold(member.Github, p.Login)) {
+			return member.Name
+		}
+	}
+	return personName(p)
+}
+
+// weeklySnapshotMetrics counts the issues of the rollup by member.
+func weeklySnapshotMetrics(rollup *TeamWeeklyReport) map[string]map[string]int {
+	metrics := newMetrics(snapshotKindWeeklyTeam)
+	for _, m := range rollup.Members {
+		metrics[metricDoneIssues][m.Name] = m.Done
+		metrics[metricInProgressIssues][m.Name] = m.InProgress
+		metrics[metricNextWeekIssues][m.Name] = m.NextWeek
+		metrics[metricOverdueIssues][m.Name] = m.Overdue
+	}
+	return metrics
+}
+
+// reviewSnapshotMetrics counts the pull requests and the median hours to the
+// first review by reviewer.
+func reviewSnapshotMetrics(review *ReviewMetrics) map[string]map[string]int {
+	metrics := newMetrics(snapshotKindReviewMetrics)
+	for _, l := range review.Reviewers {
+		metrics[metricReviewedPRs][l.Name] = l.PullRequests
+		if l.FirstReviewHours.Count > 0 {
+			metrics[metricFirstReviewHours][l.Name] = int(math.Round(l.FirstReviewHours.P50))
+		}
+	}
+	return metrics
+}
+
+// jiraSnapshotMetrics counts the resolved issues and the median days by member.
+func jiraSnapshotMetrics(jiraMetrics *JiraMetrics) map[string]map[string]int {
+	metrics := newMetrics(snapshotKindJiraMetrics)
+	for _, group := range jiraMetrics.GroupsBy("member") {
+		metrics[metricResolvedIssues][group.Name] = group.Issues
+		metrics[metricLeadDays][group.Name] = int(math.Round(group.LeadDays.P50))
+		if group.CycleDays.Count > 0 {
+			metrics[metricCycleDays][group.Name] = int(math.Round(group.CycleDays.P50))
+		}
+	}
+	return metrics
+}
+
+// timesheetSnapshotMetrics sums up the hours logged by member.
+func timesheetSnapshotMetrics(sheet *Timesheet) map[string]map[string]int {
+	metrics := newMetrics(snapshotKindTimesheet)
+	for _, m := range sheet.Members {
+		metrics[metricLoggedHours][m.Name] = int(math.Round(float64(m.Total) / 3600))
+	}
+	return metrics
+}
+
+var (
+	historyMetric string
+	historyKind   string
+	historyPeriod string
+	historySince  string
+)
+
+func newHistoryCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "history",
+		Short: "Compare The Saved Report Snapshots Over Time",
+		Run:   runHistoryCommandFunc,
+	}
+	m.Flags().StringVar(&historyMetric, "metric", metricOverdueIssues, "Metric, see the metrics of every kind in README")
+	m.Flags().StringVar(&historyKind, "kind", "daily", "Kind of the snapshots, one of "+strings.Join(snapshotKinds(), ","))
+	m.Flags().StringVar(&historyPeriod, "period", "week", "Period, one of day,week,month, the last snapshot of each period is shown")
+	m.Flags().StringVar(&historySince, "since", "", "Start date like 2006-01-02, default 8 periods ago")
+	return m
+}
+
+func snapshotKinds() []string {
+	kinds := make([]string, 0, len(snapshotKindMetrics))
+	for kind := range snapshotKindMetrics {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// periodStart returns the first day of the period containing t, the weeks start on Monday.
+func periodStart(period string, t time.Time) (time.Time, error) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case "day":
+		return day, nil
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day()), nil
+	}
+	return day, errors.NotSupportedf("period %s", period)
+}
+
+func runHistoryCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("history")
+
+	metrics, ok := snapshotKindMetrics[historyKind]
+	if !ok {
+		summary.Record("kind", errors.NotSupportedf("kind %s, use one of %s", historyKind, strings.Join(snapshotKinds(), ",")))
+		summary.Exit()
+	}
+	if !containsString(metrics, historyMetric) {
+		summary.Record("metric", errors.NotSupportedf("metric %s of %s, use one of %s", historyMetric, historyKind, strings.Join(metrics, ",")))
+		summary.Exit()
+	}
+
+	now := reportClock()
+	since, err := periodStart(historyPeriod, now)
+	if !summary.Record("period", err) {
+		switch {
+		case len(historySince) > 0:
+			since, err = time.ParseInLocation(dayFormat, historySince, now.Location())
+			summary.Record("since", errors.Annotatef(err, "since:%s", historySince))
+		case historyPeriod == "day":
+			since = since.AddDate(0, 0, -7)
+		case historyPeriod == "week":
+			since = since.AddDate(0, 0, -7*7)
+		default:
+			since = since.AddDate(0, -7, 0)
+		}
+	}
+	if summary.Failed() {
+		summary.Exit()
+	}
+
+	for _, team := range teams {
+		history, err := snapshots.load(team.Name, historyKind, since)
+		if summary.Record(team.Name, err) {
+			continue
+		}
+		fmt.Printf("%s %s of %s, by %s\n", team.Name, historyMetric, historyKind, historyPeriod)
+		if len(history) == 0 {
+			fmt.Printf("no snapshots since %s\n\n", since.Format(dayFormat))
+			continue
+		}
+		printHistory(history)
+		fmt.Println()
+	}
+	summary.Exit()
+}
+
+// printHistory prints a table of the metric, one column per period.
+func printHistory(history []*Snapshot) {
+	var periods []string
+	latest := make(map[string]map[string]int)
+	keys := make(map[string]bool)
+	for _, snapshot := range history {
+		start, _ := periodStart(historyPeriod, snapshot.Time.In(reportClock().Location()))
+		period := start.Format(dayFormat)
+		if _, ok := latest[period]; !ok {
+			periods = append(periods, period)
+		}
+		// The snapshots are in time order, so the last one of the period wins.
+		latest[period] = snapshot.Metrics[historyMetric]
+		for key := range latest[period] {
+			keys[key] = true
+		}
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "\t%s\t\n", strings.Join(periods, "\t"))
+	totals := make([]int, len(periods))
+	for _, name := range names {
+		row := make([]string, 0, len(periods))
+		for idx, period := range periods {
+			row = append(row, fmt.Sprint(latest[period][name]))
+			totals[idx] += latest[period][name]
+		}
+		fmt.Fprintf(w, "%s\t%s\t\n", name, strings.Join(row, "\t"))
+	}
+	row := make([]string, 0, len(totals))
+	for _, total := range totals {
+		row = append(row, fmt.Sprint(total))
+	}
+	fmt.Fprintf(w, "Total\t%s\t\n", strings.Join(row, "\t"))
+	w.Flush()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	store := newSnapshotStore(t.TempDir())
+	if history, err := store.load("Team", "daily", time.Time{}); err != nil || len(history) != 0 {
+		t.Fatalf("load before any save %v %v", history, err)
+	}
+
+	// The snapshots are saved out of order, in several time zones.
+	shanghai := time.FixedZone("CST", 8*3600)
+	for _, s := range []*Snapshot{
+		{Time: testNow, Kind: "daily", Team: "Team"},
+		{Time: testNow.AddDate(0, 0, -14).In(shanghai), Kind: "daily", Team: "Team"},
+		{Time: testNow.AddDate(0, 0, -7), Kind: "daily", Team: "Team"},
+		{Time: testNow.AddDate(0, 0, -7), Kind: snapshotKindWeeklyTeam, Team: "Team", Data: json.RawMessage(`{"Team":"Team"}`)},
+		{Time: testNow.AddDate(0, 0, -7), Kind: "daily", Team: "Other"},
+	} {
+		if err := store.save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		team  string
+		kind  string
+		since time.Time
+		// days are the days of the snapshots before testNow, in order.
+		days string
+	}{
+		{name: "all", team: "Team", kind: "daily", days: "14,7,0"},
+		{name: "since", team: "Team", kind: "daily", since: testNow.AddDate(0, 0, -7), days: "7,0"},
+		{name: "since in another zone", team: "Team", kind: "daily", since: testNow.AddDate(0, 0, -7).Add(time.Second).In(shanghai), days: "0"},
+		{name: "kind", team: "Team", kind: snapshotKindWeeklyTeam, days: "7"},
+		{name: "team", team: "Other", kind: "daily", days: "7"},
+		{name: "no team", team: "Nobody", kind: "daily"},
+		{name: "no kind", team: "Other", kind: snapshotKindTimesheet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := store.load(tt.team, tt.kind, tt.since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var days []string
+			for _, s := range history {
+				if s.Team != tt.team || s.Kind != tt.kind {
+					t.Errorf("loaded %s %s", s.Team, s.Kind)
+				}
+				days = append(days, fmt.Sprint(int(testNow.Sub(s.Time).Hours()/24)))
+			}
+			if strings.Join(days, ",") != tt.days {
+				t.Errorf("days %v, want %s", days, tt.days)
+			}
+		})
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		period  string
+		t       time.Time
+		want    string
+		wantErr bool
+	}{
+		{period: "day", t: testNow, want: "2026-10-16"},
+		{period: "week", t: testNow, want: "2026-10-12"},
+		// A Sunday belongs to the week started on Monday.
+		{period: "week", t: time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC), want: "2026-10-12"},
+		{period: "week", t: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), want: "2026-10-19"},
+		{period: "month", t: testNow, want: "2026-10-01"},
+		{period: "year", t: testNow, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period+" "+tt.want, func(t *testing.T) {
+			start, err := periodStart(tt.period, tt.t)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v", err)
+			}
+			if !tt.wantErr && start.Format(dayFormat) != tt.want {
+				t.Errorf("start %s, want %s", start.Format(dayFormat), tt.want)
+			}
+		})
+	}
+}
+
+func TestRunHistoryCommand(t *testing.T) {
+	setupFakes(t)
+	snapshots = newSnapshotStore(t.TempDir())
+	defer func(metric, kind, period, since string) {
+		historyMetric, historyKind, historyPeriod, historySince = metric, kind, period, since
+	}(historyMetric, historyKind, historyPeriod, historySince)
+	historyMetric, historyKind, historyPeriod, historySince = metricLoggedHours, snapshotKindTimesheet, "week", "2026-10-01"
+
+	for _, s := range []struct {
+		days  int
+		alice int
+	}{{days: 28, alice: 1}, {days: 9, alice: 2}, {days: 8, alice: 3}, {days: 1, alice: 4}} {
+		metrics := newMetrics(snapshotKindTimesheet)
+		metrics[metricLoggedHours]["Alice"] = s.alice
+		metrics[metricLoggedHours]["Bob"] = 1
+		err := snapshots.save(&Snapshot{Time: testNow.AddDate(0, 0, -s.days), Kind: snapshotKindTimesheet, Team: "Team", Metrics: metrics})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := captureStdout(t, func() {
+		runHistoryCommandFunc(nil, nil)
+	})
+	// The snapshot before --since is skipped, and the last snapshot of a week wins.
+	want := []string{
+		"Team logged-hours of timesheet, by week",
+		"  2026-10-05  2026-10-12",
+		"Alice           3           4",
+		"  Bob           1           1",
+		"Total           4           5",
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("output:\n%s", output)
+	}
+	for idx, line := range lines {
+		if strings.TrimSpace(line) != strings.TrimSpace(want[idx]) {
+			t.Errorf("line %d %q, want %q", idx, line, want[idx])
+		}
+	}
+}
+
+func TestRunSavesSnapshot(t *testing.T) {
+	for _, dry := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry run %v", dry), func(t *testing.T) {
+			_, jr, _, _ := setupFakes(t)
+			snapshots = newSnapshotStore(t.TempDir())
+			dryRun = dry
+			issue := testJiraIssue("TIKV-1", "Support TTL", "alice@example.com", "In Progress", "indeterminate")
+			jr.Issues[`worklogAuthor in ("alice@example.com","bob@example.com") AND worklogDate >= "2026-10-10" AND worklogDate < "2026-10-17" ORDER BY key`] = []jira.Issue{issue}
+			started := jira.Time(testNow.Add(-time.Hour))
+			jr.Worklogs["TIKV-1"] = []jira.WorklogRecord{{
+				Author:           &jira.User{EmailAddress: "alice@example.com"},
+				Started:          &started,
+				TimeSpentSeconds: 7200,
+			}}
+
+			captureStdout(t, func() {
+				runMetricsTimesheetCommandFunc(nil, nil)
+			})
+			history, err := snapshots.load("Team", snapshotKindTimesheet, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dry {
+				if len(history) > 0 {
+					t.Errorf("saved %d snapshots in the dry run", len(history))
+				}
+				return
+			}
+			if len(history) != 1 {
+				t.Fatalf("saved %d snapshots", len(history))
+			}
+			s := history[0]
+			if !s.Time.Equal(testNow) || s.Metrics[metricLoggedHours]["Alice"] != 2 {
+				t.Errorf("snapshot %+v", s)
+			}
+			var sheet Timesheet
+			if err = json.Unmarshal(s.Data, &sheet); err != nil || len(sheet.Issues) != 1 || sheet.Issues[0].Key != "TIKV-1" {
+				t.Errorf("snapshot data %+v %v", sheet, err)
+			}
+		})
+	}
+}
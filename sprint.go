@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/juju/errors"
+)
+
+const (
+	defaultSprintDays = 7
+	defaultSprintName = "{start} - {end}"
+	// The active sprint is rotated only if it ends within sprintRotateAhead,
+	// so the rotation can run a little early and a re-run after a successful
+	// rotation does nothing.
+	sprintRotateAhead = 24 * time.Hour
+)
+
+func (s *Sprint) adjust() error {
+	if s.Days <= 0 {
+		s.Days = defaultSprintDays
+	}
+	if len(s.Name) == 0 {
+		s.Name = defaultSprintName
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return errors.Annotatef(err, "sprint timezone:%s", s.Timezone)
+	}
+	return nil
+}
+
+// location returns the timezone of the sprint, an empty timezone means UTC.
+func (s *Sprint) location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// sprintName names the sprint by the first and the last day of it.
+func (s *Sprint) sprintName(startDate, endDate time.Time) string {
+	r := strings.NewReplacer(
+		"{start}", startDate.Format(dayFormat),
+		"{end}", endDate.Add(-time.Second).Format(dayFormat),
+	)
+	return r.Replace(s.Name)
+}
+
+type SprintRotation struct {
+	Closed jira.Sprint
+	Next   jira.Sprint
+	// CarriedOver are the unfinished issues moved to the next sprint in this run.
+	CarriedOver []jira.Issue
+}
+
+// rotateSprint closes the active sprint, moves the unfinished issues to the next
+// sprint and starts the next sprint. It's safe to re-run after a partial failure,
+// the finished steps are skipped. It returns nil if there is nothing to rotate.
+func rotateSprint(now time.Time) (*SprintRotation, error) {
+	boardID, err := getBoardID(config.Jira.Project, "scrum", config.Sprint.Board)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	activeSprints, err := getSprints(boardID, "active")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var current jira.Sprint
+	alreadyClosed := false
+	switch len(activeSprints) {
+	case 0:
+		// The last run may fail after the active sprint is closed.
+		closed, err := getLatestClosedSprint(boardID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if closed == nil {
+			return nil, errors.NotFoundf("active or closed sprint of board %d", boardID)
+		}
+		current = *closed
+		alreadyClosed = true
+	case 1:
+		current = activeSprints[0]
+	default:
+		return nil, errors.Errorf("board %d has %d active sprints", boardID, len(activeSprints))
+	}
+
+	if current.EndDate == nil {
+		return nil, errors.Errorf("sprint %s has no end date", current.Name)
+	}
+	if !alreadyClosed && current.EndDate.After(now.Add(sprintRotateAhead)) {
+		return nil, nil
+	}
+
+	// Don't create a sprint in the past if the sprint is rotated late, e.g. the
+	// board has no active sprint since the last closed one.
+	startDate := *current.EndDate
+	loc := config.Sprint.location()
+	today := now.In(loc)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	if startDate.Before(today) {
+		startDate = today
+	}
+	nextSprint, err := createNextSprint(boardID, startDate)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// The issues already moved by the last run are excluded.
+	pendingIssues, err := queryJiraIssues(
+		fmt.Sprintf("project = %s and Sprint = %d and Sprint not in (%d) and statusCategory != Done",
+			config.Jira.Project, current.ID, nextSprint.ID,
+		))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// Move issues before closing the sprint, so a failure never leaves them in a closed sprint.
+	if err = moveIssuesToSprint(nextSprint.ID, pendingIssues); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !alreadyClosed {
+		if _, err = updateSprintState(current.ID, "closed"); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if nextSprint.State != "active" {
+		if _, err = updateSprintState(nextSprint.ID, "active"); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	return &SprintRotation{
+		Closed:      current,
+		Next:        nextSprint,
+		CarriedOver: pendingIssues,
+	}, nil
+}
+
+func newSprintRotationReport(rotation *SprintRotation) *Report {
+	report := &Report{Kind: "sprint-rotation", Title: "Sprint Rotation"}
+	report.AddSection(
+		fmt.Sprintf("Sprint %s is closed, sprint %s is started", rotation.Closed.Name, rotation.Next.Name),
+		fmt.Sprintf("%d issues are carried over", len(rotation.CarriedOver)),
+		newJiraReportItems(rotation.CarriedOver),
+	)
+	return report
+}
+
+// publishSprintBurndowns creates a page of the sprint's burndown chart for every
+// team under its dead-line path, the failed teams are recorded to summary and skipped.
+func publishSprintBurndowns(sprint jira.Sprint, summary *RunSummary) []ReportItem {
+	var items []ReportItem
+	for _, team := range teams {
+		title := fmt.Sprintf("Sprint %s %s Burndown", sprint.Name, team.Name)
+		c, err := publishSprintBurndown(team, sprint, title)
+		if !summary.Record(title, err) && c.Id != "" {
+			items = append(items, ReportItem{Title: title, Link: config.Confluence.Endpoint + c.Links.WebUI})
+		}
+	}
+	return items
+}
+
+func publishSprintBurndown(team *Team, sprint jira.Sprint, title string) (Content, error) {
+	chart, err := newSprintBurndownChart(team, sprint, reportClock())
+	if err != nil || chart == nil {
+		return Content{}, errors.Trace(err)
+	}
+	var body bytes.Buffer
+	formatPageBeginForHtmlOutput(&body)
+	genWeeklyReportCharts(&body, []*Chart{chart})
+	formatPageEndForHtmlOutput(&body)
+	c, err := createWeeklyDueDateReport(team, title, body.String())
+	if err != nil {
+		return Content{}, errors.Trace(err)
+	}
+	return c, errors.Trace(uploadCharts(c.Id, []*Chart{chart}))
+}
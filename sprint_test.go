@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+func testSprint(id int, state string, start time.Time, days int) jira.Sprint {
+	end := start.AddDate(0, 0, days)
+	return jira.Sprint{
+		ID:            id,
+		Name:          config.Sprint.sprintName(start, end),
+		State:         state,
+		OriginBoardID: 1,
+		StartDate:     &start,
+		EndDate:       &end,
+	}
+}
+
+func TestRunRotateSprintCommand(t *testing.T) {
+	today := time.Date(testNow.Year(), testNow.Month(), testNow.Day(), 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// sprints are the sprints of the board before the rotation.
+		sprints func() []jira.Sprint
+		// moved are the issues already in the next sprint.
+		moved []string
+		// wantSprints are the "name state" of the sprints after the rotation.
+		wantSprints []string
+		wantIssues  []string
+		// posted is whether the carried-over issues are posted to Slack.
+		posted bool
+	}{
+		{
+			name: "rotate",
+			sprints: func() []jira.Sprint {
+				return []jira.Sprint{testSprint(1, "active", today.AddDate(0, 0, -7), 7)}
+			},
+			wantSprints: []string{"2026-10-09 - 2026-10-15 closed", "2026-10-16 - 2026-10-22 active"},
+			wantIssues:  []string{"TIKV-1", "TIKV-2"},
+			posted:      true,
+		},
+		{
+			name: "not end yet",
+			sprints: func() []jira.Sprint {
+				return []jira.Sprint{testSprint(1, "active", today.AddDate(0, 0, -2), 7)}
+			},
+			wantSprints: []string{"2026-10-14 - 2026-10-20 active"},
+		},
+		{
+			name: "closed long ago",
+			sprints: func() []jira.Sprint {
+				return []jira.Sprint{testSprint(1, "closed", today.AddDate(0, 0, -30), 7)}
+			},
+			wantSprints: []string{"2026-09-16 - 2026-09-22 closed", "2026-10-16 - 2026-10-22 active"},
+			wantIssues:  []string{"TIKV-1", "TIKV-2"},
+			posted:      true,
+		},
+		{
+			name: "re-run after closing",
+			sprints: func() []jira.Sprint {
+				return []jira.Sprint{
+					testSprint(1, "closed", today.AddDate(0, 0, -7), 7),
+					testSprint(2, "future", today, 7),
+				}
+			},
+			moved:       []string{"TIKV-1"},
+			wantSprints: []string{"2026-10-09 - 2026-10-15 closed", "2026-10-16 - 2026-10-22 active"},
+			wantIssues:  []string{"TIKV-1", "TIKV-2"},
+			posted:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, jr, _, sl := setupFakes(t)
+			jr.Boards = []jira.Board{{ID: 1, Name: "TiKV Board", Type: "scrum"}}
+			jr.Sprints[1] = tt.sprints()
+			current := jr.Sprints[1][0].ID
+			next := jr.nextID + 1
+			if len(jr.Sprints[1]) > 1 {
+				next = jr.Sprints[1][1].ID
+				jr.SprintIssues[next] = tt.moved
+			}
+			pending := []jira.Issue{testJiraIssue("TIKV-2", "Docs", "bob@example.com", "In Progress", "indeterminate")}
+			if len(tt.moved) == 0 {
+				pending = append([]jira.Issue{testJiraIssue("TIKV-1", "TTL", "alice@example.com", "To Do", "new")}, pending...)
+			}
+			jr.Issues[fmt.Sprintf("project = TIKV and Sprint = %d and Sprint not in (%d) and statusCategory != Done", current, next)] = pending
+
+			runRotateSprintCommandFunc(nil, nil)
+
+			var sprints []string
+			for _, sprint := range jr.Sprints[1] {
+				sprints = append(sprints, sprint.Name+" "+sprint.State)
+			}
+			if strings.Join(sprints, ",") != strings.Join(tt.wantSprints, ",") {
+				t.Errorf("sprints %q, want %q", sprints, tt.wantSprints)
+			}
+			if got := strings.Join(jr.SprintIssues[next], ","); got != strings.Join(tt.wantIssues, ",") {
+				t.Errorf("issues of the next sprint %q, want %q", got, tt.wantIssues)
+			}
+			if !tt.posted {
+				if len(sl.Messages) > 0 {
+					t.Errorf("posted %d messages", len(sl.Messages))
+				}
+				return
+			}
+			if len(sl.Messages) != 1 || sl.Messages[0].Channel != "#team-channel" {
+				t.Fatalf("posted %+v", sl.Messages)
+			}
+			text := sl.Messages[0].Text
+			for _, want := range append([]string{"Sprint Rotation", fmt.Sprintf("%d issues are carried over", len(pending))}, keys(pending)...) {
+				if !strings.Contains(text, want) {
+					t.Errorf("message doesn't contain %q:\n%s", want, text)
+				}
+			}
+		})
+	}
+}
+
+func keys(issues []jira.Issue) []string {
+	var keys []string
+	for _, issue := range issues {
+		keys = append(keys, issue.Key)
+	}
+	return keys
+}
+
+func TestRunRotateSprintCommandBurndown(t *testing.T) {
+	_, jr, cf, sl := setupFakes(t)
+	config.Confluence.Charts = true
+	config.Confluence.Endpoint = "https://wiki.example.com"
+	today := time.Date(testNow.Year(), testNow.Month(), testNow.Day(), 0, 0, 0, 0, time.UTC)
+	jr.Boards = []jira.Board{{ID: 1, Name: "TiKV Board", Type: "scrum"}}
+	sprint := testSprint(1, "active", today.AddDate(0, 0, -7), 7)
+	jr.Sprints[1] = []jira.Sprint{sprint}
+	done := testJiraIssue("TIKV-1", "TTL", "alice@example.com", "Done", "done")
+	done.Fields.Resolutiondate = jira.Time(today.AddDate(0, 0, -3))
+	pending := testJiraIssue("TIKV-2", "Docs", "bob@example.com", "In Progress", "indeterminate")
+	jr.Issues[fmt.Sprintf("project = TIKV and Sprint = 1 and Sprint not in (%d) and statusCategory != Done", jr.nextID+1)] = []jira.Issue{pending}
+	jr.Issues[`Sprint = 1 AND assignee in ("alice@example.com","bob@example.com")`] = []jira.Issue{done, pending}
+	parent := &Content{Type: "page", Title: "Weekly Due Dates"}
+	parent.Space.Key = "TT"
+	if _, err := cf.CreateContent(parent); err != nil {
+		t.Fatal(err)
+	}
+
+	runRotateSprintCommandFunc(nil, nil)
+
+	title := "Sprint " + sprint.Name + " Team Burndown"
+	c, err := cf.GetContentByTitle("TT", title)
+	if err != nil || c.Id == "" {
+		t.Fatalf("page %q %+v %v", title, c, err)
+	}
+	attachments := cf.Attachments[c.Id]
+	if len(attachments) != 1 || attachments[0].Title != "chart-burndown.svg" {
+		t.Fatalf("attachments %+v", attachments)
+	}
+	if svg := string(cf.AttachmentData[attachments[0].Id]); !strings.Contains(svg, "Burndown of sprint "+sprint.Name) {
+		t.Errorf("chart:\n%s", svg)
+	}
+	if len(sl.Messages) != 1 {
+		t.Fatalf("posted %+v", sl.Messages)
+	}
+	for _, want := range []string{"Burndown", title, "https://wiki.example.com" + c.Links.WebUI} {
+		if !strings.Contains(sl.Messages[0].Text, want) {
+			t.Errorf("message doesn't contain %q:\n%s", want, sl.Messages[0].Text)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ngaut/log"
+)
+
+type runFailure struct {
+	target string
+	err    error
+}
+
+// RunSummary collects the failures of a command run, so one failed member or
+// section doesn't stop the whole run.
+type RunSummary struct {
+	command  string
+	failures []runFailure
+}
+
+func newRunSummary(command string) *RunSummary {
+	return &RunSummary{command: command}
+}
+
+// Record records err for target and returns whether err is not nil.
+func (s *RunSummary) Record(target string, err error) bool {
+	if err == nil {
+		return false
+	}
+	log.Errorf("%s: %s failed: %v", s.command, target, err)
+	s.failures = append(s.failures, runFailure{target: target, err: err})
+	return true
+}
+
+func (s *RunSummary) Failed() bool {
+	return len(s.failures) > 0
+}
+
+func (s *RunSummary) String() string {
+	if !s.Failed() {
+		return fmt.Sprintf("%s: finished without failures", s.command)
+	}
+	msg := fmt.Sprintf("%s: %d failures", s.command, len(s.failures))
+	for _, f := range s.failures {
+		msg += fmt.Sprintf("\n  - %s: %v", f.target, f.err)
+	}
+	return msg
+}
+
+// Exit prints the summary and exits with a non-zero code only when
+// something failed.
+func (s *RunSummary) Exit() {
+	if !s.Failed() {
+		return
+	}
+	fmt.Fprintln(os.Stderr, s.String())
+	os.Exit(1)
+}
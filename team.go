@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+const defaultExternalLabel = "Community"
+
+// IsMember checks whether the GitHub login belongs to the team.
+func (t *Team) IsMember(login string) bool {
+	for _, member := range t.Members {
+		if strings.EqualFold(member.Github, login) {
+			return true
+		}
+	}
+	return false
+}
+
+// GithubIDs returns the GitHub logins of all the members.
+func (t *Team) GithubIDs() []string {
+	ids := make([]string, 0, len(t.Members))
+	for _, member := range t.Members {
+		ids = append(ids, member.Github)
+	}
+	return ids
+}
+
+// QuotedEmails returns the quoted emails of all the members, which can be used in JQL directly.
+func (t *Team) QuotedEmails() []string {
+	emails := make([]string, 0, len(t.Members))
+	for _, member := range t.Members {
+		emails = append(emails, strconv.Quote(member.Email))
+	}
+	return emails
+}
+
+// datePageTitle returns the title of the team's weekly date page. The titles are unique
+// in a space, so each team has its own date page if there are several teams, a single
+// team keeps the bare date like before the teams were configurable.
+func (t *Team) datePageTitle(date string) string {
+	if len(config.Teams) <= 1 {
+		return date
+	}
+	return t.Name + " " + date
+}
+
+func (t *Team) adjust() {
+	if len(t.SlackChannel) == 0 {
+		t.SlackChannel = config.Slack.Channel
+	}
+	if len(t.WeeklyPath) == 0 {
+		t.WeeklyPath = config.Confluence.WeeklyPath
+	}
+	if len(t.WeeklyDueDatePath) == 0 {
+		t.WeeklyDueDatePath = config.Confluence.WeeklyDueDatePath
+	}
+	if len(t.ExternalLabel) == 0 {
+		t.ExternalLabel = defaultExternalLabel
+	}
+}
+
+// selectTeams returns the team specified by --team, or all the teams if not specified.
+func selectTeams(name string) ([]*Team, error) {
+	teams := make([]*Team, 0, len(config.Teams))
+	for idx := range config.Teams {
+		team := &config.Teams[idx]
+		if len(name) == 0 || strings.EqualFold(team.Name, name) {
+			teams = append(teams, team)
+		}
+	}
+	if len(teams) == 0 {
+		return nil, errors.NotFoundf("team %q in config", name)
+	}
+	return teams, nil
+}
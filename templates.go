@@ -0,0 +1,243 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/nlopes/slack/slackutilsx"
+)
+
+// The reports are rendered by the text/template templates below. Each of them
+// can be overridden by a file of the same name in the "templates" directory
+// next to the config file, e.g. ~/.work-reporter/templates/daily.slack.tmpl.
+//
+// Templates and their data:
+//
+//	<kind>.slack.tmpl, report.slack.tmpl                *Report, for Slack
+//	<kind>.confluence.tmpl, report.confluence.tmpl      *Report, for Confluence
+//	weekly-personal.confluence.tmpl                     *PersonalWeeklyReport
+//	weekly-team.confluence.tmpl                         *TeamWeeklyReport
+//	review-metrics.confluence.tmpl                      *ReviewMetrics
+//	jira-metrics.confluence.tmpl                        *JiraMetrics
+//	timesheet.confluence.tmpl                           *Timesheet
+//	release-notes.confluence.tmpl                       *ReleaseNotes
+//
+// The kind of a report is "daily", "daily-digest" or "sprint-rotation", report.*.tmpl is used
+// if there is no template for the kind. The Confluence report template must
+// define the "section" and "items" templates too.
+//
+// An existing weekly-personal, weekly-team, release, jira-metrics or timesheet page is updated only
+// between the regionBegin and regionEnd of the same name, the notes written by hand
+// elsewhere are kept.
+//
+// Helper functions:
+//
+//	slackEscape TEXT         escapes the text for Slack
+//	slackMention EMAIL       mentions the Slack user of the email
+//	slackItem ITEM           formats a ReportItem for Slack
+//	jiraMacro KEY            the Confluence Jira macro of the issue
+//	statusLozenge NAME COLOR the Confluence status lozenge, COLOR is Grey, Red, Yellow, Green or Blue
+//	confluenceItem ITEM      formats a ReportItem for Confluence
+//	pageLink TITLE           links to the Confluence page of the title
+//	date TIME                formats the time as 2006-01-02
+//	workTime SECONDS         formats the seconds like Jira time tracking, e.g. 1d 2h
+//	regionBegin NAME         begins a generated region of the page
+//	regionEnd NAME           ends the generated region of the page
+//	html TEXT                escapes the text for Confluence (built-in)
+
+var templateFuncs = template.FuncMap{
+	"slackEscape":    slackutilsx.EscapeMessage,
+	"slackMention":   buildSlackMention,
+	"slackItem":      slackRenderer{}.formatItem,
+	"jiraMacro":      jiraMacro,
+	"statusLozenge":  formatLabelForHtmlOutput,
+	"confluenceItem": confluenceItem,
+	"pageLink":       confluencePageLink,
+	"date": func(t time.Time) string {
+		return t.Format(dayFormat)
+	},
+	"workTime":    formatWorkTime,
+	"regionBegin": regionBegin,
+	"regionEnd":   regionEnd,
+}
+
+const defaultSlackReportTemplate = `*{{slackEscape .Title}}*{{with .Team}} ({{slackEscape .}}){{end}}
+
+{{range .Sections}}*{{slackEscape .Title}}*
+{{with .Description}}> {{slackEscape .}}
+{{end}}{{range .Items}}• {{slackItem .}}
+{{else}}_None_
+{{end}}
+{{end}}`
+
+const defaultConfluenceReportTemplate = `{{define "items"}}{{if .}}<ul>{{range .}}<li>{{confluenceItem .}}</li>
+{{end}}</ul>{{else}}<p><i>None</i></p>
+{{end}}{{end}}
+{{- define "section"}}<ac:layout-section ac:type="single"><ac:layout-cell><hr/>
+
+<h1>{{html .Title}}</h1>
+{{with .Description}}
+<blockquote>{{html .}}</blockquote>
+{{end}}{{template "items" .Items}}</ac:layout-cell></ac:layout-section>
+{{end}}
+{{- "<ac:layout>"}}{{range .Sections}}{{template "section" .}}{{end}}</ac:layout>`
+
+const defaultPersonalWeeklyTemplate = `{{define "issues"}}{{if .}}<ul>{{range .}}<li>{{jiraMacro .Key}}{{with .Progress}} : {{html .}}{{end}}{{template "issues" .Children}}</li>{{end}}</ul>{{end}}{{end}}
+{{- regionBegin "works"}}<h2>Works of this week</h2>
+{{- range .Works}}<h3>{{html .Type}}</h3>{{template "issues" .Issues}}<br/>{{end}}{{regionEnd "works"}}
+{{- regionBegin "next-week"}}<h2>Next week plans</h2>
+{{- template "issues" .NextWeek}}{{regionEnd "next-week"}}`
+
+const defaultTeamWeeklyTemplate = `{{define "counts"}}<td>{{.Done}}</td><td>{{.InProgress}}</td><td>{{.NextWeek}}</td><td>{{if .Overdue}}{{statusLozenge (printf "%d" .Overdue) "Red"}}{{else}}0{{end}}</td>{{end}}
+{{- regionBegin "summary"}}<h1>{{html .Team}} {{html .Date}}</h1>
+<table><tbody><tr><th>Member</th><th>Done</th><th>In Progress</th><th>Next Week</th><th>Overdue</th></tr>
+{{range .Members}}<tr><td>{{pageLink .Page}}</td>{{template "counts" .}}</tr>
+{{end}}{{with .Total}}<tr><th>{{.Name}}</th>{{template "counts" .}}</tr>
+{{end}}</tbody></table>{{regionEnd "summary"}}
+{{- regionBegin "types"}}<h2>Issue Types</h2>
+{{if .Types}}<table><tbody><tr><th>Type</th><th>Done</th><th>In Progress</th></tr>
+{{range .Types}}<tr><td>{{html .Type}}</td><td>{{.Done}}</td><td>{{.InProgress}}</td></tr>
+{{end}}</tbody></table>{{else}}<p><i>None</i></p>{{end}}{{regionEnd "types"}}
+{{- regionBegin "overdue"}}<h2>Overdue Issues</h2>
+{{if .Overdue}}<table><tbody><tr><th>Issue</th><th>Assignee</th><th>Due Date</th><th>Overdue</th></tr>
+{{range .Overdue}}<tr><td>{{jiraMacro .Key}}</td><td>{{html .Assignee}}</td><td>{{date .DueDate}}</td><td>{{statusLozenge (printf "%d days" .Days) "Red"}}</td></tr>
+{{end}}</tbody></table>{{else}}<p><i>None</i></p>{{end}}{{regionEnd "overdue"}}
+{{- with .ReviewLatency}}{{regionBegin "review-latency"}}<h2>Review Latency</h2>
+<blockquote>Pull requests created in {{.Start}} ~ {{.End}}, p50 / p90 hours since ready for review</blockquote>
+<table><tbody><tr><th>Reviewer</th><th>PRs</th><th>First Review</th><th>Approval</th><th>Rounds</th></tr>
+{{range .Reviewers}}<tr><td>{{html .Name}}</td><td>{{.PullRequests}}</td><td>{{.FirstReviewHours}}</td><td>{{.ApprovalHours}}</td><td>{{.Rounds}}</td></tr>
+{{else}}<tr><td colspan="5"><i>None</i></td></tr>
+{{end}}</tbody></table>{{regionEnd "review-latency"}}{{end}}`
+
+const defaultReviewMetricsTemplate = `{{define "latencies"}}<table><tbody><tr><th>Name</th><th>PRs</th><th>First Review</th><th>Approval</th><th>Merge</th><th>Rounds</th></tr>
+{{range .}}<tr><td>{{html .Name}}</td><td>{{.PullRequests}}</td><td>{{.FirstReviewHours}}</td><td>{{.ApprovalHours}}</td><td>{{.MergeHours}}</td><td>{{.Rounds}}</td></tr>
+{{end}}</tbody></table>{{end}}
+{{- "<h1>Pull Request Review Latency</h1>"}}
+<blockquote>Pull requests created in {{.Start}} ~ {{.End}}, p50 / p90 hours since ready for review, merge hours since created</blockquote>
+<h2>Repositories</h2>
+{{template "latencies" .Repos}}
+<h2>Reviewers</h2>
+{{template "latencies" .Reviewers}}`
+
+const defaultJiraMetricsTemplate = `{{regionBegin "jira-metrics"}}<h1>Jira Lead Time And Cycle Time</h1>
+<blockquote>Issues resolved in {{.Start}} ~ {{.End}}, p50 / p90 days, and the average days in every status</blockquote>
+{{range $by := .Groupings}}<h2>By {{$by}}</h2>
+<table><tbody><tr><th>{{$by}}</th><th>Issues</th><th>Lead Time</th><th>Cycle Time</th>{{range $.Statuses}}<th>{{html .}}</th>{{end}}</tr>
+{{range $.GroupsBy $by}}{{$group := .}}<tr><td>{{html .Name}}</td><td>{{.Issues}}</td><td>{{.LeadDays}}</td><td>{{.CycleDays}}</td>{{range $.Statuses}}<td>{{printf "%.1f" (index $group.StatusDays .)}}</td>{{end}}</tr>
+{{end}}</tbody></table>
+{{end}}{{regionEnd "jira-metrics"}}`
+
+const defaultTimesheetTemplate = `{{regionBegin "timesheet"}}<h1>Timesheet</h1>
+<blockquote>Time logged in {{.Start}} ~ {{.End}}</blockquote>
+<table><tbody><tr><th>Member</th>{{range .Days}}<th>{{.}}</th>{{end}}<th>Total</th></tr>
+{{range .Members}}<tr><td>{{html .Name}}</td>{{range .Days}}<td>{{workTime .}}</td>{{end}}<td>{{workTime .Total}}</td></tr>
+{{end}}</tbody></table>
+{{range .Members}}<h2>{{html .Name}}</h2>
+{{if .Issues}}<table><tbody><tr><th>Epic</th><th>Logged</th></tr>
+{{range .Epics}}<tr><td>{{html .Key}}</td><td>{{workTime .Logged}}</td></tr>
+{{end}}</tbody></table>
+<table><tbody><tr><th>Issue</th><th>Logged</th></tr>
+{{range .Issues}}<tr><td>{{jiraMacro .Key}}</td><td>{{workTime .Logged}}</td></tr>
+{{end}}</tbody></table>
+{{else}}<p><i>None</i></p>
+{{end}}{{end}}<h2>Estimates</h2>
+<table><tbody><tr><th>Issue</th><th>Epic</th><th>Logged</th><th>Spent</th><th>Original Estimate</th><th>Remaining Estimate</th><th>Over Estimate</th></tr>
+{{range .Issues}}<tr><td>{{jiraMacro .Key}}</td><td>{{html .Epic}}</td><td>{{workTime .Logged}}</td><td>{{workTime .Spent}}</td><td>{{workTime .OriginalEstimate}}</td><td>{{workTime .RemainingEstimate}}</td><td>{{if .Estimated}}{{workTime .Over}}{{else}}-{{end}}</td></tr>
+{{end}}</tbody></table>{{regionEnd "timesheet"}}`
+
+const defaultReleaseNotesTemplate = `{{regionBegin "release-notes"}}<h1>Release Notes {{html .Version}}</h1>
+{{range .Groups}}<h2>{{with .Label}}{{html .}}{{else}}Others{{end}}</h2>
+<ul>{{range .Notes}}<li>{{html .Note}} (<a href="{{html .URL}}">{{html .Repo}}#{{.Number}}</a>, @{{html .Author}})</li>
+{{end}}</ul>
+{{else}}<p><i>None</i></p>
+{{end}}{{regionEnd "release-notes"}}`
+
+var builtinTemplates = map[string]string{
+	"report.slack.tmpl":               defaultSlackReportTemplate,
+	"report.confluence.tmpl":          defaultConfluenceReportTemplate,
+	"weekly-personal.confluence.tmpl": defaultPersonalWeeklyTemplate,
+	"weekly-team.confluence.tmpl":     defaultTeamWeeklyTemplate,
+	"review-metrics.confluence.tmpl":  defaultReviewMetricsTemplate,
+	"jira-metrics.confluence.tmpl":    defaultJiraMetricsTemplate,
+	"timesheet.confluence.tmpl":       defaultTimesheetTemplate,
+	"release-notes.confluence.tmpl":   defaultReleaseNotesTemplate,
+}
+
+// templateDir is the "templates" directory next to the config file.
+var templateDir string
+
+var (
+	templateMu    sync.Mutex
+	templateCache = make(map[string]*template.Template)
+)
+
+// loadTemplate loads the template of the first name which is found, the files
+// in templateDir take precedence over the built-in templates.
+func loadTemplate(names ...string) (*template.Template, error) {
+	templateMu.Lock()
+	defer templateMu.Unlock()
+
+	for _, name := range names {
+		t, ok := templateCache[name]
+		if !ok {
+			var err error
+			if t, err = parseTemplate(name); err != nil {
+				return nil, errors.Trace(err)
+			}
+			// A nil template caches that the name is not found.
+			templateCache[name] = t
+		}
+		if t != nil {
+			return t, nil
+		}
+	}
+	return nil, errors.NotFoundf("templates %v", names)
+}
+
+// parseTemplate returns nil if neither the file nor the built-in template of the name exists.
+func parseTemplate(name string) (*template.Template, error) {
+	text, err := readTemplateFile(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(text) == 0 {
+		var ok bool
+		if text, ok = builtinTemplates[name]; !ok {
+			return nil, nil
+		}
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	return t, errors.Annotatef(err, "template:%s", name)
+}
+
+func readTemplateFile(name string) (string, error) {
+	if len(templateDir) == 0 {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path.Join(templateDir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(data), nil
+}
+
+// executeTemplate executes the named template defined in the first template
+// found by names, or the template itself if the named template is empty.
+func executeTemplate(w io.Writer, data interface{}, named string, names ...string) error {
+	t, err := loadTemplate(names...)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(named) > 0 {
+		return errors.Annotatef(t.ExecuteTemplate(w, named, data), "template:%s", t.Name())
+	}
+	return errors.Annotatef(t.Execute(w, data), "template:%s", t.Name())
+}
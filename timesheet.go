@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+	"github.com/juju/errors"
+	"github.com/spf13/cobra"
+)
+
+// The times of the timesheet are in seconds, like the Jira time tracking.
+
+// TimesheetEntry is the time a member logged on an issue or an epic.
+type TimesheetEntry struct {
+	Key     string `json:"key"`
+	Summary string `json:"summary,omitempty"`
+	Logged  int    `json:"logged"`
+}
+
+// TimesheetMember is the time a member logged in the range.
+type TimesheetMember struct {
+	Name string `json:"name"`
+	// Days are the time logged on every day of Timesheet.Days.
+	Days   []int            `json:"days"`
+	Total  int              `json:"total"`
+	Issues []TimesheetEntry `json:"issues"`
+	Epics  []TimesheetEntry `json:"epics"`
+}
+
+// TimesheetIssue is the time the team logged on an issue in the range, and
+// the time tracking of the issue.
+type TimesheetIssue struct {
+	Key               string `json:"key"`
+	Summary           string `json:"summary"`
+	Epic              string `json:"epic"`
+	Logged            int    `json:"logged"`
+	Spent             int    `json:"spent"`
+	OriginalEstimate  int    `json:"original_estimate"`
+	RemainingEstimate int    `json:"remaining_estimate"`
+}
+
+// Estimated reports whether the issue has an original estimate.
+func (i TimesheetIssue) Estimated() bool {
+	return i.OriginalEstimate > 0
+}
+
+// Over is the time spent and remaining beyond the original estimate, it's
+// negative if the issue takes less than the estimate.
+func (i TimesheetIssue) Over() int {
+	return i.Spent + i.RemainingEstimate - i.OriginalEstimate
+}
+
+// TimesheetLog is the time a member logged on an issue in a day.
+type TimesheetLog struct {
+	Member string `json:"member"`
+	Day    string `json:"day"`
+	Key    string `json:"key"`
+	Logged int    `json:"logged"`
+}
+
+// Timesheet is the time the team members logged in [Start, End].
+type Timesheet struct {
+	Team    string            `json:"team"`
+	Start   string            `json:"start"`
+	End     string            `json:"end"`
+	Days    []string          `json:"days"`
+	Members []TimesheetMember `json:"members"`
+	Issues  []TimesheetIssue  `json:"issues"`
+	Logs    []TimesheetLog    `json:"logs"`
+}
+
+// formatWorkTime formats the seconds like Jira, e.g. "1d 2h 30m", a day is
+// config.Jira.TimeTrackingDayHours hours.
+func formatWorkTime(seconds int) string {
+	if seconds == 0 {
+		return "-"
+	}
+	sign := ""
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	minutes := seconds / 60
+	dayMinutes := config.Jira.TimeTrackingDayHours * 60
+	var parts []string
+	if d := minutes / dayMinutes; d > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", d))
+	}
+	if h := minutes % dayMinutes / 60; h > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", h))
+	}
+	if m := minutes % 60; m > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%dm", m))
+	}
+	return sign + strings.Join(parts, " ")
+}
+
+// timesheetEntries returns the entries sorted by the logged time, the most first.
+func timesheetEntries(logged map[string]int, summaries map[string]string) []TimesheetEntry {
+	entries := make([]TimesheetEntry, 0, len(logged))
+	for key, seconds := range logged {
+		entries = append(entries, TimesheetEntry{Key: key, Summary: summaries[key], Logged: seconds})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Logged != entries[j].Logged {
+			return entries[i].Logged > entries[j].Logged
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+func newTimesheetIssue(issue jira.Issue) TimesheetIssue {
+	ti := TimesheetIssue{
+		Key:               issue.Key,
+		Summary:           issue.Fields.Summary,
+		Epic:              jiraIssueEpic(issue),
+		Spent:             issue.Fields.TimeSpent,
+		OriginalEstimate:  issue.Fields.TimeOriginalEstimate,
+		RemainingEstimate: issue.Fields.TimeEstimate,
+	}
+	if tt := issue.Fields.TimeTracking; tt != nil {
+		ti.Spent = tt.TimeSpentSeconds
+		ti.OriginalEstimate = tt.OriginalEstimateSeconds
+		ti.RemainingEstimate = tt.RemainingEstimateSeconds
+	}
+	return ti
+}
+
+// collectTimesheet sums up the worklogs of the team members started in
+// [start, end], the issues whose worklogs fail to fetch are recorded to summary
+// and skipped. The search result has only the latest 20 worklogs of an issue,
+// so the worklogs are fetched one issue by one.
+func collectTimesheet(team *Team, start string, end string, summary *RunSummary) (*Timesheet, error) {
+	loc := reportClock().Location()
+	startDay, err := time.ParseInLocation(dayFormat, start, loc)
+	if err != nil {
+		return nil, errors.NotValidf("start date %s", start)
+	}
+	endDay, err := time.ParseInLocation(dayFormat, end, loc)
+	if err != nil {
+		return nil, errors.NotValidf("end date %s", end)
+	}
+
+	members := strings.Join(team.QuotedEmails(), ",")
+	jql := fmt.Sprintf(`worklogAuthor in (%s) AND worklogDate >= "%s" AND worklogDate < "%s" ORDER BY key`,
+		members, start, nextDay(end))
+	issues, err := queryJiraIssuesWithOptions(jql, &allFieldsOpts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	worklogs := make([][]jira.WorklogRecord, len(issues))
+	errs := make([]error, len(issues))
+	runParallel(len(issues), func(idx int) {
+		worklogs[idx], errs[idx] = getIssueWorklogs(issues[idx].Key)
+	})
+
+	sheet := &Timesheet{Team: team.Name, Start: start, End: end}
+	dayIdx := make(map[string]int)
+	for day := startDay; !day.After(endDay); day = day.AddDate(0, 0, 1) {
+		dayIdx[day.Format(dayFormat)] = len(sheet.Days)
+		sheet.Days = append(sheet.Days, day.Format(dayFormat))
+	}
+	memberIdx := make(map[string]int, len(team.Members))
+	memberIssues := make([]map[string]int, len(team.Members))
+	memberEpics := make([]map[string]int, len(team.Members))
+	for idx, member := range team.Members {
+		memberIdx[strings.ToLower(member.Email)] = idx
+		memberIssues[idx] = make(map[string]int)
+		memberEpics[idx] = make(map[string]int)
+		sheet.Members = append(sheet.Members, TimesheetMember{Name: member.Name, Days: make([]int, len(sheet.Days))})
+	}
+
+	type logKey struct {
+		member int
+		day    int
+		key    string
+	}
+	logs := make(map[logKey]int)
+	summaries := make(map[string]string)
+	for idx, issue := range issues {
+		if issue.Fields == nil || summary.Record(issue.Key, errs[idx]) {
+			continue
+		}
+		ti := newTimesheetIssue(issue)
+		for _, record := range worklogs[idx] {
+			if record.Author == nil || record.Started == nil {
+				continue
+			}
+			m, ok := memberIdx[strings.ToLower(record.Author.EmailAddress)]
+			if !ok {
+				continue
+			}
+			day, ok := dayIdx[time.Time(*record.Started).In(loc).Format(dayFormat)]
+			if !ok {
+				continue
+			}
+			sheet.Members[m].Days[day] += record.TimeSpentSeconds
+			sheet.Members[m].Total += record.TimeSpentSeconds
+			memberIssues[m][issue.Key] += record.TimeSpentSeconds
+			memberEpics[m][ti.Epic] += record.TimeSpentSeconds
+			ti.Logged += record.TimeSpentSeconds
+			logs[logKey{member: m, day: day, key: issue.Key}] += record.TimeSpentSeconds
+		}
+		if ti.Logged > 0 {
+			summaries[issue.Key] = issue.Fields.Summary
+			sheet.Issues = append(sheet.Issues, ti)
+		}
+	}
+	for idx := range sheet.Members {
+		sheet.Members[idx].Issues = timesheetEntries(memberIssues[idx], summaries)
+		sheet.Members[idx].Epics = timesheetEntries(memberEpics[idx], nil)
+	}
+	// The logs are in the member order, and then by the day and the issue.
+	keys := make([]logKey, 0, len(logs))
+	for key := range logs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].member != keys[j].member {
+			return keys[i].member < keys[j].member
+		}
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].key < keys[j].key
+	})
+	for _, key := range keys {
+		sheet.Logs = append(sheet.Logs, TimesheetLog{
+			Member: team.Members[key.member].Name,
+			Day:    sheet.Days[key.day],
+			Key:    key.key,
+			Logged: logs[key],
+		})
+	}
+	return sheet, nil
+}
+
+var timesheetCSV string
+
+func newMetricsTimesheetCommand() *cobra.Command {
+	m := &cobra.Command{
+		Use:   "timesheet",
+		Short: "Time Logged By The Members From Jira Worklogs",
+		Run:   runMetricsTimesheetCommandFunc,
+	}
+	m.Flags().StringVar(&timesheetCSV, "csv", "", "Write the timesheets to the CSV file too")
+	return m
+}
+
+func runMetricsTimesheetCommandFunc(cmd *cobra.Command, args []string) {
+	summary := newRunSummary("metrics timesheet")
+	start, end, err := metricsDateRange()
+	if summary.Record("date range", err) {
+		summary.Exit()
+	}
+
+	var all []*Timesheet
+	for _, team := range teams {
+		sheet, err := collectTimesheet(team, start, end, summary)
+		if summary.Record(team.Name, err) {
+			continue
+		}
+		all = append(all, sheet)
+		summary.Record(team.Name+" snapshot", saveDataSnapshot(team, snapshotKindTimesheet, sheet, timesheetSnapshotMetrics(sheet)))
+		if len(config.Metrics.Parent) == 0 || printToConsole {
+			summary.Record(team.Name+" output", renderTimesheet(os.Stdout, reportFormat, sheet))
+			continue
+		}
+		var body bytes.Buffer
+		err = renderTimesheet(&body, reportFormatConfluence, sheet)
+		if !summary.Record(team.Name+" output", err) {
+			title := fmt.Sprintf("%s Timesheet %s ~ %s", team.Name, start, end)
+			summary.Record(title, publishGeneratedPage(team, pageKindTimesheet, config.Metrics.Parent, title, body.String()))
+		}
+	}
+	if len(timesheetCSV) > 0 {
+		summary.Record(timesheetCSV, writeTimesheetCSVFile(timesheetCSV, all))
+	}
+	summary.Exit()
+}
+
+// renderTimesheet renders the timesheet as JSON, Confluence tables or text tables.
+func renderTimesheet(w io.Writer, format string, sheet *Timesheet) error {
+	switch format {
+	case reportFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Trace(enc.Encode(sheet))
+	case reportFormatConfluence:
+		return errors.Trace(executeTemplate(w, sheet, "", "timesheet.confluence.tmpl"))
+	case "", reportFormatText:
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "%s timesheet of %s ~ %s, a day is %d hours\n\n", sheet.Team, sheet.Start, sheet.End,
+			config.Jira.TimeTrackingDayHours)
+		tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "Member\t%s\tTotal\n", strings.Join(sheet.Days, "\t"))
+		for _, member := range sheet.Members {
+			days := make([]string, 0, len(member.Days))
+			for _, seconds := range member.Days {
+				days = append(days, formatWorkTime(seconds))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", member.Name, strings.Join(days, "\t"), formatWorkTime(member.Total))
+		}
+		tw.Flush()
+
+		buf.WriteString("\n")
+		tw = tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "Issue\tEpic\tLogged\tSpent\tOriginal\tRemaining\tOver\n")
+		for _, issue := range sheet.Issues {
+			over := "-"
+			if issue.Estimated() {
+				over = formatWorkTime(issue.Over())
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", issue.Key, issue.Epic, formatWorkTime(issue.Logged),
+				formatWorkTime(issue.Spent), formatWorkTime(issue.OriginalEstimate), formatWorkTime(issue.RemainingEstimate), over)
+		}
+		tw.Flush()
+		_, err := w.Write(buf.Bytes())
+		return errors.Trace(err)
+	}
+	return errors.NotSupportedf("timesheet format %s, use one of %s,%s,%s", format, reportFormatText, reportFormatJSON, reportFormatConfluence)
+}
+
+func workHours(seconds int) string {
+	return fmt.Sprintf("%.2f", float64(seconds)/3600)
+}
+
+// writeTimesheetCSV writes one row per member, day and issue, the times are in
+// hours, and the estimates are of the issue.
+func writeTimesheetCSV(w io.Writer, all []*Timesheet) error {
+	cw := csv.NewWriter(w)
+	header := []string{"team", "member", "day", "issue", "summary", "epic", "logged_hours",
+		"spent_hours", "original_estimate_hours", "remaining_estimate_hours"}
+	if err := cw.Write(header); err != nil {
+		return errors.Trace(err)
+	}
+	for _, sheet := range all {
+		issues := make(map[string]TimesheetIssue, len(sheet.Issues))
+		for _, issue := range sheet.Issues {
+			issues[issue.Key] = issue
+		}
+		for _, entry := range sheet.Logs {
+			issue := issues[entry.Key]
+			row := []string{sheet.Team, entry.Member, entry.Day, entry.Key, issue.Summary, issue.Epic, workHours(entry.Logged),
+				workHours(issue.Spent), workHours(issue.OriginalEstimate), workHours(issue.RemainingEstimate)}
+			if err := cw.Write(row); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	cw.Flush()
+	return errors.Trace(cw.Error())
+}
+
+func writeTimesheetCSVFile(file string, all []*Timesheet) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = writeTimesheetCSV(f, all); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+func TestRunMetricsTimesheetCommand(t *testing.T) {
+	title := "Team Timesheet 2026-10-10 ~ 2026-10-16"
+	tests := []struct {
+		name   string
+		parent string
+		// existing is the body of the existing timesheet page, there is none if empty.
+		existing string
+		printed  bool
+		contains []string
+		version  int
+	}{
+		{name: "print", printed: true},
+		{name: "new page", parent: "Metrics", contains: []string{"Timesheet", "TIKV-1"}, version: 1},
+		{
+			name:     "existing page keeps the notes",
+			parent:   "Metrics",
+			existing: regionBegin("timesheet") + "<p>TIKV-99</p>" + regionEnd("timesheet") + "<p>notes by hand</p>",
+			contains: []string{"TIKV-1", "<p>notes by hand</p>"},
+			version:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, jr, cf, _ := setupFakes(t)
+			config.Metrics.Parent = tt.parent
+			issue := testJiraIssue("TIKV-1", "Support TTL", "alice@example.com", "In Progress", "indeterminate")
+			jr.Issues[`worklogAuthor in ("alice@example.com","bob@example.com") AND worklogDate >= "2026-10-10" AND worklogDate < "2026-10-17" ORDER BY key`] = []jira.Issue{issue}
+			started := jira.Time(testNow.Add(-time.Hour))
+			jr.Worklogs["TIKV-1"] = []jira.WorklogRecord{{
+				Author:           &jira.User{EmailAddress: "alice@example.com"},
+				Started:          &started,
+				TimeSpentSeconds: 7200,
+			}}
+			if _, err := createContent(config.Confluence.Space, "", "Metrics", ""); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.existing) > 0 {
+				if _, err := createContent(config.Confluence.Space, "", title, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output := captureStdout(t, func() {
+				runMetricsTimesheetCommandFunc(nil, nil)
+			})
+
+			page, _ := cf.GetContentByTitle(config.Confluence.Space, title)
+			if tt.printed {
+				if len(page.Id) > 0 || !strings.Contains(output, "TIKV-1") {
+					t.Errorf("page %q, printed:\n%s", page.Id, output)
+				}
+				return
+			}
+			body := page.Body.Storage.Value
+			for _, want := range tt.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("page doesn't contain %q:\n%s", want, body)
+				}
+			}
+			if strings.Contains(body, "TIKV-99") {
+				t.Errorf("the stale region is kept:\n%s", body)
+			}
+			if page.Version.Number != tt.version {
+				t.Errorf("page version %d, want %d", page.Version.Number, tt.version)
+			}
+		})
+	}
+}
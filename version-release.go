@@ -3,13 +3,23 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	jira "github.com/andygrunwald/go-jira"
+	"github.com/google/go-github/github"
 	"github.com/juju/errors"
+	"github.com/ngaut/log"
 	"github.com/spf13/cobra"
 )
 
+var (
+	releaseVersion string
+	releaseStart   string
+	releaseEnd     string
+)
+
 func newVersionReleaseCommand() *cobra.Command {
 	m := &cobra.Command{
 		Use:   "release",
@@ -27,6 +37,9 @@ func newVersionReleaseReportCommand() *cobra.Command {
 		Short: "Create Release Version Report",
 		Run:   runVersionReleaseReportCommandFunc,
 	}
+	m.Flags().StringVar(&releaseVersion, "version", "", "The fixVersion of the release, e.g. v3.0")
+	m.Flags().StringVar(&releaseStart, "start", "", "The release notes are of the pull requests merged since the day, default the pull requests in the milestone of the version")
+	m.Flags().StringVar(&releaseEnd, "end", "", "The last day of the merged pull requests, default today, used with --start")
 	return m
 }
 
@@ -41,27 +54,54 @@ func newVersionReleaseLinkCommand() *cobra.Command {
 
 func runVersionReleaseReportCommandFunc(cmd *cobra.Command, args []string) {
 	// create version release report
+	summary := newRunSummary("release report")
+	if len(releaseVersion) == 0 {
+		summary.Record("--version", errors.NotValidf("empty --version"))
+		summary.Exit()
+	}
 	var pageBody bytes.Buffer
 
+	// The regions are in a single layout cell, the anchors can't be between the sections.
 	formatPageBeginForHtmlOutput(&pageBody)
-	genVersionReleaseReportHtml(&pageBody)
+	formatSectionBeginForHtmlOutput(&pageBody)
+	pageBody.WriteString(regionBegin("release-issues"))
+	if summary.Record("version release", genVersionReleaseReportHtml(&pageBody, releaseVersion)) {
+		summary.Exit()
+	}
+	pageBody.WriteString(regionEnd("release-issues"))
+
+	notes, err := collectReleaseNotes(releaseVersion, releaseStart, releaseEnd)
+	if !summary.Record("release notes", err) {
+		summary.Record("release notes", executeTemplate(&pageBody, notes, "", "release-notes.confluence.tmpl"))
+	}
+	formatSectionEndForHtmlOutput(&pageBody)
 	formatPageEndForHtmlOutput(&pageBody)
 
-	fmt.Println(pageBody.String())
+	if len(config.Release.Parent) == 0 || printToConsole {
+		fmt.Println(pageBody.String())
+	} else {
+		summary.Record("release page", createReleaseReportToConfluence(releaseVersion, pageBody.String()))
+	}
+	summary.Exit()
 }
 
 func runVersionReleaseLinkCommandFunc(cmd *cobra.Command, args []string) {
 	// Link
+	summary := newRunSummary("release link")
 	linkIssues := config.IssueLinks
 	for _, linkIssue := range linkIssues {
 		err := linkRelatedJiraIssues(linkIssue.LinkTo, linkIssue.Labels, linkIssue.ReleaseVer)
-		perror(errors.Trace(err))
+		summary.Record(linkIssue.LinkTo, err)
 	}
+	summary.Exit()
 }
 
 func linkRelatedJiraIssues(linkToIssue string, labels []string, releaseVer string) error {
 	labelsStr := strings.Join(labels, ",")
-	jiraIssues := queryJiraIssues(fmt.Sprintf("labels in (%s) and fixVersion = %s and type = Epic", labelsStr, releaseVer))
+	jiraIssues, err := queryJiraIssues(fmt.Sprintf("labels in (%s) and fixVersion = %s and type = Epic", labelsStr, releaseVer))
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	for _, issue := range jiraIssues {
 		issueLink := &jira.IssueLink{
@@ -70,21 +110,185 @@ func linkRelatedJiraIssues(linkToIssue string, labels []string, releaseVer strin
 			Type:         jira.IssueLinkType{Name: "Relates"},
 		}
 
-		_, err := jiraClient.Issue.AddLink(issueLink)
+		err := issueSearcher.AddLink(issueLink)
 		if err != nil {
-			return err
+			return errors.Annotatef(err, "link %s to %s", issue.Key, linkToIssue)
 		}
 	}
 
 	return nil
 }
 
-func genVersionReleaseReportHtml(buf *bytes.Buffer) {
-	formatSectionBeginForHtmlOutput(buf)
+// genVersionReleaseReportHtml adds the "Version Release" issue of the version,
+// and the issues and the epics linked to it.
+func genVersionReleaseReportHtml(buf *bytes.Buffer, version string) error {
+	releaseItemIssue, err := queryJiraIssues(fmt.Sprintf(`type = "Version Release" AND fixVersion = %s ORDER BY key`, strconv.Quote(version)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(releaseItemIssue) == 0 {
+		return errors.NotFoundf("version release issue of %s", version)
+	}
+	if len(releaseItemIssue) > 1 {
+		log.Warnf("%d version release issues of %s, use %s", len(releaseItemIssue), version, releaseItemIssue[0].Key)
+	}
+
 	buf.WriteString("<p>")
-	releaseItemIssue := queryJiraIssues("key = TIDB-3476")
 	formatJiraIssueForHtmlOutput(buf, &releaseItemIssue[0])
-	formatJiraIssueToExpandForHtmlOutput(buf, &releaseItemIssue[0], nil)
+	if err = formatJiraIssueToExpandForHtmlOutput(buf, &releaseItemIssue[0], nil); err != nil {
+		return errors.Trace(err)
+	}
 	buf.WriteString("</p>")
-	formatSectionEndForHtmlOutput(buf)
+	return nil
+}
+
+// ReleaseNotes is the data of the release-notes.confluence.tmpl template.
+type ReleaseNotes struct {
+	Version string
+	Groups  []ReleaseNoteGroup
+}
+
+// ReleaseNoteGroup is the notes of a label in config.Release.NoteLabels, the
+// label is empty for the others.
+type ReleaseNoteGroup struct {
+	Label string
+	Notes []ReleaseNote
+}
+
+type ReleaseNote struct {
+	Repo   string
+	Number int
+	URL    string
+	Author string
+	Note   string
+}
+
+// collectReleaseNotes groups the pull requests merged in [start, end], or in the
+// milestone of the version if start is empty.
+func collectReleaseNotes(version string, start string, end string) (*ReleaseNotes, error) {
+	var (
+		prs IssueSlice
+		err error
+	)
+	if len(start) > 0 {
+		if len(end) == 0 {
+			end = reportClock().Format(dayFormat)
+		}
+		prs, err = getMergedPullRequests(start, &end)
+	} else {
+		prs, err = getMilestonePullRequests(version)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newReleaseNotes(version, prs), nil
+}
+
+// getMilestonePullRequests returns the merged pull requests in the milestone.
+func getMilestonePullRequests(milestone string) (IssueSlice, error) {
+	return getIssues("created", map[string]string{
+		"is":        "merged",
+		"type":      "pr",
+		"milestone": strconv.Quote(milestone),
+	})
+}
+
+func newReleaseNotes(version string, prs IssueSlice) *ReleaseNotes {
+	notes := &ReleaseNotes{Version: version}
+	groups := make([]ReleaseNoteGroup, len(config.Release.NoteLabels)+1)
+	for idx, label := range config.Release.NoteLabels {
+		groups[idx].Label = label
+	}
+	for _, pr := range prs {
+		labels := make(map[string]bool)
+		for _, label := range pr.Labels {
+			labels[label.GetName()] = true
+		}
+		if hasAnyLabel(labels, config.Release.SkipLabels) {
+			continue
+		}
+		// The others are the last group.
+		idx := len(config.Release.NoteLabels)
+		for i, label := range config.Release.NoteLabels {
+			if labels[label] {
+				idx = i
+				break
+			}
+		}
+		note := releaseNoteOf(pr)
+		if len(note) == 0 {
+			continue
+		}
+		groups[idx].Notes = append(groups[idx].Notes, ReleaseNote{
+			Repo:   pullRequestRepo(pr),
+			Number: pr.GetNumber(),
+			URL:    pr.GetHTMLURL(),
+			Author: pr.GetUser().GetLogin(),
+			Note:   note,
+		})
+	}
+	for _, group := range groups {
+		if len(group.Notes) > 0 {
+			notes.Groups = append(notes.Groups, group)
+		}
+	}
+	return notes
+}
+
+func hasAnyLabel(labels map[string]bool, names []string) bool {
+	for _, name := range names {
+		if labels[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// pullRequestRepo returns the "owner/repo" in the URL of the pull request.
+func pullRequestRepo(pr github.Issue) string {
+	parts := strings.Split(strings.TrimPrefix(pr.GetHTMLURL(), "https://github.com/"), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0] + "/" + parts[1]
+}
+
+var releaseNoteHeading = regexp.MustCompile(`(?i)^#*\s*release[ -]?note`)
+
+// releaseNoteOf returns the text under the "Release note" heading of the pull
+// request's description, or the title if there is none. It returns "" if the
+// note is "None", "N/A" or "No", the pull request needs no release note.
+func releaseNoteOf(pr github.Issue) string {
+	var (
+		lines []string
+		found bool
+	)
+	for _, line := range strings.Split(pr.GetBody(), "\n") {
+		line = strings.TrimSpace(line)
+		if !found {
+			found = releaseNoteHeading.MatchString(line)
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "<!--") || len(line) == 0 {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	note := strings.Join(lines, " ")
+	switch strings.ToLower(strings.Trim(note, ".!`* ")) {
+	case "":
+		return pr.GetTitle()
+	case "none", "n/a", "no":
+		return ""
+	}
+	return note
+}
+
+// createReleaseReportToConfluence creates the release page under config.Release.Parent,
+// only the generated regions are replaced if the page exists.
+func createReleaseReportToConfluence(version string, body string) error {
+	return errors.Trace(publishGeneratedPage(nil, pageKindRelease, config.Release.Parent, "Release "+version, body))
 }
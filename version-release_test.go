@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func testReleasePullRequest(number int, body string, labels ...string) github.Issue {
+	pr := testPullRequest(number, fmt.Sprintf("Title %d", number), "alice")
+	pr.Body = github.String(body)
+	for _, label := range labels {
+		pr.Labels = append(pr.Labels, github.Label{Name: github.String(label)})
+	}
+	return pr
+}
+
+func TestReleaseNoteOf(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "no heading", body: "Fix the panic.", want: "Title 1"},
+		{name: "heading", body: "### What is changed\n\nFix.\n\n### Release note\n\nFix the panic of `SELECT`.\n", want: "Fix the panic of `SELECT`."},
+		{name: "lines are joined", body: "Release note:\nFix the panic\nof SELECT.", want: "Fix the panic of SELECT."},
+		{name: "code block and comment", body: "### Release note\n<!-- bugfix or feature -->\n```release-note\nSupport TTL.\n```", want: "Support TTL."},
+		{name: "next heading", body: "## Release-note\nSupport TTL.\n## Tests\nUnit test", want: "Support TTL."},
+		{name: "empty note", body: "### Release note\n\n### Tests\n", want: "Title 1"},
+		{name: "none", body: "### Release note\n```release-note\nNone\n```", want: ""},
+		{name: "n/a", body: "### Release note\nN/A.", want: ""},
+		{name: "no", body: "release note\n`NO`", want: ""},
+		{name: "starts with none", body: "### Release note\nNone of the queries panic now.", want: "None of the queries panic now."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := releaseNoteOf(testReleasePullRequest(1, tt.body)); got != tt.want {
+				t.Errorf("releaseNoteOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewReleaseNotes(t *testing.T) {
+	setupFakes(t)
+	config.Release.NoteLabels = []string{"type/feature", "type/bugfix"}
+	config.Release.SkipLabels = []string{"release-note-none"}
+
+	tests := []struct {
+		name string
+		prs  IssueSlice
+		// groups are the "label: numbers" of the groups in order.
+		groups []string
+	}{
+		{name: "no pull requests"},
+		{
+			name: "grouped by the first label",
+			prs: IssueSlice{
+				testReleasePullRequest(1, "", "type/bugfix"),
+				testReleasePullRequest(2, "", "type/bugfix", "type/feature"),
+				testReleasePullRequest(3, ""),
+				testReleasePullRequest(4, "", "type/feature"),
+			},
+			groups: []string{"type/feature: 2,4", "type/bugfix: 1", ": 3"},
+		},
+		{
+			name: "skipped by the label",
+			prs: IssueSlice{
+				testReleasePullRequest(1, "", "type/bugfix", "release-note-none"),
+				testReleasePullRequest(2, "", "type/bugfix"),
+			},
+			groups: []string{"type/bugfix: 2"},
+		},
+		{
+			name: "skipped by the note",
+			prs: IssueSlice{
+				testReleasePullRequest(1, "### Release note\nNone", "type/feature"),
+				testReleasePullRequest(2, "### Release note\nN/A"),
+				testReleasePullRequest(3, "### Release note\nSupport TTL.", "type/feature"),
+			},
+			groups: []string{"type/feature: 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notes := newReleaseNotes("v3.0", tt.prs)
+			if notes.Version != "v3.0" {
+				t.Errorf("version %s", notes.Version)
+			}
+			var groups []string
+			for _, group := range notes.Groups {
+				var numbers []string
+				for _, note := range group.Notes {
+					if note.Repo != "pingcap/tidb" || note.Author != "alice" || len(note.Note) == 0 {
+						t.Errorf("note %+v", note)
+					}
+					numbers = append(numbers, fmt.Sprint(note.Number))
+				}
+				groups = append(groups, group.Label+": "+strings.Join(numbers, ","))
+			}
+			if strings.Join(groups, "; ") != strings.Join(tt.groups, "; ") {
+				t.Errorf("groups %q, want %q", groups, tt.groups)
+			}
+		})
+	}
+}
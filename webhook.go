@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+const githubWebhookMaxBody = 25 << 20
+
+// githubEvents serves the GitHub queries from the received webhook events
+// instead of the search API, it's nil if config.Github.Events is false.
+var githubEvents *GithubEventStore
+
+// GithubEvent is a received webhook event, Issue is the issue or the pull
+// request after the event, the pull requests are stored as issues too.
+type GithubEvent struct {
+	Delivery string       `json:"delivery"`
+	Type     string       `json:"type"`
+	Action   string       `json:"action"`
+	Time     time.Time    `json:"time"`
+	Repo     string       `json:"repo"`
+	Actor    string       `json:"actor"`
+	Issue    github.Issue `json:"issue"`
+	// Mentions are the users mentioned by the issue body, the comment or the review.
+	Mentions []string `json:"mentions,omitempty"`
+	// Reviewer is the user of the review_requested and review_request_removed events.
+	Reviewer string `json:"reviewer,omitempty"`
+}
+
+const defaultEventsRetentionDays = 90
+
+// GithubEventStore appends the events to one JSON lines file per day, the
+// files older than the retention are deleted when a new day begins.
+type GithubEventStore struct {
+	dir       string
+	retention int
+
+	mu sync.Mutex
+	// cached are the issue states replayed from the files, they're loaded
+	// once and reset by append.
+	cached []*githubIssueState
+}
+
+func newGithubEventStore(dir string, retentionDays int) *GithubEventStore {
+	if retentionDays <= 0 {
+		retentionDays = defaultEventsRetentionDays
+	}
+	return &GithubEventStore{dir: dir, retention: retentionDays}
+}
+
+func (s *GithubEventStore) file(t time.Time) string {
+	return path.Join(s.dir, t.UTC().Format(dayFormat)+".jsonl")
+}
+
+// expired returns whether the day file is out of the retention at now.
+func (s *GithubEventStore) expired(file string, now time.Time) bool {
+	day, err := time.Parse(dayFormat, strings.TrimSuffix(path.Base(file), ".jsonl"))
+	if err != nil {
+		return false
+	}
+	return day.Before(now.UTC().AddDate(0, 0, -s.retention))
+}
+
+func (s *GithubEventStore) files() ([]string, error) {
+	files, err := filepath.Glob(path.Join(s.dir, "*.jsonl"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *GithubEventStore) append(event GithubEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = os.MkdirAll(s.dir, 0700); err != nil {
+		return errors.Trace(err)
+	}
+	file := s.file(event.Time)
+	if _, err = os.Stat(file); os.IsNotExist(err) {
+		// The first event of the day.
+		if err = s.prune(event.Time); err != nil {
+			log.Warnf("prune github events: %v", err)
+		}
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.cached = nil
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
+
+// prune deletes the day files out of the retention at now.
+func (s *GithubEventStore) prune(now time.Time) error {
+	files, err := s.files()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, file := range files {
+		if !s.expired(file, now) {
+			continue
+		}
+		log.Infof("delete expired github events %s", file)
+		if err = os.Remove(file); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// load reads the events in the retention in order, the redelivered events are
+// skipped, s.mu must be held.
+func (s *GithubEventStore) load() ([]GithubEvent, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	now := reportClock()
+	var events []GithubEvent
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if s.expired(file, now) {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, githubWebhookMaxBody)
+		for line := 1; scanner.Scan(); line++ {
+			var event GithubEvent
+			if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				f.Close()
+				return nil, errors.Annotatef(err, "%s:%d", file, line)
+			}
+			if len(event.Delivery) > 0 && seen[event.Delivery] {
+				continue
+			}
+			seen[event.Delivery] = true
+			events = append(events, event)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, errors.Annotatef(err, "%s", file)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+// githubIssueState is an issue or a pull request replayed from the events.
+type githubIssueState struct {
+	issue      github.Issue
+	updated    time.Time
+	mentions   map[string]bool
+	commenters map[string]bool
+	reviewers  map[string]bool
+}
+
+// states returns the issue states, the files are read only by the first query.
+func (s *GithubEventStore) states() ([]*githubIssueState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil {
+		return s.cached, nil
+	}
+
+	events, err := s.load()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	states := make(map[string]*githubIssueState)
+	var links []string
+	for _, event := range events {
+		link := event.Issue.GetHTMLURL()
+		state, ok := states[link]
+		if !ok {
+			state = &githubIssueState{
+				mentions:   make(map[string]bool),
+				commenters: make(map[string]bool),
+				reviewers:  make(map[string]bool),
+			}
+			states[link] = state
+			links = append(links, link)
+		}
+		state.issue = event.Issue
+		state.updated = event.Time
+		for _, login := range event.Mentions {
+			state.mentions[strings.ToLower(login)] = true
+		}
+		actor := strings.ToLower(event.Actor)
+		switch {
+		case event.Type == "issue_comment" || event.Type == "pull_request_review":
+			state.commenters[actor] = true
+			delete(state.reviewers, actor)
+		case event.Action == "review_requested":
+			state.reviewers[strings.ToLower(event.Reviewer)] = true
+		case event.Action == "review_request_removed":
+			delete(state.reviewers, strings.ToLower(event.Reviewer))
+		}
+	}
+
+	sort.Strings(links)
+	s.cached = make([]*githubIssueState, 0, len(links))
+	for _, link := range links {
+		s.cached = append(s.cached, states[link])
+	}
+	return s.cached, nil
+}
+
+// find returns the issues matched by fn, like the search API does.
+func (s *GithubEventStore) find(fn func(state *githubIssueState) bool) (IssueSlice, error) {
+	states, err := s.states()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var issues IssueSlice
+	for _, state := range states {
+		if fn(state) {
+			issues = append(issues, state.issue)
+		}
+	}
+	sort.Sort(issues)
+	return issues, nil
+}
+
+// parseGithubDateRange parses the start and end of generateDateRangeQuery,
+// the end day is inclusive.
+func parseGithubDateRange(start string, end *string) (time.Time, time.Time, error) {
+	parse := func(s string, inclusive bool) (time.Time, error) {
+		if t, err := time.Parse(githubUTCDateFormat, s); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(dayFormat, s)
+		if err != nil {
+			return t, errors.NotValidf("date %s", s)
+		}
+		if inclusive {
+			t = t.Add(24 * time.Hour)
+		}
+		return t, nil
+	}
+
+	from, err := parse(start, false)
+	if err != nil {
+		return from, from, errors.Trace(err)
+	}
+	to := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if end != nil {
+		if to, err = parse(*end, true); err != nil {
+			return from, to, errors.Trace(err)
+		}
+	}
+	return from, to, nil
+}
+
+func inTimeRange(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
+
+func (s *GithubEventStore) createdIssues(start string, end *string, pr bool) (IssueSlice, error) {
+	from, to, err := parseGithubDateRange(start, end)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s.find(func(state *githubIssueState) bool {
+		return state.issue.IsPullRequest() == pr && inTimeRange(state.issue.GetCreatedAt(), from, to)
+	})
+}
+
+func (s *GithubEventStore) mentionedPullRequests(start string, end *string, user string) (IssueSlice, error) {
+	from, to, err := parseGithubDateRange(start, end)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	user = strings.ToLower(user)
+	return s.find(func(state *githubIssueState) bool {
+		return state.issue.IsPullRequest() && inTimeRange(state.updated, from, to) &&
+			state.mentions[user] && !strings.EqualFold(state.issue.GetUser().GetLogin(), user)
+	})
+}
+
+func (s *GithubEventStore) reviewPullRequests(user string, start string, end *string) (IssueSlice, error) {
+	from, to, err := parseGithubDateRange(start, end)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	user = strings.ToLower(user)
+	return s.find(func(state *githubIssueState) bool {
+		return state.issue.IsPullRequest() && state.issue.GetState() == "open" && inTimeRange(state.updated, from, to) &&
+			state.commenters[user] && !strings.EqualFold(state.issue.GetUser().GetLogin(), user)
+	})
+}
+
+func (s *GithubEventStore) reviewRequestedPullRequests(user string) (IssueSlice, error) {
+	user = strings.ToLower(user)
+	return s.find(func(state *githubIssueState) bool {
+		return state.issue.IsPullRequest() && state.issue.GetState() == "open" && state.reviewers[user]
+	})
+}
+
+// verifyGithubSignature verifies the X-Hub-Signature-256 header, see
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries.
+func verifyGithubSignature(header http.Header, body []byte, secret string) error {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Hub-Signature-256"))) {
+		return errors.Unauthorizedf("github webhook signature")
+	}
+	return nil
+}
+
+var githubMentionPattern = regexp.MustCompile(`(?:^|[^\w/])@([A-Za-z0-9][A-Za-z0-9-]*)`)
+
+func githubMentions(texts ...string) []string {
+	var logins []string
+	for _, text := range texts {
+		for _, m := range githubMentionPattern.FindAllStringSubmatch(text, -1) {
+			logins = append(logins, m[1])
+		}
+	}
+	return logins
+}
+
+// pullRequestIssue converts the pull request to the issue returned by the search API.
+func pullRequestIssue(pr *github.PullRequest) github.Issue {
+	return github.Issue{
+		ID:        pr.ID,
+		Number:    pr.Number,
+		State:     pr.State,
+		Title:     pr.Title,
+		Body:      pr.Body,
+		User:      pr.User,
+		Assignees: pr.Assignees,
+		HTMLURL:   pr.HTMLURL,
+		CreatedAt: pr.CreatedAt,
+		UpdatedAt: pr.UpdatedAt,
+		ClosedAt:  pr.ClosedAt,
+		PullRequestLinks: &github.PullRequestLinks{
+			URL:     pr.URL,
+			HTMLURL: pr.HTMLURL,
+		},
+	}
+}
+
+// newGithubEvent converts the webhook payload, it returns nil for the
+// events which the reports don't need.
+func newGithubEvent(eventType string, payload []byte) (*GithubEvent, error) {
+	switch eventType {
+	case "pull_request", "issue_comment", "pull_request_review", "issues":
+	default:
+		return nil, nil
+	}
+	parsed, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	event := &GithubEvent{Type: eventType}
+	var repo *github.Repository
+	var sender *github.User
+	switch e := parsed.(type) {
+	case *github.PullRequestEvent:
+		if e.PullRequest == nil {
+			return nil, errors.NotValidf("pull_request event without pull request")
+		}
+		event.Action, repo, sender = e.GetAction(), e.Repo, e.Sender
+		event.Issue = pullRequestIssue(e.PullRequest)
+		event.Reviewer = e.GetRequestedReviewer().GetLogin()
+		if event.Action == "opened" || event.Action == "edited" {
+			event.Mentions = githubMentions(e.PullRequest.GetBody())
+		}
+	case *github.IssueCommentEvent:
+		if e.Issue == nil {
+			return nil, errors.NotValidf("issue_comment event without issue")
+		}
+		event.Action, repo, sender = e.GetAction(), e.Repo, e.Sender
+		event.Issue = *e.Issue
+		if event.Action != "deleted" {
+			event.Mentions = githubMentions(e.GetComment().GetBody())
+		}
+	case *github.PullRequestReviewEvent:
+		if e.PullRequest == nil {
+			return nil, errors.NotValidf("pull_request_review event without pull request")
+		}
+		event.Action, repo, sender = e.GetAction(), e.Repo, e.Sender
+		event.Issue = pullRequestIssue(e.PullRequest)
+		event.Mentions = githubMentions(e.GetReview().GetBody())
+	case *github.IssuesEvent:
+		if e.Issue == nil {
+			return nil, errors.NotValidf("issues event without issue")
+		}
+		event.Action, repo, sender = e.GetAction(), e.Repo, e.Sender
+		event.Issue = *e.Issue
+		if event.Action == "opened" || event.Action == "edited" {
+			event.Mentions = githubMentions(e.Issue.GetBody())
+		}
+	default:
+		return nil, nil
+	}
+	event.Repo = repo.GetFullName()
+	event.Actor = sender.GetLogin()
+	return event, nil
+}
+
+func isReportedRepo(repo string) bool {
+	for _, r := range config.Github.Repos {
+		if strings.EqualFold(r, repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// githubWebhookHandler stores the events of the configured repos.
+type githubWebhookHandler struct {
+	secret string
+	store  *GithubEventStore
+	now    func() time.Time
+}
+
+func newGithubWebhookHandler(secret string, store *GithubEventStore) *githubWebhookHandler {
+	return &githubWebhookHandler{secret: secret, store: store, now: time.Now}
+}
+
+func (h *githubWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, githubWebhookMaxBody))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err = verifyGithubSignature(r.Header, body, h.secret); err != nil {
+		log.Warnf("reject github webhook from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType, delivery := github.WebHookType(r), github.DeliveryID(r)
+	event, err := newGithubEvent(eventType, body)
+	if err != nil {
+		log.Warnf("parse github event %s %s: %v", eventType, delivery, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if event == nil || !isReportedRepo(event.Repo) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	event.Delivery = delivery
+	event.Time = h.now().UTC()
+	if err = h.store.append(*event); err != nil {
+		log.Errorf("store github event %s %s: %v", eventType, delivery, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	log.Infof("github event %s %s of %s", eventType, event.Action, event.Issue.GetHTMLURL())
+	w.WriteHeader(http.StatusNoContent)
+}
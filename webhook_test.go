@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func testGithubEvent(delivery string, number int, at time.Time) GithubEvent {
+	issue := testPullRequest(number, "Fix the planner", "carol")
+	issue.PullRequestLinks = &github.PullRequestLinks{HTMLURL: issue.HTMLURL}
+	return GithubEvent{
+		Delivery: delivery,
+		Type:     "pull_request",
+		Action:   "opened",
+		Time:     at,
+		Repo:     "pingcap/tidb",
+		Actor:    "carol",
+		Issue:    issue,
+		Mentions: []string{"alice"},
+	}
+}
+
+func writeGithubEvents(t *testing.T, store *GithubEventStore, events ...GithubEvent) {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(store.file(event.Time), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(append(data, '\n'))
+		f.Close()
+	}
+}
+
+func TestGithubEventStore(t *testing.T) {
+	setupFakes(t)
+	store := newGithubEventStore(t.TempDir(), 30)
+	start := testNow.AddDate(0, 0, -60).Format(githubUTCDateFormat)
+	expired := testGithubEvent("expired", 1, testNow.AddDate(0, 0, -40))
+	writeGithubEvents(t, store, expired, testGithubEvent("kept", 2, testNow.AddDate(0, 0, -1)))
+
+	// The expired events are never read.
+	issues, err := store.mentionedPullRequests(start, nil, "alice")
+	if err != nil || len(issues) != 1 || issues[0].GetNumber() != 2 {
+		t.Fatalf("mentioned %v %v", issues, err)
+	}
+
+	// The queries share the loaded states until an event is appended.
+	writeGithubEvents(t, store, testGithubEvent("other", 3, testNow.Add(-time.Hour)))
+	if issues, err = store.mentionedPullRequests(start, nil, "alice"); err != nil || len(issues) != 1 {
+		t.Fatalf("mentioned from the loaded states %v %v", issues, err)
+	}
+	if _, err = os.Stat(store.file(expired.Time)); err != nil {
+		t.Fatalf("the expired file is deleted before a new day: %v", err)
+	}
+
+	// The first event of a new day deletes the expired files.
+	if err = store.append(testGithubEvent("new", 4, testNow.AddDate(0, 0, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(store.file(expired.Time)); !os.IsNotExist(err) {
+		t.Errorf("the expired file is kept: %v", err)
+	}
+	if issues, err = store.mentionedPullRequests(start, nil, "alice"); err != nil || len(issues) != 3 {
+		t.Fatalf("mentioned after the append %v %v", issues, err)
+	}
+}
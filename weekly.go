@@ -3,10 +3,12 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/andygrunwald/go-jira"
+	"github.com/juju/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -50,6 +52,7 @@ func newWeeklyCommand() *cobra.Command {
 	}
 	m.AddCommand(newWeeklyDeadlineReportCommand())
 	m.AddCommand(newWeeklyReportCommand())
+	m.AddCommand(newRotateSprintCommand())
 	return m
 }
 
@@ -76,7 +79,6 @@ func collectEpicJiraIssue(jiraIssues []jira.Issue) map[string]*JiraIssueArray {
 
 type IssueRepeatChecker map[string]struct{}
 
-//
 func (ir IssueRepeatChecker) Check(key string) bool {
 	_, ok := ir[key]
 	if !ok {
@@ -99,10 +101,17 @@ func duedate2EstimateSeconds(duedate time.Time, now time.Time) int {
 	return int(seconds)
 }
 
-func findNextWeekIssues(member Member, now time.Time) []jira.Issue {
+func findNextWeekIssues(member Member, now time.Time) ([]jira.Issue, error) {
 	// Find all duedate less than 7 days.
-	nextWeekIssues := queryJiraIssuesWithOptions(fmt.Sprintf(`assignee = "%s" AND duedate <= 7d AND statusCategory = indeterminate`, member.Email), &allFieldsOpts)
-	remainingMorethan7dIssues := queryJiraIssuesWithOptions(fmt.Sprintf(`assignee = "%s" AND remainingEstimate > 7d AND duedate > 7d AND priority >= High AND statusCategory = indeterminate`, member.Email), &allFieldsOpts)
+	inProgress := config.Jira.inProgressJQL()
+	nextWeekIssues, err := queryJiraIssuesWithOptions(fmt.Sprintf(`assignee = "%s" AND duedate <= 7d AND %s`, member.Email, inProgress), &allFieldsOpts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	remainingMorethan7dIssues, err := queryJiraIssuesWithOptions(fmt.Sprintf(`assignee = "%s" AND remainingEstimate > 7d AND duedate > 7d AND priority >= High AND %s`, member.Email, inProgress), &allFieldsOpts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	for _, issue := range remainingMorethan7dIssues {
 		if issue.Fields.TimeTracking == nil {
@@ -115,145 +124,223 @@ func findNextWeekIssues(member Member, now time.Time) []jira.Issue {
 		}
 	}
 
-	return nextWeekIssues
+	return nextWeekIssues, nil
 }
 
-func createPersonalWeeklyReport(member Member, now time.Time) {
-	var pageBody bytes.Buffer
-	formatHeadLineHtmlOutput(&pageBody, "h2", "Works of this week")
+// PersonalWeeklyReport is the data of the weekly-personal.confluence.tmpl template.
+type PersonalWeeklyReport struct {
+	Member Member
+	// Works are grouped by the issue type, the epics come first.
+	Works    []WeeklyIssueGroup
+	NextWeek []WeeklyIssue
+}
 
-	jiraIssues := queryJiraIssuesWithOptions(fmt.Sprintf(`assignee = "%s" AND %s`, member.Email, config.Jira.WeeklyPersonalIssues), &allFieldsOpts)
+type WeeklyIssueGroup struct {
+	Type   string
+	Issues []WeeklyIssue
+}
+
+// WeeklyIssue is a Jira issue on the personal weekly page.
+type WeeklyIssue struct {
+	Key     string
+	Summary string
+	Type    string
+	// Assignee is the email of the assignee.
+	Assignee string
+	Done     bool
+	// Progress is the assignee if it's not the assignee of the epic,
+	// followed by the latest worklog comment of this week.
+	Progress string
+	// Children are the issues in the epic.
+	Children []WeeklyIssue
+}
+
+func newPersonalWeeklyReport(member Member, now time.Time) (*PersonalWeeklyReport, error) {
+	report := &PersonalWeeklyReport{Member: member}
+
+	jiraIssues, err := queryJiraIssuesWithOptions(fmt.Sprintf(`assignee = "%s" AND %s`, member.Email, config.Jira.WeeklyPersonalIssues), &allFieldsOpts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	repeatChecker := make(IssueRepeatChecker)
 	collectIssueMap := collectEpicJiraIssue(jiraIssues)
-	// first format epic, to make up repeatChecker.
-	epicIssues := collectIssueMap["epic"]
-	if epicIssues != nil {
-		formatHeadLineHtmlOutput(&pageBody, "h3", "Epic")
-		formatUnorderedListIssuesForHtmlOutput(&pageBody, nil, *epicIssues, repeatChecker)
-		pageBody.WriteString("<br/>")
+	// first collect epic, to make up repeatChecker.
+	epics := collectIssueMap["epic"]
+	if epics != nil {
+		epicIssues, err := fetchEpicIssues(*epics)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		issues, err := newWeeklyIssues(nil, *epics, repeatChecker, epicIssues)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		report.Works = append(report.Works, WeeklyIssueGroup{Type: "Epic", Issues: issues})
 	}
 
-	for issueType, issues := range collectIssueMap {
+	issueTypes := make([]string, 0, len(collectIssueMap))
+	for issueType := range collectIssueMap {
+		issueTypes = append(issueTypes, issueType)
+	}
+	sort.Strings(issueTypes)
+	for _, issueType := range issueTypes {
+		issues := collectIssueMap[issueType]
 		if issueType == "epic" || (issues != nil && len(*issues) == 0) {
 			continue
 		}
 		issueArr := *issues
-		formatHeadLineHtmlOutput(&pageBody, "h3", issueArr[0].Fields.Type.Name)
-		formatUnorderedListIssuesForHtmlOutput(&pageBody, nil, issueArr, repeatChecker)
-		pageBody.WriteString("<br/>")
+		weeklyIssues, err := newWeeklyIssues(nil, issueArr, repeatChecker, nil)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		report.Works = append(report.Works, WeeklyIssueGroup{Type: issueArr[0].Fields.Type.Name, Issues: weeklyIssues})
 	}
 
 	// Next week work plans
-	formatHeadLineHtmlOutput(&pageBody, "h2", "Next week plans")
-
-	nextWeekIssues := findNextWeekIssues(member, now)
+	nextWeekIssues, err := findNextWeekIssues(member, now)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	repeatChecker = make(IssueRepeatChecker)
-	formatNextWeekPlansForHtmlOutput(&pageBody, nextWeekIssues, repeatChecker)
+	for idx := range nextWeekIssues {
+		if repeatChecker.Check(nextWeekIssues[idx].Key) {
+			continue
+		}
+		report.NextWeek = append(report.NextWeek, newWeeklyIssue(nil, &nextWeekIssues[idx]))
+	}
+
+	return report, nil
+}
+
+// genPersonalWeeklyReport generates the body of the member's weekly page.
+func genPersonalWeeklyReport(member Member, now time.Time) (string, error) {
+	report, err := newPersonalWeeklyReport(member, now)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return renderPersonalWeeklyReport(report)
+}
+
+func renderPersonalWeeklyReport(report *PersonalWeeklyReport) (string, error) {
+	var pageBody bytes.Buffer
+	if err := executeTemplate(&pageBody, report, "", "weekly-personal.confluence.tmpl"); err != nil {
+		return "", errors.Trace(err)
+	}
+	return pageBody.String(), nil
+}
+
+func createPersonalWeeklyReport(team *Team, member Member, now time.Time) error {
+	body, err := genPersonalWeeklyReport(member, now)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return createPersonalWeeklyReportToConfluence(team, weeklyReportDate(now), member.Name, body)
+}
 
-	// create a new confluence page.
-	//fmt.Println(pageBody.String())
-	date := fmt.Sprintf("%s ~ %s", now.AddDate(0, 0, -6).Format("2006/01/02"), now.Format("2006/01/02"))
-	createPersonalWeeklyReportToConfluence(date, member.Name, pageBody.String())
+func weeklyReportDate(now time.Time) string {
+	return fmt.Sprintf("%s ~ %s", now.AddDate(0, 0, -6).Format("2006/01/02"), now.Format("2006/01/02"))
 }
 
 func runWeeklyReportCommandFunc(cmd *cobra.Command, args []string) {
-	now := time.Now()
+	now := reportClock()
+	summary := newRunSummary("weekly report")
+
+	for _, team := range teams {
+		// Fetch concurrently, but create the pages one by one in the member order.
+		reports := make([]*PersonalWeeklyReport, len(team.Members))
+		overdue := make([][]jira.Issue, len(team.Members))
+		errs := make([]error, len(team.Members))
+		overdueErrs := make([]error, len(team.Members))
+		runParallel(len(team.Members), func(idx int) {
+			reports[idx], errs[idx] = newPersonalWeeklyReport(team.Members[idx], now)
+			overdue[idx], overdueErrs[idx] = findOverdueIssues(team.Members[idx])
+		})
+
+		for idx, member := range team.Members {
+			summary.Record(member.Name+" overdue issues", overdueErrs[idx])
+			if summary.Record(member.Name, errs[idx]) {
+				continue
+			}
+			body, err := renderPersonalWeeklyReport(reports[idx])
+			if !summary.Record(member.Name, err) {
+				err = createPersonalWeeklyReportToConfluence(team, weeklyReportDate(now), member.Name, body)
+				summary.Record(member.Name, err)
+			}
+		}
 
-	for _, team := range config.Teams {
-		for _, member := range team.Members {
-			createPersonalWeeklyReport(member, now)
+		rollup := newTeamWeeklyReport(team, weeklyReportDate(now), reports, overdue, now)
+		if config.Confluence.ReviewLatency {
+			var err error
+			rollup.ReviewLatency, err = collectReviewMetrics(team, now.AddDate(0, 0, -6).Format(dayFormat), now.Format(dayFormat), summary)
+			summary.Record(team.Name+" review latency", err)
 		}
+		summary.Record(team.Name+" rollup", updateTeamWeeklyReportToConfluence(team, rollup))
+		summary.Record(team.Name+" snapshot", saveDataSnapshot(team, snapshotKindWeeklyTeam, rollup, weeklySnapshotMetrics(rollup)))
 	}
+
+	summary.Exit()
 }
 
 func runWeelyDeadLineReportCommandFunc(cmd *cobra.Command, args []string) {
-	//boardID := getBoardID(config.Jira.Project, "scrum")
-	//lastSprint := getLatestPassedSprint(boardID)
-	//nextSprint := getNearestFutureSprint(boardID)
+	summary := newRunSummary("weekly dead-line-report")
+	for _, team := range teams {
+		title, body, charts := genWeeklyDeadLineReport(team, summary)
+		c, err := createWeeklyDueDateReport(team, title, body)
+		if !summary.Record(title, err) && len(charts) > 0 {
+			summary.Record(title+" charts", uploadCharts(c.Id, charts))
+		}
+	}
+	summary.Exit()
+}
 
+// genWeeklyDeadLineReport generates the team's weekly page, with the review latency
+// and the charts if config.Confluence.ReviewLatency and config.Confluence.Charts
+// are set, the failed sections are recorded to summary and skipped.
+func genWeeklyDeadLineReport(team *Team, summary *RunSummary) (string, string, []*Chart) {
 	var body bytes.Buffer
 
-	//startDate := lastSprint.StartDate.Format(dayFormat)
-	//endDate := lastSprint.EndDate.Format(dayFormat)
-	//
-	//githubStartDate := lastSprint.StartDate.UTC().Format(githubUTCDateFormat)
-	//githubEndDate := lastSprint.EndDate.UTC().Format(githubUTCDateFormat)
-
 	formatPageBeginForHtmlOutput(&body)
 	genWeeklyReportToc(&body)
-	genWeeklyReportDuedate(&body)
-	//genWeeklyReportIssuesPRs(&body, githubStartDate, githubEndDate)
-
-	//for _, team := range config.Teams {
-	//	fmt.Println(team.Name)
-	//	formatSectionBeginForHtmlOutput(&body)
-	//	body.WriteString(fmt.Sprintf("<h1>%s Team</h1>", team.Name))
-	//	for _, m := range team.Members {
-	//		genWeeklyUserPage(&body, m, *lastSprint, *nextSprint)
-	//	}
-	//	formatSectionEndForHtmlOutput(&body)
-	//}
+	genWeeklyReportDuedate(&body, team)
+	now := reportClock()
+	if config.Confluence.ReviewLatency {
+		summary.Record(team.Name+" review latency", genWeeklyReportReviewLatency(&body, team,
+			now.AddDate(0, 0, -6).Format(dayFormat), now.Format(dayFormat), summary))
+	}
+	var charts []*Chart
+	if config.Confluence.Charts {
+		charts = collectWeeklyCharts(team, now, summary)
+		genWeeklyReportCharts(&body, charts)
+	}
 
 	formatPageEndForHtmlOutput(&body)
 
-	now := time.Now()
-	title := fmt.Sprintf("%s SQL-Infra Due Dates", now.Format("2006-01-02"))
-	createWeeklyDueDateReport(title, body.String())
+	title := fmt.Sprintf("%s %s Due Dates", now.Format("2006-01-02"), team.Name)
+	return title, body.String(), charts
 }
 
 func runRotateSprintCommandFunc(cmd *cobra.Command, args []string) {
-	boardID := getBoardID(config.Jira.Project, "scrum")
-	activeSprint := getActiveSprint(boardID)
-	nextSprint := createNextSprint(boardID, *activeSprint.EndDate)
-
-	pendingIssues := queryJiraIssues(
-		fmt.Sprintf("project = %s and Sprint = %d and statusCategory != Done",
-			config.Jira.Project, activeSprint.ID,
-		))
-	// Close the old sprint.
-	updateSprintState(activeSprint.ID, "closed")
-	// Move issues to the next sprint.
-	moveIssuesToSprint(nextSprint.ID, pendingIssues)
-	// Active the next sprint.
-	updateSprintState(nextSprint.ID, "active")
-	sendToSlack("Current active Sprint %s is closed", activeSprint.Name)
-}
-
-func genWeeklyUserPage(buf *bytes.Buffer, m Member, curSprint jira.Sprint, nextSprint jira.Sprint) {
-	sprintID := curSprint.ID
-	nextSprintID := nextSprint.ID
-
-	html := `
-<ac:structured-macro ac:name="jira">
-  <ac:parameter ac:name="columns">key,summary,created,updated,priority,status</ac:parameter>
-  <ac:parameter ac:name="server">%s</ac:parameter>
-  <ac:parameter ac:name="serverId">%s</ac:parameter>
-  <ac:parameter ac:name="jqlQuery">project = TIKV AND sprint = %d AND assignee = "%s"</ac:parameter>
-</ac:structured-macro>
-`
-
-	buf.WriteString(fmt.Sprintf("\n<h2>%s</h2>\n", m.Name))
-	buf.WriteString("\n<h3>Work</h3>\n")
-	buf.WriteString(fmt.Sprintf(html, config.Jira.Server, config.Jira.ServerID, sprintID, m.Email))
-	genReviewPullRequests(buf, m.Github, curSprint.StartDate.Format(dayFormat), curSprint.EndDate.Format(dayFormat))
-	if nextSprintID > 0 {
-		buf.WriteString("\n<h3>Next Week</h3>\n")
-		buf.WriteString(fmt.Sprintf(html, config.Jira.Server, config.Jira.ServerID, nextSprintID, m.Email))
+	summary := newRunSummary("weekly rotate-sprint")
+	rotation, err := rotateSprint(reportClock())
+	if !summary.Record("rotate sprint", err) {
+		if rotation == nil {
+			fmt.Println("the active sprint doesn't end yet, nothing to rotate")
+		} else {
+			report := newSprintRotationReport(rotation)
+			if config.Confluence.Charts {
+				report.AddSection("Burndown", "", publishSprintBurndowns(rotation.Closed, summary))
+			}
+			summary.Record("output", outputReport(config.Slack.Channel, report))
+		}
 	}
+	summary.Exit()
 }
 
-func genReviewPullRequests(buf *bytes.Buffer, user, start, end string) {
-	buf.WriteString("<h3>Review PR</h3>")
-	issues := getReviewPullRequests(user, start, &end)
-	formatGitHubIssuesForHtmlOutput(buf, issues)
-}
-
-func genWeeklyReportDuedate(buf *bytes.Buffer) {
+func genWeeklyReportDuedate(buf *bytes.Buffer, team *Team) {
 	formatSectionBeginForHtmlOutput(buf)
 
 	buf.WriteString("\n<h1>Issues Exceed Due Date</h1>\n")
-	stopStatus := `"Job Closed",Closed,"CAN'T REPRODUCE",Paused,Blocked,完成,TODO,"To Do"`
 
 	html := `
 <ac:structured-macro ac:name="jira">
@@ -264,8 +351,8 @@ func genWeeklyReportDuedate(buf *bytes.Buffer) {
 </ac:structured-macro>
 `
 
-	for _, member := range allSQLInfraMemberEmals {
-		jqlQuery := fmt.Sprintf(`assignee = %v AND duedate &lt; now() AND status not in (%s)`, member, stopStatus)
+	for _, member := range team.QuotedEmails() {
+		jqlQuery := strings.Replace(overdueIssuesJQL(member), "<", "&lt;", -1)
 		buf.WriteString(fmt.Sprintf(html, config.Jira.Server, config.Jira.ServerID, jqlQuery))
 	}
 
@@ -284,49 +371,91 @@ func genWeeklyReportDuedate(buf *bytes.Buffer) {
 	formatSectionEndForHtmlOutput(buf)
 }
 
-func genWeeklyReportIssuesPRs(buf *bytes.Buffer, start, end string) {
+// genWeeklyReportReviewLatency adds the review latency of the pull requests created in [start, end].
+func genWeeklyReportReviewLatency(buf *bytes.Buffer, team *Team, start, end string, summary *RunSummary) error {
+	metrics, err := collectReviewMetrics(team, start, end, summary)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var section bytes.Buffer
+	if err = renderReviewMetrics(&section, reportFormatConfluence, metrics); err != nil {
+		return errors.Trace(err)
+	}
 	formatSectionBeginForHtmlOutput(buf)
-	issues := getCreatedIssues(start, &end)
-	buf.WriteString("\n<h1>New Issues</h1>\n")
-	buf.WriteString(fmt.Sprintf("\n<blockquote>New GitHub issues (created: %s..%s)</blockquote>\n", start, end))
-	formatGitHubIssuesForHtmlOutput(buf, issues)
+	buf.Write(section.Bytes())
 	formatSectionEndForHtmlOutput(buf)
+	return nil
 }
 
-func createWeeklyDueDateReport(title string, value string) {
+func createWeeklyDueDateReport(team *Team, title string, value string) (Content, error) {
 	space := config.Confluence.Space
-	c := getContentByTitle(space, title)
+	c, err := getContentByTitle(space, title)
+	if err != nil {
+		return Content{}, errors.Trace(err)
+	}
 
 	if c.Id != "" {
-		c = updateContent(c, value)
+		c, err = updateContent(c, value)
 	} else {
-		parent := getContentByTitle(space, config.Confluence.WeeklyDueDatePath)
-		c = createContent(space, parent.Id, title, value)
+		var parent Content
+		parent, err = getContentByTitle(space, team.WeeklyDueDatePath)
+		if err != nil {
+			return Content{}, errors.Trace(err)
+		}
+		c, err = createContent(space, parent.Id, title, value)
+	}
+	if err != nil {
+		return Content{}, errors.Trace(err)
 	}
 
 	//sendToSlack("Weekly report for sprint %s is generated: %s%s", title, config.Confluence.Endpoint, c.Links.WebUI)
+	return c, errors.Trace(applyPageSettings(c, team, pageKindWeeklyDueDate, ""))
 }
 
-func createConfluencePath(space string, title string) {
-	c := getContentByTitle(space, title)
+func createConfluencePath(space string, parentTitle string, title string) error {
+	c, err := getContentByTitle(space, title)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	if c.Id != "" {
 		// path is exists.
-		return
+		return nil
 	}
-	parent := getContentByTitle(space, config.Confluence.WeeklyPath)
-	c = createContent(space, parent.Id, title, "")
+	parent, err := getContentByTitle(space, parentTitle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = createContent(space, parent.Id, title, "")
+	return errors.Trace(err)
 }
 
-func createPersonalWeeklyReportToConfluence(date string, name string, body string) {
+func createPersonalWeeklyReportToConfluence(team *Team, date string, name string, body string) error {
 	space := config.Confluence.Space
 	personalReportTitle := name + " " + date
-	c := getContentByTitle(space, personalReportTitle)
+	c, err := getContentByTitle(space, personalReportTitle)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	if c.Id != "" {
-		// c = updateContent(c, body)
-	} else {
-		// create this week's path.
-		createConfluencePath(space, date)
-		parent := getContentByTitle(space, date)
-		c = createContent(space, parent.Id, personalReportTitle, body)
+		c, err = mergeGeneratedContent(c, body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(applyPageSettings(c, team, pageKindWeeklyPersonal, name))
+	}
+
+	// create this week's path.
+	datePageTitle := team.datePageTitle(date)
+	if err = createConfluencePath(space, team.WeeklyPath, datePageTitle); err != nil {
+		return errors.Trace(err)
+	}
+	parent, err := getContentByTitle(space, datePageTitle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c, err = createContent(space, parent.Id, personalReportTitle, body)
+	if err != nil {
+		return errors.Trace(err)
 	}
+	return errors.Trace(applyPageSettings(c, team, pageKindWeeklyPersonal, name))
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	jira "github.com/andygrunwald/go-jira"
+)
+
+func TestCreatePersonalWeeklyReport(t *testing.T) {
+	date := weeklyReportDate(testNow)
+	pageTitle := "Alice " + date
+	stale := regionBegin("works") + "<p>TIKV-99</p>" + regionEnd("works")
+
+	tests := []struct {
+		name string
+		// existing is the body of the existing page of Alice, there is none if empty.
+		existing string
+		labels   []string
+		contains []string
+		excludes []string
+		version  int
+		// otherTeam adds another team to the config.
+		otherTeam bool
+		// parent is the title of the parent page of a new page.
+		parent     string
+		wantLabels []string
+		// previous is kept under legacyContentHeading.
+		previous string
+	}{
+		{
+			name:     "new page",
+			contains: []string{"Works of this week", "TIKV-10", "TIKV-11", "TIKV-12", "Next week plans", "TIKV-13"},
+			version:  1,
+			parent:   date,
+		},
+		{
+			name:      "date page of the team",
+			otherTeam: true,
+			contains:  []string{"TIKV-10"},
+			version:   1,
+			parent:    "Team " + date,
+		},
+		{
+			name:     "existing page keeps the notes",
+			existing: stale + "<p>notes by hand</p>",
+			contains: []string{"TIKV-10", "TIKV-13", "<p>notes by hand</p>"},
+			excludes: []string{"TIKV-99"},
+			version:  2,
+		},
+		{
+			name:     "legacy page without regions",
+			existing: "<h2>Works of this week</h2><p>TIKV-99</p><p>notes by hand</p>",
+			contains: []string{"TIKV-10", regionBegin("works")},
+			version:  2,
+			previous: "<h2>Works of this week</h2><p>TIKV-99</p><p>notes by hand</p>",
+		},
+		{
+			name:       "labels",
+			labels:     []string{"{team}", "{kind}", "{member}", "{week}"},
+			contains:   []string{"TIKV-12"},
+			version:    1,
+			parent:     date,
+			wantLabels: []string{"team", "weekly-personal", "alice", "2026-w42"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, jr, cf, _ := setupFakes(t)
+			config.Confluence.Labels = tt.labels
+			if tt.otherTeam {
+				config.Teams = append(config.Teams, Team{Name: "Other"})
+			}
+			team := teams[0]
+			alice := team.Members[0]
+
+			epic := testJiraIssue("TIKV-10", "TTL", alice.Email, "In Progress", "indeterminate")
+			epic.Fields.Type.Name = "Epic"
+			jr.Issues[fmt.Sprintf(`assignee = "%s" AND %s`, alice.Email, config.Jira.WeeklyPersonalIssues)] = []jira.Issue{
+				epic,
+				testJiraIssue("TIKV-12", "Docs", alice.Email, "Done", "done"),
+			}
+			jr.Issues[fmt.Sprintf(`"Epic Link" = TIKV-10 AND %s`, config.Jira.WeeklyPersonalIssues)] = []jira.Issue{
+				testJiraIssue("TIKV-11", "TTL in the planner", alice.Email, "In Progress", "indeterminate"),
+			}
+			jr.Issues[fmt.Sprintf(`assignee = "%s" AND duedate <= 7d AND %s`, alice.Email, config.Jira.inProgressJQL())] = []jira.Issue{
+				testJiraIssue("TIKV-13", "Release", alice.Email, "In Progress", "indeterminate"),
+			}
+
+			if _, err := createContent(config.Confluence.Space, "", team.WeeklyPath, ""); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.existing) > 0 {
+				if _, err := createContent(config.Confluence.Space, "", pageTitle, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := createPersonalWeeklyReport(team, alice, testNow); err != nil {
+				t.Fatal(err)
+			}
+
+			page, _ := cf.GetContentByTitle(config.Confluence.Space, pageTitle)
+			if len(page.Id) == 0 {
+				t.Fatalf("page %q is not created", pageTitle)
+			}
+			body := page.Body.Storage.Value
+			if len(tt.previous) > 0 {
+				// A re-run keeps the previous content once, the page isn't changed.
+				if err := createPersonalWeeklyReport(team, alice, testNow); err != nil {
+					t.Fatal(err)
+				}
+				page, _ = cf.GetContentByTitle(config.Confluence.Space, pageTitle)
+				parts := strings.Split(page.Body.Storage.Value, legacyContentHeading)
+				if len(parts) != 2 || parts[1] != tt.previous {
+					t.Fatalf("previous content %q, want %q once", parts[1:], tt.previous)
+				}
+				body = parts[0]
+			} else if strings.Contains(body, legacyContentHeading) {
+				t.Errorf("page has the previous content:\n%s", body)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(body, want) {
+					t.Errorf("page doesn't contain %q:\n%s", want, body)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("page contains %q:\n%s", unwanted, body)
+				}
+			}
+			if strings.Count(body, "Works of this week") != 1 {
+				t.Errorf("the works are not there once:\n%s", body)
+			}
+			if strings.Index(body, "Works of this week") > strings.Index(body, "Next week plans") {
+				t.Errorf("the works are after the next week plans:\n%s", body)
+			}
+			if page.Version.Number != tt.version {
+				t.Errorf("page version %d, want %d", page.Version.Number, tt.version)
+			}
+			if len(tt.parent) > 0 {
+				parent, _ := cf.GetContentByTitle(config.Confluence.Space, tt.parent)
+				if got := page.Ancestors[len(page.Ancestors)-1].Id; len(parent.Id) == 0 || got != parent.Id {
+					t.Errorf("page is under %q, want %q (%s)", got, tt.parent, parent.Id)
+				}
+			}
+			if got := strings.Join(cf.Labels[page.Id], ","); got != strings.Join(tt.wantLabels, ",") {
+				t.Errorf("labels %q, want %q", got, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestOverdueIssuesJQL(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []string
+		categories []string
+		want       string
+	}{
+		{
+			name: "default",
+			want: `assignee = "alice@example.com" AND duedate < now() AND (statusCategory in ("indeterminate"))`,
+		},
+		{
+			name:     "statuses",
+			statuses: []string{"In Progress", "In Review"},
+			want:     `assignee = "alice@example.com" AND duedate < now() AND (status in ("In Progress","In Review"))`,
+		},
+		{
+			name:       "statuses and categories",
+			statuses:   []string{"Blocked"},
+			categories: []string{"indeterminate"},
+			want:       `assignee = "alice@example.com" AND duedate < now() AND (status in ("Blocked") OR statusCategory in ("indeterminate"))`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakes(t)
+			config.Jira.InProgressStatuses = tt.statuses
+			config.Jira.InProgressStatusCategories = tt.categories
+			config.Jira.adjust()
+			if got := overdueIssuesJQL(`"alice@example.com"`); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeeklyReviewLatency(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			gh, _, cf, _ := setupFakes(t)
+			config.Confluence.ReviewLatency = enabled
+			if _, err := createContent(config.Confluence.Space, "", config.Confluence.WeeklyPath, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			runWeeklyReportCommandFunc(nil, nil)
+			page, _ := cf.GetContentByTitle(config.Confluence.Space, weeklyReportDate(testNow))
+			if got := strings.Contains(page.Body.Storage.Value, "Review Latency"); got != enabled {
+				t.Errorf("the date page has the review latency %v:\n%s", got, page.Body.Storage.Value)
+			}
+
+			summary := newRunSummary("weekly dead-line-report")
+			_, body, _ := genWeeklyDeadLineReport(teams[0], summary)
+			if got := strings.Contains(body, "Pull Request Review Latency"); got != enabled {
+				t.Errorf("the dead-line page has the review latency %v:\n%s", got, body)
+			}
+			if summary.Failed() {
+				t.Errorf("failed: %s", summary)
+			}
+			if enabled != (len(gh.Queries) > 0) {
+				t.Errorf("searched %q", gh.Queries)
+			}
+		})
+	}
+}
+
+func TestWeeklyReportDryRun(t *testing.T) {
+	_, _, cf, _ := setupFakes(t)
+	var output bytes.Buffer
+	oldOutput := dryRunOutput
+	dryRunOutput = &output
+	defer func() {
+		dryRunOutput = oldOutput
+	}()
+	if _, err := createContent(config.Confluence.Space, "", config.Confluence.WeeklyPath, ""); err != nil {
+		t.Fatal(err)
+	}
+	dryRun = true
+	useDryRun()
+
+	// A new week, none of the pages exists.
+	runWeeklyReportCommandFunc(nil, nil)
+	date := weeklyReportDate(testNow)
+	if !strings.Contains(output.String(), fmt.Sprintf("create page %q", "Alice "+date)) ||
+		!strings.Contains(output.String(), "work-reporter-summary-begin") {
+		t.Errorf("the dry run doesn't create the pages with the rollup:\n%s", output.String())
+	}
+	if strings.Contains(output.String(), `update page ""`) {
+		t.Errorf("the dry run updates a page without ID:\n%s", output.String())
+	}
+	if len(cf.Contents) != 1 {
+		t.Errorf("the dry run created %d pages", len(cf.Contents)-1)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+	"github.com/juju/errors"
+)
+
+// overdueIssuesJQL matches the overdue issues of the quoted email, only the
+// in progress issues of config.Jira can be overdue.
+func overdueIssuesJQL(member string) string {
+	return fmt.Sprintf(`assignee = %v AND duedate < now() AND %s`, member, config.Jira.inProgressJQL())
+}
+
+func findOverdueIssues(member Member) ([]jira.Issue, error) {
+	issues, err := queryJiraIssuesWithOptions(overdueIssuesJQL(strconv.Quote(member.Email)), &allFieldsOpts)
+	return issues, errors.Trace(err)
+}
+
+// TeamWeeklyReport is the data of the weekly-team.confluence.tmpl template,
+// the rollup of the personal weekly pages on the team's date page.
+type TeamWeeklyReport struct {
+	Team    string
+	Date    string
+	Members []TeamWeeklyMember
+	Types   []TeamWeeklyType
+	Overdue []WeeklyOverdueIssue
+	// ReviewLatency is nil unless config.Confluence.ReviewLatency is set.
+	ReviewLatency *ReviewMetrics
+}
+
+type TeamWeeklyMember struct {
+	Name string
+	// Page is the title of the member's weekly page.
+	Page       string
+	Done       int
+	InProgress int
+	NextWeek   int
+	Overdue    int
+}
+
+// TeamWeeklyType counts the issues of the team by the issue type.
+type TeamWeeklyType struct {
+	Type       string
+	Done       int
+	InProgress int
+}
+
+type WeeklyOverdueIssue struct {
+	Key      string
+	Summary  string
+	Assignee string
+	DueDate  time.Time
+	Days     int
+}
+
+// memberWeeklyIssues returns the issues assigned to the member, the epics are
+// replaced by the issues in them.
+func memberWeeklyIssues(issues []WeeklyIssue, member Member) []WeeklyIssue {
+	var result []WeeklyIssue
+	for _, issue := range issues {
+		if len(issue.Children) > 0 {
+			result = append(result, memberWeeklyIssues(issue.Children, member)...)
+			continue
+		}
+		if strings.EqualFold(issue.Assignee, member.Email) {
+			result = append(result, issue)
+		}
+	}
+	return result
+}
+
+// newTeamWeeklyReport sums up the personal reports of the members, the members
+// whose report is nil are skipped.
+func newTeamWeeklyReport(team *Team, date string, reports []*PersonalWeeklyReport, overdue [][]jira.Issue, now time.Time) *TeamWeeklyReport {
+	rollup := &TeamWeeklyReport{Team: team.Name, Date: date}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	types := make(map[string]*TeamWeeklyType)
+	for idx, member := range team.Members {
+		report := reports[idx]
+		if report == nil {
+			continue
+		}
+		m := TeamWeeklyMember{
+			Name:     member.Name,
+			Page:     member.Name + " " + date,
+			NextWeek: len(report.NextWeek),
+			Overdue:  len(overdue[idx]),
+		}
+		for _, group := range report.Works {
+			for _, issue := range memberWeeklyIssues(group.Issues, member) {
+				t, ok := types[issue.Type]
+				if !ok {
+					t = &TeamWeeklyType{Type: issue.Type}
+					types[issue.Type] = t
+				}
+				if issue.Done {
+					m.Done++
+					t.Done++
+				} else {
+					m.InProgress++
+					t.InProgress++
+				}
+			}
+		}
+		rollup.Members = append(rollup.Members, m)
+
+		for _, issue := range overdue[idx] {
+			// The due date is a day in UTC, compare it with today as a day.
+			dueDate := time.Time(issue.Fields.Duedate)
+			dueDay := time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 0, 0, 0, 0, now.Location())
+			rollup.Overdue = append(rollup.Overdue, WeeklyOverdueIssue{
+				Key:      issue.Key,
+				Summary:  issue.Fields.Summary,
+				Assignee: member.Name,
+				DueDate:  dueDate,
+				Days:     int(today.Sub(dueDay).Hours()/24 + 0.5),
+			})
+		}
+	}
+
+	for _, t := range types {
+		rollup.Types = append(rollup.Types, *t)
+	}
+	sort.Slice(rollup.Types, func(i, j int) bool { return rollup.Types[i].Type < rollup.Types[j].Type })
+	// The most overdue first.
+	sort.SliceStable(rollup.Overdue, func(i, j int) bool { return rollup.Overdue[i].Days > rollup.Overdue[j].Days })
+	return rollup
+}
+
+// Total sums up the members.
+func (r *TeamWeeklyReport) Total() TeamWeeklyMember {
+	total := TeamWeeklyMember{Name: "Total"}
+	for _, m := range r.Members {
+		total.Done += m.Done
+		total.InProgress += m.InProgress
+		total.NextWeek += m.NextWeek
+		total.Overdue += m.Overdue
+	}
+	return total
+}
+
+// updateTeamWeeklyReportToConfluence fills the team's date page with the rollup,
+// only the generated regions are replaced if the page exists. The page is
+// created with the rollup if it doesn't exist, e.g. in the dry run, where the
+// date page created for the personal pages isn't stored.
+func updateTeamWeeklyReportToConfluence(team *Team, rollup *TeamWeeklyReport) error {
+	var body bytes.Buffer
+	if err := executeTemplate(&body, rollup, "", "weekly-team.confluence.tmpl"); err != nil {
+		return errors.Trace(err)
+	}
+
+	space := config.Confluence.Space
+	datePageTitle := team.datePageTitle(rollup.Date)
+	c, err := getContentByTitle(space, datePageTitle)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if c.Id != "" {
+		c, err = mergeGeneratedContent(c, body.String())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(applyPageSettings(c, team, pageKindWeeklyTeam, ""))
+	}
+
+	parent, err := getContentByTitle(space, team.WeeklyPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c, err = createContent(space, parent.Id, datePageTitle, body.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(applyPageSettings(c, team, pageKindWeeklyTeam, ""))
+}